@@ -0,0 +1,118 @@
+package starquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// CheckAccess confirms the GitHub token can read every tracked repo,
+// e.g. that a fine-grained token was granted each of them with the
+// metadata permission. Otherwise fetches quietly find no stargazers.
+// Inaccessible repos are logged and reported by the health endpoint
+// until they're next fetched, and the returned error lists them.
+func (a *API) CheckAccess(ctx context.Context) error {
+	var errs []error
+	for _, repo := range a.Repos() {
+		err := a.checkRepoAccess(ctx, repo)
+		if err != nil {
+			a.logger.ErrorContext(ctx, "github token cannot read repo", "repo", repo, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", repo, err))
+		}
+		a.setRepoError(repo, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("github token cannot read %d of %d repos: %w", len(errs), len(a.Repos()), errors.Join(errs...))
+	}
+	return nil
+}
+
+// checkRepoAccess checks the repo can be read with the API the fetch
+// strategy uses.
+func (a *API) checkRepoAccess(ctx context.Context, repo Repo) error {
+	switch a.fetchStrategy {
+	case FetchREST:
+		return a.checkRepoAccessREST(ctx, repo)
+	case FetchAuto:
+		err := a.checkRepoAccessGraphQL(ctx, repo)
+		if err == nil || !graphQLUnavailable(err) {
+			return err
+		}
+		return a.checkRepoAccessREST(ctx, repo)
+	default:
+		return a.checkRepoAccessGraphQL(ctx, repo)
+	}
+}
+
+// checkRepoAccessGraphQL looks up the repo's ID with the GraphQL API.
+func (a *API) checkRepoAccessGraphQL(ctx context.Context, repo Repo) error {
+	reqBody, err := json.Marshal(map[string]any{
+		"query": `
+	query($owner: String!, $name: String!) {
+		repository(owner: $owner, name: $name) {
+			id
+		}
+	}`,
+		"variables": map[string]string{
+			"owner": repo.Owner,
+			"name":  repo.Name,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal query: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.graphQLTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.github.com/graphql", bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", a.userAgent)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+	defer closeOnDone(ctx, resp.Body)()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp.StatusCode)
+	}
+
+	var response struct {
+		Data struct {
+			Repository *struct {
+				ID string `json:"id"`
+			} `json:"repository"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	if err := repositoryError(response.Errors, resp.Header.Get("X-OAuth-Scopes")); err != nil {
+		return err
+	}
+	if response.Data.Repository == nil {
+		return ErrRepoNotFound
+	}
+	return nil
+}
+
+// checkRepoAccessREST gets the repo with the REST API.
+func (a *API) checkRepoAccessREST(ctx context.Context, repo Repo) error {
+	_, resp, err := a.github.Repositories.Get(ctx, repo.Owner, repo.Name)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return ErrRepoNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("get repo: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAccess(t *testing.T) {
+	t.Parallel()
+
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				var body struct {
+					Variables struct {
+						Name string `json:"name"`
+					} `json:"variables"`
+				}
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					return nil, err
+				}
+				// The token wasn't granted the private repo.
+				response := `{"data": {"repository": {"id": "R_1", "stargazers": {"edges": []}}, "rateLimit": {"remaining": 5000}}}`
+				if body.Variables.Name == "private" {
+					response = `{"data": {"repository": null}, "errors": [{"type": "NOT_FOUND", "message": "Could not resolve to a Repository with the name 'coder/private'."}]}`
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(response)),
+				}, nil
+			}),
+		},
+		KV:    kv.NewMemory(),
+		Repos: []starquery.Repo{{Owner: "coder", Name: "coder"}, {Owner: "coder", Name: "private"}},
+	})
+	defer api.Close()
+
+	err := api.CheckAccess(context.Background())
+	require.ErrorIs(t, err, starquery.ErrRepoNotFound)
+	require.ErrorContains(t, err, "coder/private")
+	require.NotContains(t, err.Error(), "coder/coder:")
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	var health struct {
+		Status string            `json:"status"`
+		Errors map[string]string `json:"errors"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&health))
+	require.Equal(t, "degraded", health.Status)
+	require.Equal(t, map[string]string{"coder/private": starquery.ErrRepoNotFound.Error()}, health.Errors)
+}
+
+func TestCheckAccessREST(t *testing.T) {
+	t.Parallel()
+
+	// A typo in the repo name, which GitHub answers with a 404.
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				status := http.StatusOK
+				if req.URL.Path == "/repos/coder/codeer" {
+					status = http.StatusNotFound
+				}
+				return &http.Response{
+					StatusCode: status,
+					Header:     http.Header{"Content-Type": {"application/json"}},
+					Body:       io.NopCloser(strings.NewReader(`{}`)),
+				}, nil
+			}),
+		},
+		FetchStrategy: starquery.FetchREST,
+		KV:            kv.NewMemory(),
+		Repos:         []starquery.Repo{{Owner: "coder", Name: "coder"}, {Owner: "coder", Name: "codeer"}},
+	})
+	defer api.Close()
+
+	err := api.CheckAccess(context.Background())
+	require.ErrorIs(t, err, starquery.ErrRepoNotFound)
+	require.ErrorContains(t, err, "1 of 2 repos")
+	require.ErrorContains(t, err, "coder/codeer")
+}
+
+func TestParseRepos(t *testing.T) {
+	t.Parallel()
+
+	repos, err := starquery.ParseRepos(" coder/coder, ,coder/code-server")
+	require.NoError(t, err)
+	require.Equal(t, []starquery.Repo{{Owner: "coder", Name: "coder"}, {Owner: "coder", Name: "code-server"}}, repos)
+
+	for _, invalid := range []string{"coder", "coder/", "/coder", "coder/coder/coder"} {
+		_, err := starquery.ParseRepos(invalid)
+		require.Error(t, err, invalid)
+	}
+}
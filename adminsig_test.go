@@ -0,0 +1,61 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedAdminRequests(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := kv.NewMemory()
+	api := starquery.New(ctx, starquery.Options{
+		KV:                  store,
+		WebhookSecret:       "secret",
+		SignedAdminRequests: true,
+	})
+	defer api.Close()
+
+	send := func(req *http.Request) int {
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		return res.Code
+	}
+	signed := func(secret string, now time.Time, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/coder/coder/import", strings.NewReader(body))
+		require.NoError(t, starquery.SignAdminRequest(req, secret, now))
+		return req
+	}
+
+	require.Equal(t, http.StatusOK, send(signed("secret", time.Now(), "kylecarbs")))
+	_, err := store.Get(ctx, starquery.Repo{Owner: "coder", Name: "coder"}.Key("kylecarbs"))
+	require.NoError(t, err, "the body is still read by the handler")
+
+	require.Equal(t, http.StatusUnauthorized, send(signed("wrong", time.Now(), "kylecarbs")), "wrong secret")
+	require.Equal(t, http.StatusUnauthorized, send(signed("secret", time.Now().Add(-time.Hour), "kylecarbs")), "stale timestamp")
+
+	req := signed("secret", time.Now(), "kylecarbs")
+	req.Body = http.NoBody
+	require.Equal(t, http.StatusUnauthorized, send(req), "tampered body")
+
+	req = signed("secret", time.Now(), "")
+	req.URL.Path = "/coder/other/import"
+	require.Equal(t, http.StatusUnauthorized, send(req), "tampered path")
+
+	req = httptest.NewRequest(http.MethodGet, "/coder/coder/export", nil)
+	require.Equal(t, http.StatusUnauthorized, send(req), "unsigned")
+	req.Header.Set("Authorization", "Bearer ")
+	require.Equal(t, http.StatusUnauthorized, send(req), "empty admin token")
+
+	_, err = starquery.NewWithError(ctx, starquery.Options{KV: kv.NewMemory(), SignedAdminRequests: true})
+	require.Error(t, err, "a webhook secret is required")
+}
@@ -0,0 +1,33 @@
+package starquery
+
+import (
+	"log/slog"
+)
+
+// AuditEvent describes a single star or unstar of a repo.
+type AuditEvent = StarChange
+
+// AuditLogger records star and unstar events to an append-only log.
+// Record is called synchronously from the webhook handler, so
+// implementations that write to slow sinks should buffer.
+type AuditLogger interface {
+	Record(event AuditEvent)
+}
+
+// NewSlogAuditLogger returns an AuditLogger that writes events to logger.
+func NewSlogAuditLogger(logger *slog.Logger) AuditLogger {
+	return &slogAuditLogger{logger: logger}
+}
+
+type slogAuditLogger struct {
+	logger *slog.Logger
+}
+
+func (l *slogAuditLogger) Record(event AuditEvent) {
+	l.logger.Info("star audit",
+		"repo", event.Repo.String(),
+		"user", event.Login,
+		"action", event.Action,
+		"time", event.Time,
+	)
+}
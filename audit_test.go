@@ -0,0 +1,79 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLogger(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Webhook", func(t *testing.T) {
+		t.Parallel()
+		audit := &recordingAuditLogger{}
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:            kv.NewMemory(),
+			WebhookSecret: "secret",
+			AuditLogger:   audit,
+		})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		for _, action := range []string{"created", "deleted"} {
+			req := generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", action))
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, req)
+			require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+		}
+
+		events := audit.Events()
+		require.Len(t, events, 2)
+		for i, action := range []string{"created", "deleted"} {
+			require.Equal(t, repo, events[i].Repo)
+			require.Equal(t, "kylecarbs", events[i].Login)
+			require.Equal(t, action, events[i].Action)
+			require.WithinDuration(t, time.Now(), events[i].Time, time.Minute)
+		}
+	})
+
+	t.Run("Slog", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		audit := starquery.NewSlogAuditLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+		audit.Record(starquery.AuditEvent{
+			Repo:   starquery.Repo{Owner: "coder", Name: "coder"},
+			Login:  "kylecarbs",
+			Action: "created",
+			Time:   time.Now(),
+		})
+		require.Contains(t, buf.String(), "repo=coder/coder")
+		require.Contains(t, buf.String(), "user=kylecarbs")
+		require.Contains(t, buf.String(), "action=created")
+	})
+}
+
+type recordingAuditLogger struct {
+	mu     sync.Mutex
+	events []starquery.AuditEvent
+}
+
+func (r *recordingAuditLogger) Record(event starquery.AuditEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingAuditLogger) Events() []starquery.AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]starquery.AuditEvent(nil), r.events...)
+}
@@ -0,0 +1,53 @@
+package starquery
+
+import "context"
+
+// DefaultMaxBackgroundTasks is the default limit on background tasks
+// running at once.
+const DefaultMaxBackgroundTasks = 32
+
+// acquireBackground blocks until a background task slot is free,
+// returning false if ctx is done first.
+func (a *API) acquireBackground(ctx context.Context) bool {
+	select {
+	case a.background <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (a *API) releaseBackground() {
+	<-a.background
+}
+
+// addTask adds a goroutine for Close to wait for. It returns false once
+// the API is closing, so tasks aren't added while Close waits.
+func (a *API) addTask() bool {
+	a.closeMu.Lock()
+	defer a.closeMu.Unlock()
+	if a.ctx.Err() != nil {
+		return false
+	}
+	a.wg.Add(1)
+	return true
+}
+
+// goBackground runs fn in a goroutine that Close waits for, once a
+// background task slot is free. It blocks until then, and returns false
+// without running fn if ctx is done or the API closes first.
+func (a *API) goBackground(ctx context.Context, fn func()) bool {
+	if !a.acquireBackground(ctx) {
+		return false
+	}
+	if !a.addTask() {
+		a.releaseBackground()
+		return false
+	}
+	go func() {
+		defer a.wg.Done()
+		defer a.releaseBackground()
+		fn()
+	}()
+	return true
+}
@@ -0,0 +1,66 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxBackgroundTasks(t *testing.T) {
+	t.Parallel()
+
+	const limit = 2
+	var running, maxRunning, calls atomic.Int64
+	release := make(chan struct{})
+	api := starquery.New(context.Background(), starquery.Options{
+		KV:            kv.NewMemory(),
+		WebhookSecret: "secret",
+		EventSink: func(context.Context, string, string, []byte) {
+			n := running.Add(1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			<-release
+			running.Add(-1)
+			calls.Add(1)
+		},
+		EventSinkAsync:     true,
+		MaxBackgroundTasks: limit,
+	})
+	defer api.Close()
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+
+	const webhooks = 10
+	var wg sync.WaitGroup
+	for range webhooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", "created")))
+			assert.Equal(t, http.StatusOK, res.Code)
+		}()
+	}
+
+	require.Eventually(t, func() bool {
+		return running.Load() == limit
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	require.Eventually(t, func() bool {
+		return calls.Load() == webhooks
+	}, time.Second, time.Millisecond)
+	require.LessOrEqual(t, maxRunning.Load(), int64(limit))
+}
@@ -0,0 +1,259 @@
+package starquery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/coder/starquery/kv"
+)
+
+// importBatchSize is the number of stargazers Import stores at once.
+const importBatchSize = 1000
+
+// Import stores the stargazers read from r for the repo without
+// fetching them from GitHub, e.g. to restore an Export after the store
+// was lost. The input is either a JSON array of logins or one login per
+// line. Imported stargazers expire like fetched ones. It returns the
+// number of stargazers imported.
+func (a *API) Import(ctx context.Context, repo Repo, r io.Reader) (int, error) {
+	logins, err := readLogins(r)
+	if err != nil {
+		return 0, err
+	}
+	if err := a.importLogins(ctx, repo, logins); err != nil {
+		return 0, err
+	}
+	return len(logins), nil
+}
+
+// readLogins reads a JSON array of logins or one login per line,
+// skipping blank lines.
+func readLogins(r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+	var lines []string
+	if first, err := peekNonSpace(br); err == nil && first == '[' {
+		if err := json.NewDecoder(br).Decode(&lines); err != nil {
+			return nil, fmt.Errorf("decode logins: %w", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(br)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read logins: %w", err)
+		}
+	}
+
+	logins := make([]string, 0, len(lines))
+	for _, login := range lines {
+		login = strings.TrimSpace(login)
+		if login == "" {
+			continue
+		}
+		if strings.Contains(login, "/") {
+			return nil, fmt.Errorf("invalid login %q", login)
+		}
+		logins = append(logins, login)
+	}
+	return logins, nil
+}
+
+// importLogins stores the logins as stargazers of the repo in batches.
+func (a *API) importLogins(ctx context.Context, repo Repo, logins []string) error {
+	for i := 0; i < len(logins); i += importBatchSize {
+		batch := make([]Stargazer, 0, importBatchSize)
+		for _, login := range logins[i:min(i+importBatchSize, len(logins))] {
+			batch = append(batch, Stargazer{Login: login})
+		}
+		if err := a.storeStargazers(ctx, repo, batch); err != nil {
+			return fmt.Errorf("store stargazers: %w", err)
+		}
+	}
+	return nil
+}
+
+// peekNonSpace returns the first byte of br that isn't whitespace
+// without consuming it.
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		_, _ = br.Discard(1)
+	}
+}
+
+// Export writes the stored stargazers of the repo to w, one login per
+// line in sorted order, in a format Import accepts. The store must
+// implement kv.Scanner, and keys must not be hashed.
+func (a *API) Export(ctx context.Context, repo Repo, w io.Writer) error {
+	logins, err := a.storedLogins(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, login := range logins {
+		if _, err := bw.WriteString(login + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// storedLogins returns the sorted logins of the repo's stored
+// stargazers. The store must implement kv.Scanner.
+func (a *API) storedLogins(ctx context.Context, repo Repo) ([]string, error) {
+	if a.hashKeys {
+		return nil, errHashedKeys
+	}
+	scanner, ok := a.kv.(kv.Scanner)
+	if !ok {
+		return nil, errors.New("store does not support listing keys")
+	}
+	prefix := a.key(repo, "")
+	keys, err := scanner.Keys(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+	logins := make([]string, 0, len(keys))
+	for _, key := range keys {
+		login := strings.TrimPrefix(key, prefix)
+		if login == "" || strings.Contains(login, "/") {
+			continue
+		}
+		logins = append(logins, login)
+	}
+	slices.Sort(logins)
+	return logins, nil
+}
+
+// requireAdmin only calls next for requests bearing the admin token,
+// or with SignedAdminRequests, signed with the webhook secret.
+func (a *API) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.signedAdminRequests && r.Header.Get(AdminSignatureHeader) != "" {
+			if err := a.verifyAdminSignature(r); err != nil {
+				http.Error(w, "invalid signature: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if a.adminToken == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// importResponse is returned by the import endpoint.
+type importResponse struct {
+	Imported int `json:"imported"`
+}
+
+// handleImport imports the stargazers in the request body.
+func (a *API) handleImport(w http.ResponseWriter, r *http.Request) {
+	if a.readOnly.Load() {
+		http.Error(w, "Read-only maintenance mode", http.StatusServiceUnavailable)
+		return
+	}
+	repo := Repo{Owner: r.PathValue("org"), Name: r.PathValue("repo")}
+	logins, err := readLogins(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := a.importLogins(r.Context(), repo, logins); err != nil {
+		a.writeStoreError(w, r, err)
+		return
+	}
+	a.logger.InfoContext(r.Context(), "imported stargazers", "repo", repo, "count", len(logins))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(importResponse{Imported: len(logins)})
+}
+
+// handleExport responds with the stored stargazers of the repo.
+func (a *API) handleExport(w http.ResponseWriter, r *http.Request) {
+	repo := Repo{Owner: r.PathValue("org"), Name: r.PathValue("repo")}
+	var buf bytes.Buffer
+	if err := a.Export(r.Context(), repo, &buf); err != nil {
+		a.writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = buf.WriteTo(w)
+}
+
+const (
+	// defaultStargazersPageSize is the page size of the stargazers
+	// endpoint when no limit is given.
+	defaultStargazersPageSize = 1000
+	// maxStargazersPageSize is the largest page size it allows.
+	maxStargazersPageSize = 10000
+)
+
+// stargazersResponse is a page of the stargazers endpoint.
+type stargazersResponse struct {
+	Stargazers []string `json:"stargazers"`
+	// Next is passed as "after" to get the next page. It's empty on
+	// the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// handleStargazers responds with a page of the repo's stored stargazers
+// sorted by login. Pages are keyed by the last login of the previous
+// page rather than a store cursor, so they stay stable while the store
+// changes: a login stored for the whole listing is returned exactly
+// once, while logins added or removed during it may or may not be.
+// Store listings aren't ordered, so each page lists and sorts all of
+// the repo's stored stargazers to find its place.
+func (a *API) handleStargazers(w http.ResponseWriter, r *http.Request) {
+	repo := Repo{Owner: r.PathValue("org"), Name: r.PathValue("repo")}
+	limit := defaultStargazersPageSize
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxStargazersPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxStargazersPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	logins, err := a.storedLogins(r.Context(), repo)
+	if err != nil {
+		a.writeStoreError(w, r, err)
+		return
+	}
+
+	start := 0
+	if after := strings.ToLower(r.URL.Query().Get("after")); after != "" {
+		var found bool
+		start, found = slices.BinarySearch(logins, after)
+		if found {
+			start++
+		}
+	}
+	resp := stargazersResponse{Stargazers: logins[start:min(start+limit, len(logins))]}
+	if start+limit < len(logins) {
+		resp.Next = resp.Stargazers[len(resp.Stargazers)-1]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
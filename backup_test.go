@@ -0,0 +1,150 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportExport(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{KV: store})
+		defer api.Close()
+
+		imported, err := api.Import(ctx, repo, strings.NewReader("user2\n\nuser1\n"))
+		require.NoError(t, err)
+		require.Equal(t, 2, imported)
+		imported, err = api.Import(ctx, repo, strings.NewReader(` ["user3"]`))
+		require.NoError(t, err)
+		require.Equal(t, 1, imported)
+		_, err = store.Get(ctx, repo.Key("user3"))
+		require.NoError(t, err)
+
+		// Stargazers of other repos aren't exported.
+		_, err = api.Import(ctx, starquery.Repo{Owner: "coder", Name: "coder-other"}, strings.NewReader("other"))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, api.Export(ctx, repo, &buf))
+		require.Equal(t, "user1\nuser2\nuser3\n", buf.String())
+
+		restored := starquery.New(ctx, starquery.Options{KV: kv.NewMemory()})
+		defer restored.Close()
+		imported, err = restored.Import(ctx, repo, &buf)
+		require.NoError(t, err)
+		require.Equal(t, 3, imported)
+	})
+
+	t.Run("InvalidLogin", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		api := starquery.New(ctx, starquery.Options{KV: kv.NewMemory()})
+		defer api.Close()
+		_, err := api.Import(ctx, repo, strings.NewReader("a/b"))
+		require.Error(t, err)
+	})
+
+	t.Run("Endpoints", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{KV: store, AdminToken: "token"})
+		defer api.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/coder/coder/import", strings.NewReader("kylecarbs"))
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code, "missing token")
+
+		req = httptest.NewRequest(http.MethodPost, "/coder/coder/import", strings.NewReader("kylecarbs"))
+		req.Header.Set("Authorization", "Bearer token")
+		res = httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.JSONEq(t, `{"imported":1}`, res.Body.String())
+		_, err := store.Get(ctx, repo.Key("kylecarbs"))
+		require.NoError(t, err)
+
+		req = httptest.NewRequest(http.MethodGet, "/coder/coder/export", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		res = httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, "kylecarbs\n", res.Body.String())
+	})
+
+	t.Run("Stargazers", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		api := starquery.New(ctx, starquery.Options{KV: kv.NewMemory(), AdminToken: "token"})
+		defer api.Close()
+		_, err := api.Import(ctx, repo, strings.NewReader("user5\nuser1\nuser4\nuser2\nuser3"))
+		require.NoError(t, err)
+
+		page := func(query string) (int, []string, string) {
+			req := httptest.NewRequest(http.MethodGet, "/coder/coder/stargazers?"+query, nil)
+			req.Header.Set("Authorization", "Bearer token")
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, req)
+			var body struct {
+				Stargazers []string `json:"stargazers"`
+				Next       string   `json:"next"`
+			}
+			if res.Code == http.StatusOK {
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			}
+			return res.Code, body.Stargazers, body.Next
+		}
+
+		var got []string
+		next := ""
+		for i := 0; ; i++ {
+			code, stargazers, cursor := page("limit=2&after=" + next)
+			require.Equal(t, http.StatusOK, code)
+			got = append(got, stargazers...)
+			if i == 0 {
+				// Logins added behind the cursor don't shift later pages.
+				_, err := api.Import(ctx, repo, strings.NewReader("user0"))
+				require.NoError(t, err)
+			}
+			if cursor == "" {
+				break
+			}
+			next = cursor
+		}
+		require.Equal(t, []string{"user1", "user2", "user3", "user4", "user5"}, got)
+
+		code, stargazers, cursor := page("")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, stargazers, 6)
+		require.Empty(t, cursor)
+
+		code, _, _ = page("limit=0")
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("AdminDisabled", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory()})
+		defer api.Close()
+		req := httptest.NewRequest(http.MethodPost, "/coder/coder/import", strings.NewReader("kylecarbs"))
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.NotEqual(t, http.StatusOK, res.Code)
+	})
+}
@@ -0,0 +1,32 @@
+//go:build redis
+
+package starquery_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/coder/starquery/kv"
+)
+
+// BenchmarkQueryRedis runs the query benchmarks against the Redis at
+// REDIS_URL, e.g.:
+//
+//	REDIS_URL=127.0.0.1:6379 go test -tags redis -run '^$' -bench QueryRedis
+func BenchmarkQueryRedis(b *testing.B) {
+	addr, ok := os.LookupEnv("REDIS_URL")
+	if !ok {
+		b.Skip("REDIS_URL is not set")
+	}
+	if err := kv.NewRedis(addr).Ping(context.Background()); err != nil {
+		b.Skipf("Redis at %s is unreachable: %v", addr, err)
+	}
+
+	b.Run("KeyPerUser", func(b *testing.B) {
+		benchmarkQuery(b, kv.NewRedis(addr))
+	})
+	b.Run("HashPerRepo", func(b *testing.B) {
+		benchmarkQuery(b, kv.NewRedisWithOptions(addr, kv.RedisOptions{Layout: kv.LayoutHashPerRepo}))
+	})
+}
@@ -0,0 +1,112 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+)
+
+// benchmarkRepos is the number of tracked repos the bulk endpoint checks.
+const benchmarkRepos = 10
+
+func BenchmarkQuery(b *testing.B) {
+	benchmarkQuery(b, kv.NewMemory())
+}
+
+// benchmarkQuery measures the query endpoints against the store, seeded
+// with one stargazer for every tracked repo.
+func benchmarkQuery(b *testing.B, store kv.Store) {
+	ctx := context.Background()
+	repos := make([]starquery.Repo, benchmarkRepos)
+	pairs := make([][2]string, benchmarkRepos)
+	for i := range repos {
+		repos[i] = starquery.Repo{Owner: "coder", Name: fmt.Sprintf("bench%d", i)}
+		pairs[i] = [2]string{repos[i].Key("kylecarbs"), "true"}
+	}
+	if err := store.Setex(ctx, 3600, pairs); err != nil {
+		b.Fatalf("Setex() error = %v", err)
+	}
+	api := starquery.New(ctx, starquery.Options{
+		// Syncing isn't measured, so fail it without leaving the process.
+		Client: &http.Client{
+			Transport: roundTripper(func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("benchmarks don't reach GitHub")
+			}),
+		},
+		KV:     store,
+		Repos:  repos,
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	b.Cleanup(api.Close)
+
+	for _, bc := range []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "Hit", path: "/coder/bench0/user/kylecarbs", wantStatus: http.StatusOK},
+		{name: "Miss", path: "/coder/bench0/user/nobody", wantStatus: http.StatusNotFound},
+		{name: "UserStars", path: "/user/kylecarbs", wantStatus: http.StatusOK},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			req := httptest.NewRequest(http.MethodGet, bc.path, nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+				if res.Code != bc.wantStatus {
+					b.Fatalf("GET %s = %d, want %d", bc.path, res.Code, bc.wantStatus)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFetchStargazers measures syncing a repo of b.N full pages of
+// stargazers, most of which is decoding GitHub's responses.
+func BenchmarkFetchStargazers(b *testing.B) {
+	var edges []string
+	for i := range 100 {
+		edges = append(edges, fmt.Sprintf(
+			`{"node": {"login": "user%d", "databaseId": %d}, "cursor": "c%d", "starredAt": "2024-01-01T00:00:00Z"}`, i, i, i))
+	}
+	page := []byte(fmt.Sprintf(`{"data": {"repository": {"stargazers": {"edges": [%s]}}, "rateLimit": {"remaining": 5000, "resetAt": "2024-01-01T01:00:00Z"}}}`,
+		strings.Join(edges, ",")))
+	empty := []byte(`{"data": {"repository": {"stargazers": {"edges": []}}, "rateLimit": {"remaining": 5000, "resetAt": "2024-01-01T01:00:00Z"}}}`)
+
+	var served atomic.Int64
+	done := make(chan struct{})
+	b.ReportAllocs()
+	b.ResetTimer()
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				body := empty
+				if n := served.Add(1); n <= int64(b.N) {
+					body = page
+				} else if n == int64(b.N)+1 {
+					close(done)
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(body))}, nil
+			}),
+		},
+		KV:     kv.NewMemory(),
+		Repos:  []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	<-done
+	b.StopTimer()
+	api.Close()
+}
@@ -0,0 +1,104 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errRateBudgetExceeded is returned instead of fetching a page for a
+// repo that has used its share of the rate limit window.
+var errRateBudgetExceeded = errors.New("repo used its share of the rate limit, deferring to the next window")
+
+// rateBudget shares GitHub's GraphQL rate limit fairly between repos.
+// Each repo may use an equal share of the points remaining when a
+// window starts, so one large repo can't starve the rest.
+type rateBudget struct {
+	mu sync.Mutex
+	// reset is when the current window ends, or zero if no response
+	// has reported it yet.
+	reset time.Time
+	// allowance is the rate limit remaining when the window started.
+	allowance int
+	used      map[Repo]int
+	// resume holds the cursor each deferred full sync stopped at.
+	resume map[Repo]string
+}
+
+func newRateBudget() *rateBudget {
+	return &rateBudget{
+		used:   make(map[Repo]int),
+		resume: make(map[Repo]string),
+	}
+}
+
+// observe records the rate limit reported by a response to the repo's
+// request, starting a new window if the reset time changed.
+func (b *rateBudget) observe(repo Repo, remaining int, reset time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if reset.Equal(b.reset) {
+		return
+	}
+	b.reset = reset
+	// The request that reported the new window counts towards it.
+	b.allowance = remaining + 1
+	clear(b.used)
+	b.used[repo] = 1
+}
+
+// take reserves a request for the repo, reporting false if the repo has
+// used its share of the window. Until a window is known, or once it has
+// passed, requests aren't limited.
+func (b *rateBudget) take(repo Repo, repos int, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.reset.IsZero() && now.Before(b.reset) && b.used[repo] >= b.share(repos) {
+		return false
+	}
+	b.used[repo]++
+	return true
+}
+
+// share is the number of requests each repo may make per window. b.mu
+// must be held.
+func (b *rateBudget) share(repos int) int {
+	return max(b.allowance/max(repos, 1), 1)
+}
+
+// usage returns the requests made by each repo this window, and the
+// share each may make.
+func (b *rateBudget) usage(repos int) (map[Repo]int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	used := make(map[Repo]int, len(b.used))
+	for repo, n := range b.used {
+		used[repo] = n
+	}
+	return used, b.share(repos)
+}
+
+// resumable wraps fetchPage so a full sync deferred by the rate budget
+// continues from the page it stopped at, instead of starting over.
+func (a *API) resumable(fetchPage pageFetcher) pageFetcher {
+	if a.budget == nil {
+		return fetchPage
+	}
+	return func(ctx context.Context, repo Repo, cursor string) ([]Stargazer, time.Time, int, error) {
+		a.budget.mu.Lock()
+		if cursor == "" {
+			cursor = a.budget.resume[repo]
+		}
+		delete(a.budget.resume, repo)
+		a.budget.mu.Unlock()
+
+		stargazers, resetTime, remaining, err := fetchPage(ctx, repo, cursor)
+		if errors.Is(err, errRateBudgetExceeded) {
+			a.budget.mu.Lock()
+			a.budget.resume[repo] = cursor
+			a.budget.mu.Unlock()
+		}
+		return stargazers, resetTime, remaining, err
+	}
+}
@@ -0,0 +1,100 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFairRateLimit(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+
+	var mu sync.Mutex
+	// afters records the cursor of every stargazers request per repo.
+	afters := make(map[string][]string)
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				var body struct {
+					Query     string `json:"query"`
+					Variables struct {
+						Name  string `json:"name"`
+						After string `json:"after"`
+					} `json:"variables"`
+				}
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					return nil, err
+				}
+				edges := "[]"
+				if strings.Contains(body.Query, "stargazers(") {
+					mu.Lock()
+					afters[body.Variables.Name] = append(afters[body.Variables.Name], body.Variables.After)
+					mu.Unlock()
+					// The big repo never runs out of pages.
+					if body.Variables.Name == "big" {
+						n, _ := strconv.Atoi(strings.TrimPrefix(body.Variables.After, "c"))
+						edges = fmt.Sprintf(`[{"node": {"login": "user%d"}, "cursor": "c%d"}]`, n+1, n+1)
+					}
+				}
+				// Windows reset on the hour. With 3 points remaining,
+				// the window's allowance is 4, so each repo gets 2.
+				reset := clk.Now().Truncate(time.Hour).Add(time.Hour)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body: io.NopCloser(strings.NewReader(fmt.Sprintf(
+						`{"data": {"repository": {"stargazers": {"edges": %s}}, "rateLimit": {"remaining": 3, "resetAt": %q}}}`,
+						edges, reset.Format(time.RFC3339)))),
+				}, nil
+			}),
+		},
+		KV:            kv.NewMemoryWithClock(clk),
+		Repos:         []starquery.Repo{{Owner: "coder", Name: "big"}, {Owner: "coder", Name: "small"}},
+		Clock:         clk,
+		FairRateLimit: true,
+		// Both repos sync within the first window.
+		DisableFetchStagger: true,
+	})
+	defer api.Close()
+
+	requests := func(name string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), afters[name]...)
+	}
+
+	// The big repo stops after its share, leaving the rest for the
+	// small one.
+	require.Eventually(t, func() bool {
+		return len(requests("small")) == 1 && len(requests("big")) == 2
+	}, 5*time.Second, time.Millisecond)
+	require.Equal(t, []string{"", "c1"}, requests("big"))
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Contains(t, res.Body.String(), "starquery_rate_budget_share 2\n")
+	require.Contains(t, res.Body.String(), "starquery_rate_budget_used{repo=\"coder/big\"} 2\n")
+	require.Contains(t, res.Body.String(), "starquery_rate_budget_used{repo=\"coder/small\"} 1\n")
+
+	// In the next window, the big repo resumes where it stopped.
+	clk.Advance(time.Hour)
+	require.Eventually(t, func() bool {
+		return len(requests("big")) == 4
+	}, 5*time.Second, time.Millisecond)
+	require.Equal(t, []string{"", "c1", "c2", "c3"}, requests("big"))
+}
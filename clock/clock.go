@@ -0,0 +1,151 @@
+// Package clock abstracts the passage of time, so expiry and loop
+// timing can be tested without sleeping.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for it to pass.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real returns the Clock backed by the time package.
+func Real() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// Fake is a Clock that only moves when advanced. It's safe for
+// concurrent use.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer fires on c at when, and again every period if it's a ticker.
+type fakeTimer struct {
+	c      chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+// NewFake returns a Fake clock starting at now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	timer := &fakeTimer{c: make(chan time.Time, 1), when: f.now.Add(d)}
+	if d <= 0 {
+		timer.c <- f.now
+		return timer.c
+	}
+	f.timers = append(f.timers, timer)
+	return timer.c
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	timer := &fakeTimer{c: make(chan time.Time, 1), when: f.now.Add(d), period: d}
+	f.timers = append(f.timers, timer)
+	return &fakeTicker{clock: f, timer: timer}
+}
+
+// Advance moves the clock forward by d, firing every timer that comes
+// due. Like time.Ticker, a ticker that isn't read drops ticks.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	pending := f.timers[:0]
+	for _, timer := range f.timers {
+		if timer.when.After(f.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		select {
+		case timer.c <- f.now:
+		default:
+		}
+		if timer.period > 0 {
+			for !timer.when.After(f.now) {
+				timer.when = timer.when.Add(timer.period)
+			}
+			pending = append(pending, timer)
+		}
+	}
+	f.timers = pending
+}
+
+// Waiters returns the number of pending timers and tickers, so tests
+// can tell when the code under test has started waiting.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+type fakeTicker struct {
+	clock *Fake
+	timer *fakeTimer
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.timer.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t.timer {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return
+		}
+	}
+}
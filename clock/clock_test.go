@@ -0,0 +1,75 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coder/starquery/clock"
+)
+
+func TestFake(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("After", func(t *testing.T) {
+		t.Parallel()
+		clk := clock.NewFake(start)
+		after := clk.After(time.Minute)
+		if clk.Waiters() != 1 {
+			t.Fatalf("Waiters() = %d, want 1", clk.Waiters())
+		}
+		clk.Advance(time.Minute - time.Second)
+		select {
+		case <-after:
+			t.Fatal("After() fired early")
+		default:
+		}
+		clk.Advance(time.Second)
+		select {
+		case got := <-after:
+			if want := start.Add(time.Minute); !got.Equal(want) {
+				t.Errorf("After() = %s, want %s", got, want)
+			}
+		default:
+			t.Fatal("After() didn't fire")
+		}
+		if clk.Waiters() != 0 {
+			t.Errorf("Waiters() = %d, want 0", clk.Waiters())
+		}
+	})
+
+	t.Run("Ticker", func(t *testing.T) {
+		t.Parallel()
+		clk := clock.NewFake(start)
+		ticker := clk.NewTicker(time.Minute)
+		for i := range 2 {
+			clk.Advance(time.Minute)
+			select {
+			case <-ticker.C():
+			default:
+				t.Fatalf("tick %d didn't fire", i)
+			}
+		}
+
+		// Unread ticks are dropped rather than queued.
+		clk.Advance(3 * time.Minute)
+		<-ticker.C()
+		select {
+		case <-ticker.C():
+			t.Fatal("ticker queued a dropped tick")
+		default:
+		}
+
+		ticker.Stop()
+		clk.Advance(time.Minute)
+		select {
+		case <-ticker.C():
+			t.Fatal("stopped ticker fired")
+		default:
+		}
+		if clk.Waiters() != 0 {
+			t.Errorf("Waiters() = %d, want 0", clk.Waiters())
+		}
+	})
+}
@@ -1,59 +1,708 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/coder/starquery"
 	"github.com/coder/starquery/kv"
 	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+)
+
+// Set by goreleaser's default ldflags, e.g.
+// -X main.version=v1.2.3 -X main.commit=abc123 -X main.date=2024-01-01T00:00:00Z.
+var (
+	version string
+	commit  string
+	date    string
 )
 
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
-	err := run(context.Background(), logger)
+	// The first SIGINT or SIGTERM shuts down gracefully, e.g. so serve
+	// saves its snapshot, and a second one exits right away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	code := run(ctx, os.Args[1:], environment{
+		lookupEnv: os.LookupEnv,
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+	})
+	stop()
+	os.Exit(code)
+}
+
+// environment is what starquery reads its configuration from and
+// writes its output to, so tests can run it without the process's own.
+type environment struct {
+	lookupEnv func(string) (string, bool)
+	stdout    io.Writer
+	stderr    io.Writer
+	// transport sends requests to GitHub, or a transport tuned by the
+	// GITHUB_* env vars if nil.
+	transport http.RoundTripper
+}
+
+// getenv returns the value of the env var, or "" if it's unset.
+func (env environment) getenv(name string) string {
+	value, _ := env.lookupEnv(name)
+	return value
+}
+
+// run runs the command in args and returns the exit code. serve runs
+// the server, the default. sync syncs every repo once and exits, e.g.
+// from cron, failing if any repo fails to sync. check prints whether a
+// user starred a repo, exiting 1 if they haven't and 2 on errors, like
+// grep.
+func run(ctx context.Context, args []string, env environment) int {
+	logger, err := newLogger(env)
+	if err != nil {
+		slog.New(slog.NewTextHandler(env.stderr, nil)).Error("configure logging", "error", err)
+		return 1
+	}
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "serve", "sync":
+		if len(args) > 0 {
+			err = fmt.Errorf("usage: starquery %s", command)
+		} else {
+			err = runCommand(ctx, env, logger, command, args)
+		}
+	case "check":
+		if len(args) != 2 {
+			err = errors.New("usage: starquery check <owner>/<repo> <username>")
+		} else {
+			err = runCommand(ctx, env, logger, command, args)
+		}
+	default:
+		err = fmt.Errorf("unknown command %q, must be \"serve\", \"sync\", or \"check\"", command)
+	}
+	if errors.Is(err, errNotStarred) {
+		return 1
+	}
 	if err != nil {
 		logger.Error("run", "error", err)
-		os.Exit(1)
+		if command == "check" {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// errNotStarred is returned by check when the user hasn't starred the
+// repo, to exit 1 without logging an error.
+var errNotStarred = errors.New("not starred")
+
+// check prints whether the store has username as a stargazer of repo,
+// e.g. "owner/repo". It returns errNotStarred if it doesn't.
+func check(ctx context.Context, env environment, api *starquery.API, repo, username string) error {
+	repos, err := starquery.ParseRepos(repo)
+	if err != nil {
+		return err
+	}
+	if len(repos) != 1 {
+		return fmt.Errorf("invalid repo %q, must be \"owner/repo\"", repo)
+	}
+	starred, err := api.IsStargazer(ctx, repos[0], username)
+	if err != nil {
+		return err
+	}
+	if !starred {
+		fmt.Fprintln(env.stdout, "not starred")
+		return errNotStarred
+	}
+	fmt.Fprintln(env.stdout, "starred")
+	return nil
+}
+
+// newLogger returns a logger writing to stderr in LOG_FORMAT (text or
+// json, text by default) at LOG_LEVEL (debug, info, warn, or error,
+// info by default).
+func newLogger(env environment) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{}
+	if value, ok := env.lookupEnv("LOG_LEVEL"); ok {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+		}
+		opts.Level = level
+	}
+	switch format := env.getenv("LOG_FORMAT"); format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(env.stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(env.stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q, must be \"text\" or \"json\"", format)
 	}
 }
 
-func run(ctx context.Context, logger *slog.Logger) error {
-	bindAddress, ok := os.LookupEnv("BIND_ADDRESS")
+// runCommand runs command, one of serve, sync, or check, with its args.
+func runCommand(ctx context.Context, env environment, logger *slog.Logger, command string, args []string) error {
+	// sync and check exit when done instead of serving.
+	oneShot := command != "serve"
+	bindAddress, ok := env.lookupEnv("BIND_ADDRESS")
 	if !ok {
 		bindAddress = "127.0.0.1:8080"
 	}
-	githubToken, ok := os.LookupEnv("GITHUB_TOKEN")
+	githubToken, ok := env.lookupEnv("GITHUB_TOKEN")
 	if !ok {
 		logger.Warn("missing GITHUB_TOKEN, unauthenticated requests will be rate-limited")
 	}
 
-	redisURL, ok := os.LookupEnv("REDIS_URL")
+	// Caps each repo's share of memory, whether the memory store or the
+	// Redis cache is used.
+	var memoryOpts kv.MemoryOptions
+	if value, ok := env.lookupEnv("MEMORY_MAX_ENTRIES_PER_REPO"); ok {
+		var err error
+		memoryOpts.MaxEntriesPerGroup, err = strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid MEMORY_MAX_ENTRIES_PER_REPO: %w", err)
+		}
+	}
+	memoryCompactInterval, err := env.durationEnv("MEMORY_COMPACT_INTERVAL", 10*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	// Set KEY_PREFIX to share one Redis across several deployments.
+	keyPrefix := env.getenv("KEY_PREFIX")
+	redisURL, ok := env.lookupEnv("REDIS_URL")
 	var store kv.Store
+	// Redis persists on its own, so snapshots are only for the memory store.
+	var snapshotPath string
+	// Set REDIS_REQUIRED=false to start without Redis, failing
+	// queries until it's reachable, rather than exiting.
+	redisRequired, err := env.boolEnv("REDIS_REQUIRED", true)
+	if err != nil {
+		return err
+	}
 	if !ok {
 		logger.Warn("missing REDIS_URL, using in-memory store")
-		store = kv.NewMemory()
+		store = kv.NewMemoryWithOptions(memoryOpts)
+		go kv.CompactMemory(ctx, store, memoryCompactInterval)
+		snapshotPath = env.getenv("SNAPSHOT_PATH")
 	} else {
-		store = kv.NewRedis(redisURL)
+		opts := kv.RedisOptions{Logger: logger, KeyPrefix: keyPrefix}
+		switch layout := env.getenv("REDIS_LAYOUT"); layout {
+		case "", "key":
+		case "hash":
+			opts.Layout = kv.LayoutHashPerRepo
+		default:
+			return fmt.Errorf("invalid REDIS_LAYOUT %q, must be \"key\" or \"hash\"", layout)
+		}
+		switch codec := env.getenv("REDIS_CODEC"); codec {
+		case "", "none":
+		case "gzip":
+			opts.Codec = kv.CodecGzip
+		default:
+			return fmt.Errorf("invalid REDIS_CODEC %q, must be \"none\" or \"gzip\"", codec)
+		}
+		pingTimeout, err := env.durationEnv("REDIS_PING_TIMEOUT", 10*time.Second)
+		if err != nil {
+			return err
+		}
+		cleanupInterval, err := env.durationEnv("REDIS_CLEANUP_INTERVAL", time.Hour)
+		if err != nil {
+			return err
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		store, err = kv.DialRedis(pingCtx, redisURL, opts)
+		cancel()
+		if err != nil {
+			if redisRequired {
+				return err
+			}
+			logger.Warn("redis is unreachable, starting degraded", "error", err)
+		}
+		go kv.CleanupRedis(ctx, store, cleanupInterval)
+		memoryCache, err := env.boolEnv("REDIS_MEMORY_CACHE", false)
+		if err != nil {
+			return err
+		}
+		if memoryCache {
+			// Deletes, e.g. from unstars, are broadcast so other
+			// replicas evict them from their caches.
+			cache := kv.NewMemoryWithOptions(memoryOpts)
+			go kv.CompactMemory(ctx, cache, memoryCompactInterval)
+			store = kv.NewTieredWithOptions(ctx, cache, store, kv.TieredOptions{
+				PubSub: store.(kv.PubSub),
+				Logger: logger,
+			})
+		}
 	}
 
-	webhookSecret, ok := os.LookupEnv("WEBHOOK_SECRET")
-	if !ok {
-		return errors.New("missing WEBHOOK_SECRET")
-	}
-
-	err := http.ListenAndServe(bindAddress, starquery.New(ctx, starquery.Options{
-		Client: oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: githubToken})),
-		KV:     store,
-		Logger: logger,
-		Repos: []starquery.Repo{{
-			Owner: "coder",
-			Name:  "coder",
-		}},
-		WebhookSecret: webhookSecret,
-	}))
+	// Per-repo secrets from the file can stand in for the shared one.
+	webhookSecret, ok := env.lookupEnv("WEBHOOK_SECRET")
+	webhookSecretsFile := env.getenv("WEBHOOK_SECRETS_FILE")
+	if !ok && webhookSecretsFile == "" && !oneShot {
+		return errors.New("missing WEBHOOK_SECRET or WEBHOOK_SECRETS_FILE")
+	}
+
+	// Set when running multiple replicas against the same Redis,
+	// so only one of them fetches from GitHub.
+	leaderElection, err := env.boolEnv("LEADER_ELECTION", false)
+	if err != nil {
+		return err
+	}
+	orgMembers, err := env.boolEnv("ORG_MEMBERS", false)
+	if err != nil {
+		return err
+	}
+	readThrough, err := env.boolEnv("READ_THROUGH", false)
+	if err != nil {
+		return err
+	}
+	ignoreUnstars, err := env.boolEnv("IGNORE_UNSTARS", false)
+	if err != nil {
+		return err
+	}
+	reconcile, err := env.boolEnv("RECONCILE", false)
+	if err != nil {
+		return err
+	}
+	incrementalSync, err := env.boolEnv("INCREMENTAL_SYNC", false)
+	if err != nil {
+		return err
+	}
+	fairRateLimit, err := env.boolEnv("FAIR_RATE_LIMIT", false)
+	if err != nil {
+		return err
+	}
+	trackForks, err := env.boolEnv("TRACK_FORKS", false)
+	if err != nil {
+		return err
+	}
+	storeUserIDs, err := env.boolEnv("STORE_USER_IDS", false)
+	if err != nil {
+		return err
+	}
+	storeLoginCasing, err := env.boolEnv("STORE_LOGIN_CASING", false)
+	if err != nil {
+		return err
+	}
+	hashKeys, err := env.boolEnv("HASH_KEYS", false)
+	if err != nil {
+		return err
+	}
+	expiresAtHeader, err := env.boolEnv("EXPIRES_AT_HEADER", false)
+	if err != nil {
+		return err
+	}
+	readOnly, err := env.boolEnv("READ_ONLY", false)
+	if err != nil {
+		return err
+	}
+	requireFirstSync, err := env.boolEnv("REQUIRE_FIRST_SYNC", false)
+	if err != nil {
+		return err
+	}
+	readOnlyAcceptWebhooks, err := env.boolEnv("READ_ONLY_ACCEPT_WEBHOOKS", false)
+	if err != nil {
+		return err
+	}
+	signedAdminRequests, err := env.boolEnv("SIGNED_ADMIN_REQUESTS", false)
+	if err != nil {
+		return err
+	}
+	// Only takes effect with STARQUERY_ENV=development.
+	webhookEchoMode, err := env.boolEnv("WEBHOOK_ECHO_MODE", false)
+	if err != nil {
+		return err
+	}
+	dropWebhookOnStoreError, err := env.boolEnv("DROP_WEBHOOK_ON_STORE_ERROR", false)
+	if err != nil {
+		return err
+	}
+	var milestones []int
+	if value, ok := env.lookupEnv("MILESTONES"); ok {
+		for _, field := range strings.Split(value, ",") {
+			milestone, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return fmt.Errorf("invalid MILESTONES: %w", err)
+			}
+			milestones = append(milestones, milestone)
+		}
+	}
+	var onMilestone func(context.Context, starquery.Repo, int) error
+	if url, ok := env.lookupEnv("MILESTONE_WEBHOOK_URL"); ok {
+		onMilestone = postMilestone(url, milestoneTimeout)
+	}
+	var maxStargazersPerSync int
+	if value, ok := env.lookupEnv("MAX_STARGAZERS_PER_SYNC"); ok {
+		var err error
+		maxStargazersPerSync, err = strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid MAX_STARGAZERS_PER_SYNC: %w", err)
+		}
+	}
+	graphQLTimeout, err := env.durationEnv("GRAPHQL_TIMEOUT", starquery.DefaultGraphQLTimeout)
+	if err != nil {
+		return err
+	}
+	storeTimeout, err := env.durationEnv("STORE_TIMEOUT", starquery.DefaultStoreTimeout)
+	if err != nil {
+		return err
+	}
+	webhookMaxAge, err := env.durationEnv("WEBHOOK_MAX_AGE", 0)
+	if err != nil {
+		return err
+	}
+	webhookClockSkew, err := env.durationEnv("WEBHOOK_CLOCK_SKEW", 0)
+	if err != nil {
+		return err
+	}
+	maxConcurrentQueries, err := env.intEnv("MAX_CONCURRENT_QUERIES", 0)
+	if err != nil {
+		return err
+	}
+	negativeCacheTTL, err := env.durationEnv("NEGATIVE_CACHE_TTL", 0)
+	if err != nil {
+		return err
+	}
+	maxSyncAge, err := env.durationEnv("MAX_SYNC_AGE", 0)
+	if err != nil {
+		return err
+	}
+	repos := []starquery.Repo{{Owner: "coder", Name: "coder"}}
+	if value, ok := env.lookupEnv("REPOS"); ok {
+		repos, err = starquery.ParseRepos(value)
+		if err != nil {
+			return fmt.Errorf("invalid REPOS: %w", err)
+		}
+	}
+	repoCheckWarnOnly, err := env.boolEnv("REPO_CHECK_WARN_ONLY", false)
+	if err != nil {
+		return err
+	}
+	var ttlJitter float64
+	if value, ok := env.lookupEnv("TTL_JITTER"); ok {
+		ttlJitter, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid TTL_JITTER: %w", err)
+		}
+	}
+	webhookTargetID, err := env.int64Env("WEBHOOK_TARGET_ID")
+	if err != nil {
+		return err
+	}
+	webhookInstallationID, err := env.int64Env("WEBHOOK_INSTALLATION_ID")
+	if err != nil {
+		return err
+	}
+	githubClient, err := newGitHubClient(ctx, env, githubToken)
+	if err != nil {
+		return err
+	}
+
+	api, err := starquery.NewWithError(ctx, starquery.Options{
+		Client:         githubClient,
+		FetchStrategy:  starquery.FetchStrategy(env.getenv("FETCH_STRATEGY")),
+		KV:             store,
+		KeyPrefix:      keyPrefix,
+		Logger:         logger,
+		Repos:          repos,
+		WebhookSecret:  webhookSecret,
+		LeaderElection: leaderElection && !oneShot,
+		AdminToken:     env.getenv("ADMIN_TOKEN"),
+		OrgMembers:     orgMembers,
+		ReadThrough:    readThrough,
+		IgnoreUnstars:  ignoreUnstars,
+		Reconcile:      reconcile,
+		SnapshotPath:   snapshotPath,
+		Build: starquery.BuildInfo{
+			Version:   version,
+			Commit:    commit,
+			BuildTime: date,
+		},
+		IncrementalSync:         incrementalSync,
+		MaxSyncAge:              maxSyncAge,
+		TTLJitter:               ttlJitter,
+		FairRateLimit:           fairRateLimit,
+		MaxStargazersPerSync:    maxStargazersPerSync,
+		TrackForks:              trackForks,
+		StoreUserIDs:            storeUserIDs,
+		StoreLoginCasing:        storeLoginCasing,
+		HashKeys:                hashKeys,
+		ExpiresAtHeader:         expiresAtHeader,
+		ReadOnly:                readOnly,
+		RequireFirstSync:        requireFirstSync,
+		ReadOnlyAcceptWebhooks:  readOnlyAcceptWebhooks,
+		WebhookSecretsFile:      webhookSecretsFile,
+		WebhookTargetType:       env.getenv("WEBHOOK_TARGET_TYPE"),
+		WebhookTargetID:         webhookTargetID,
+		WebhookInstallationID:   webhookInstallationID,
+		WebhookMaxAge:           webhookMaxAge,
+		WebhookClockSkew:        webhookClockSkew,
+		Milestones:              milestones,
+		OnMilestone:             onMilestone,
+		GraphQLTimeout:          graphQLTimeout,
+		StoreTimeout:            storeTimeout,
+		MaxConcurrentQueries:    maxConcurrentQueries,
+		NegativeCacheTTL:        negativeCacheTTL,
+		SignedAdminRequests:     signedAdminRequests,
+		WebhookEchoMode:         webhookEchoMode,
+		DropWebhookOnStoreError: dropWebhookOnStoreError,
+		DisableFetchLoop:        oneShot,
+		AllowUnavailableStore:   !redisRequired,
+	})
+	if err != nil {
+		return err
+	}
+	defer api.Close()
+	switch command {
+	case "sync":
+		return api.Sync(ctx)
+	case "check":
+		return check(ctx, env, api, args[0], args[1])
+	}
+	// Typos in REPOS, or repos the token wasn't granted, would otherwise
+	// never sync.
+	if err := api.CheckAccess(ctx); err != nil {
+		if !repoCheckWarnOnly {
+			return err
+		}
+		logger.Warn("starting with repos that can't be read", "error", err)
+	}
+
+	// Set to serve webhooks, metrics, and admin endpoints on a separate,
+	// e.g. internal-only, address from the public queries.
+	servers := []*http.Server{{Addr: bindAddress, Handler: api}}
+	if webhookBindAddress, ok := env.lookupEnv("WEBHOOK_BIND_ADDRESS"); ok {
+		servers = []*http.Server{
+			{Addr: bindAddress, Handler: api.QueryHandler()},
+			{Addr: webhookBindAddress, Handler: api.WebhookHandler()},
+		}
+	}
+	for _, server := range servers {
+		for _, timeout := range []struct {
+			name  string
+			value *time.Duration
+			def   time.Duration
+		}{
+			// Headers are small, so slow senders are likely slowloris.
+			{"READ_HEADER_TIMEOUT", &server.ReadHeaderTimeout, 10 * time.Second},
+			// Webhook and import bodies are the largest requests.
+			{"READ_TIMEOUT", &server.ReadTimeout, 30 * time.Second},
+			// Leaves room for exporting large repos.
+			{"WRITE_TIMEOUT", &server.WriteTimeout, 60 * time.Second},
+			{"IDLE_TIMEOUT", &server.IdleTimeout, 120 * time.Second},
+		} {
+			*timeout.value, err = env.durationEnv(timeout.name, timeout.def)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	shutdownTimeout, err := env.durationEnv("SHUTDOWN_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return err
+	}
+	// Set to also answer queries over gRPC on its own address.
+	grpcBindAddress, serveGRPC := env.lookupEnv("GRPC_BIND_ADDRESS")
+	var grpcServer *grpc.Server
+	var grpcListener net.Listener
+	if serveGRPC {
+		grpcListener, err = net.Listen("tcp", grpcBindAddress)
+		if err != nil {
+			return fmt.Errorf("listen %s: %w", grpcBindAddress, err)
+		}
+		grpcServer = api.GRPCServer()
+	}
+
+	// Stop as soon as any server fails, or on a signal.
+	errs := make(chan error, len(servers)+1)
+	for _, server := range servers {
+		go func() {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("serve %s: %w", server.Addr, err)
+			}
+		}()
+	}
+	if grpcServer != nil {
+		go func() {
+			// Serve only returns nil once stopped.
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				errs <- fmt.Errorf("serve %s: %w", grpcBindAddress, err)
+			}
+		}()
+	}
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		logger.Info("shutting down")
+	}
+	// In-flight requests finish before the deferred Close stops the API.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+			err = errors.Join(err, fmt.Errorf("shut down %s: %w", server.Addr, shutdownErr))
+		}
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcServer.Stop()
+			err = errors.Join(err, fmt.Errorf("shut down %s: %w", grpcBindAddress, shutdownCtx.Err()))
+		}
+	}
 	return err
 }
+
+// milestoneTimeout bounds each milestone post. Milestones are checked
+// during syncs, which have no deadline, so a hung endpoint would
+// otherwise stall every later sync.
+const milestoneTimeout = 10 * time.Second
+
+// postMilestone returns an OnMilestone callback that posts a message
+// about the milestone to url, in the format of a Slack incoming webhook,
+// giving up after timeout.
+func postMilestone(url string, timeout time.Duration) func(context.Context, starquery.Repo, int) error {
+	return func(ctx context.Context, repo starquery.Repo, milestone int) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		body, err := json.Marshal(map[string]string{
+			"text": fmt.Sprintf("%s reached %d stars", repo, milestone),
+		})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// newGitHubClient returns a client authenticating with the token over a
+// transport tuned by the GITHUB_* env vars. Syncs page through GraphQL
+// one request after another per repo, against the one api.github.com
+// host, so keeping more idle connections to it than Go's default of 2
+// saves a TLS handshake per request when repos sync concurrently.
+func newGitHubClient(ctx context.Context, env environment, token string) (*http.Client, error) {
+	base, err := env.githubTransport()
+	if err != nil {
+		return nil, err
+	}
+	// oauth2 wraps the transport of the client in ctx, so requests keep
+	// their token whatever the tuning.
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: base})
+	return oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})), nil
+}
+
+// githubTransport returns the environment's transport, or a clone of
+// the default transport tuned by the GITHUB_* env vars.
+func (env environment) githubTransport() (http.RoundTripper, error) {
+	if env.transport != nil {
+		return env.transport, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	var err error
+	transport.MaxIdleConns, err = env.intEnv("GITHUB_MAX_IDLE_CONNS", 100)
+	if err != nil {
+		return nil, err
+	}
+	transport.MaxIdleConnsPerHost, err = env.intEnv("GITHUB_MAX_IDLE_CONNS_PER_HOST", 10)
+	if err != nil {
+		return nil, err
+	}
+	transport.IdleConnTimeout, err = env.durationEnv("GITHUB_IDLE_CONN_TIMEOUT", 90*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSHandshakeTimeout, err = env.durationEnv("GITHUB_TLS_HANDSHAKE_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return transport, nil
+}
+
+// intEnv parses the integer in the env var, or returns def if it's
+// unset.
+func (env environment) intEnv(name string, def int) (int, error) {
+	value, ok := env.lookupEnv(name)
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return n, nil
+}
+
+// int64Env parses the integer in the env var, or returns zero if it's
+// unset.
+func (env environment) int64Env(name string) (int64, error) {
+	value, ok := env.lookupEnv(name)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return n, nil
+}
+
+// boolEnv parses the boolean in the env var, e.g. "true" or "0", or
+// returns def if it's unset.
+func (env environment) boolEnv(name string, def bool) (bool, error) {
+	value, ok := env.lookupEnv(name)
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return b, nil
+}
+
+// durationEnv parses the duration in the env var, or returns def if
+// it's unset.
+func (env environment) durationEnv(name string, def time.Duration) (time.Duration, error) {
+	value, ok := env.lookupEnv(name)
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/starquery"
+)
+
+type roundTripper func(req *http.Request) (*http.Response, error)
+
+func (r roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r(req)
+}
+
+// newEnv returns an environment reading vars, writing to stdout, and
+// sending GitHub requests to transport.
+func newEnv(vars map[string]string, stdout io.Writer, transport http.RoundTripper) environment {
+	return environment{
+		lookupEnv: func(name string) (string, bool) {
+			value, ok := vars[name]
+			return value, ok
+		},
+		stdout:    stdout,
+		stderr:    io.Discard,
+		transport: transport,
+	}
+}
+
+// github serves each repo's stargazers, user1 and user2, on one page,
+// or fails every request if failing is set.
+func github(failing *atomic.Bool) roundTripper {
+	return func(req *http.Request) (*http.Response, error) {
+		if failing.Load() {
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Body:       io.NopCloser(strings.NewReader("")),
+			}, nil
+		}
+		var body struct {
+			Query     string `json:"query"`
+			Variables struct {
+				After string `json:"after"`
+			} `json:"variables"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+		edges := "[]"
+		if strings.Contains(body.Query, "stargazers(") && body.Variables.After == "" {
+			edges = `[{"node": {"login": "user1"}, "cursor": "c1"}, {"node": {"login": "user2"}, "cursor": "c2"}]`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(fmt.Sprintf(
+				`{"data": {"repository": {"stargazers": {"edges": %s}, "forks": {"edges": []}, "stargazerCount": 2}, "rateLimit": {"remaining": 5000}}}`, edges))),
+		}, nil
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot")
+	vars := map[string]string{
+		"GITHUB_TOKEN":  "token",
+		"REPOS":         "coder/coder",
+		"SNAPSHOT_PATH": snapshotPath,
+	}
+	var failing atomic.Bool
+	runArgs := func(args ...string) (int, string) {
+		var stdout bytes.Buffer
+		code := run(context.Background(), args, newEnv(vars, &stdout, github(&failing)))
+		return code, stdout.String()
+	}
+
+	t.Run("Usage", func(t *testing.T) {
+		code, _ := runArgs("unknown")
+		require.Equal(t, 1, code)
+		code, _ = runArgs("sync", "extra")
+		require.Equal(t, 1, code)
+	})
+
+	// sync stores the stargazers and saves them to the snapshot on exit,
+	// which check then reads without fetching.
+	t.Run("Sync", func(t *testing.T) {
+		code, _ := runArgs("sync")
+		require.Equal(t, 0, code)
+		_, err := os.Stat(snapshotPath)
+		require.NoError(t, err)
+	})
+
+	t.Run("Check", func(t *testing.T) {
+		failing.Store(true)
+		code, stdout := runArgs("check", "coder/coder", "user1")
+		require.Equal(t, 0, code)
+		require.Equal(t, "starred\n", stdout)
+
+		code, stdout = runArgs("check", "coder/coder", "user3")
+		require.Equal(t, 1, code)
+		require.Equal(t, "not starred\n", stdout)
+
+		code, _ = runArgs("check", "coder/coder")
+		require.Equal(t, 2, code)
+		code, _ = runArgs("check", "coder", "user1")
+		require.Equal(t, 2, code)
+	})
+
+	// Stargazers synced under another KEY_PREFIX aren't found.
+	t.Run("KeyPrefix", func(t *testing.T) {
+		vars["KEY_PREFIX"] = "other"
+		defer delete(vars, "KEY_PREFIX")
+		failing.Store(true)
+		code, stdout := runArgs("check", "coder/coder", "user1")
+		require.Equal(t, 1, code)
+		require.Equal(t, "not starred\n", stdout)
+	})
+
+	t.Run("SyncFails", func(t *testing.T) {
+		failing.Store(true)
+		code, _ := runArgs("sync")
+		require.Equal(t, 1, code)
+	})
+
+	t.Run("InvalidEnv", func(t *testing.T) {
+		vars["TRACK_FORKS"] = "yes"
+		defer delete(vars, "TRACK_FORKS")
+		code, _ := runArgs("sync")
+		require.Equal(t, 1, code)
+	})
+}
+
+// TestPostMilestoneTimeout verifies that a milestone endpoint that never
+// responds doesn't block the sync that posts to it.
+func TestPostMilestoneTimeout(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	post := postMilestone(server.URL, 50*time.Millisecond)
+	start := time.Now()
+	err := post(context.Background(), starquery.Repo{Owner: "coder", Name: "coder"}, 1000)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
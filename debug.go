@@ -0,0 +1,54 @@
+package starquery
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/coder/starquery/kv"
+)
+
+// debugKeyResponse is returned by the debug key endpoint.
+type debugKeyResponse struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// TTLSeconds is negative if the key never expires.
+	TTLSeconds float64 `json:"ttl_seconds"`
+}
+
+// handleDebugKey returns the raw value and remaining TTL of the ?key=
+// store key. It's meant for operators debugging unexpected query
+// results, and its response format isn't stable.
+func (a *API) handleDebugKey(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	value, err := a.kv.Get(r.Context(), key)
+	if err != nil {
+		a.writeDebugKeyError(w, r, err)
+		return
+	}
+	ttl, err := a.kv.TTL(r.Context(), key)
+	if err != nil {
+		a.writeDebugKeyError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(debugKeyResponse{
+		Key:        key,
+		Value:      value,
+		TTLSeconds: ttl.Seconds(),
+	})
+}
+
+// writeDebugKeyError responds with 404 if the key doesn't exist, and
+// the store error otherwise.
+func (a *API) writeDebugKeyError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, kv.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	a.writeStoreError(w, r, err)
+}
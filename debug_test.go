@@ -0,0 +1,48 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := kv.NewMemory()
+	api := starquery.New(ctx, starquery.Options{KV: store, AdminToken: "token"})
+	defer api.Close()
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	err := store.Setex(ctx, 60, [][2]string{{repo.Key("kylecarbs"), "true"}})
+	require.NoError(t, err)
+
+	debugKey := func(key, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/debug/key?key="+key, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		return res
+	}
+
+	res := debugKey(repo.Key("kylecarbs"), "token")
+	require.Equal(t, http.StatusOK, res.Code)
+	var resp struct {
+		Key        string  `json:"key"`
+		Value      string  `json:"value"`
+		TTLSeconds float64 `json:"ttl_seconds"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+	require.Equal(t, repo.Key("kylecarbs"), resp.Key)
+	require.Equal(t, "true", resp.Value)
+	require.InDelta(t, 60, resp.TTLSeconds, 5)
+
+	require.Equal(t, http.StatusNotFound, debugKey(repo.Key("other"), "token").Code)
+	require.Equal(t, http.StatusUnauthorized, debugKey(repo.Key("kylecarbs"), "wrong").Code)
+}
@@ -0,0 +1,29 @@
+package starquery
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/google/go-github/v52/github"
+)
+
+// DevEnvVar must be "development" for WebhookEchoMode to take effect,
+// so it can't be left on in production by a stray option alone.
+const DevEnvVar = "STARQUERY_ENV"
+
+// devEnv reports whether the process runs in development.
+func devEnv() bool {
+	return os.Getenv(DevEnvVar) == "development"
+}
+
+// echoWebhook logs the parsed webhook event for WebhookEchoMode.
+func (a *API) echoWebhook(r *http.Request, event any) {
+	parsed, err := json.Marshal(event)
+	if err != nil {
+		a.logger.WarnContext(r.Context(), "failed to encode webhook event for echo mode", "error", err)
+		return
+	}
+	a.logger.WarnContext(r.Context(), "webhook echo mode: received unverified event",
+		"type", github.WebHookType(r), "delivery_id", github.DeliveryID(r), "event", string(parsed))
+}
@@ -0,0 +1,67 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWebhookEchoMode can't run in parallel because it sets the
+// environment.
+func TestWebhookEchoMode(t *testing.T) {
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	unsigned := func() *http.Request {
+		data, err := json.Marshal(generateEvent(repo, "kylecarbs", "created"))
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(data))
+		req.Header.Set("X-GitHub-Event", "star")
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	t.Run("Development", func(t *testing.T) {
+		t.Setenv(starquery.DevEnvVar, "development")
+		ctx := context.Background()
+		store := kv.NewMemory()
+		var logs bytes.Buffer
+		api, err := starquery.NewWithError(ctx, starquery.Options{
+			KV:              store,
+			Logger:          slog.New(slog.NewTextHandler(&logs, nil)),
+			WebhookSecret:   "secret",
+			RequireSHA256:   true,
+			WebhookEchoMode: true,
+		})
+		require.NoError(t, err)
+		defer api.Close()
+		require.Contains(t, logs.String(), "WEBHOOK ECHO MODE IS ON")
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, unsigned())
+		require.Equal(t, http.StatusOK, res.Code)
+		_, err = store.Get(ctx, repo.Key("kylecarbs"))
+		require.NoError(t, err)
+		require.Contains(t, logs.String(), "received unverified event")
+		require.Contains(t, logs.String(), "kylecarbs")
+	})
+
+	t.Run("Production", func(t *testing.T) {
+		t.Setenv(starquery.DevEnvVar, "")
+		ctx := context.Background()
+		_, err := starquery.NewWithError(ctx, starquery.Options{KV: kv.NewMemory(), WebhookEchoMode: true})
+		require.Error(t, err)
+
+		api := starquery.New(ctx, starquery.Options{KV: kv.NewMemory(), WebhookSecret: "secret", WebhookEchoMode: true})
+		defer api.Close()
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, unsigned())
+		require.Equal(t, http.StatusBadRequest, res.Code)
+	})
+}
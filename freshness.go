@@ -0,0 +1,91 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+)
+
+const (
+	// DefaultFreshCheckLimit is the default number of fresh checks
+	// queries may make per minute.
+	DefaultFreshCheckLimit = 10
+	// freshCheckTimeout bounds how long a fresh check waits on GitHub.
+	freshCheckTimeout = 10 * time.Second
+	// freshCheckPages is the most pages of the user's stars a fresh
+	// check reads, newest first.
+	freshCheckPages = 10
+)
+
+// StaleHeader is set to "true" on answers to fresh queries that came
+// from the store because GitHub couldn't be checked.
+const StaleHeader = "X-Starquery-Stale"
+
+// errFreshCheckIncomplete is returned for users with more stars than a
+// fresh check reads.
+var errFreshCheckIncomplete = fmt.Errorf("user has starred more than %d repos", freshCheckPages*100)
+
+// checkStarFresh checks GitHub for whether the user starred the tracked
+// repo, storing a star if found and removing a stored one if not, as if
+// it were unstarred by webhook.
+func (a *API) checkStarFresh(ctx context.Context, repo Repo, username string) (bool, error) {
+	if !a.tracks(repo) {
+		return false, errors.New("repo isn't tracked")
+	}
+	ctx, cancel := context.WithTimeout(ctx, freshCheckTimeout)
+	defer cancel()
+	opts := &github.ActivityListStarredOptions{
+		Sort:        "created",
+		Direction:   "desc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for range freshCheckPages {
+		fetchedAt := a.clock.Now()
+		starred, resp, err := a.github.Activity.ListStarred(ctx, username, opts)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			// The user doesn't exist (anymore).
+			return false, a.unstar(ctx, repo, username, 0)
+		}
+		if err != nil {
+			return false, fmt.Errorf("list starred: %w", err)
+		}
+		for _, s := range starred {
+			if strings.EqualFold(s.GetRepository().GetFullName(), repo.String()) {
+				return true, a.storeFetched(ctx, stars, repo, []Stargazer{{Login: username}}, fetchedAt)
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, a.unstar(ctx, repo, username, 0)
+		}
+		opts.Page = resp.NextPage
+	}
+	return false, errFreshCheckIncomplete
+}
+
+// handleFreshStar answers a star query with ?fresh=true from GitHub.
+// It reports false if the query should instead be answered from the
+// store, having marked the response stale.
+func (a *API) handleFreshStar(w http.ResponseWriter, r *http.Request, repo Repo, username string) bool {
+	if !a.freshLimiter.allow(a.clock.Now()) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Fresh check limit reached, query without fresh=true for the stored answer", http.StatusTooManyRequests)
+		return true
+	}
+	starred, err := a.checkStarFresh(r.Context(), repo, username)
+	if err != nil {
+		a.logger.WarnContext(r.Context(), "fresh check failed, answering from the store", "repo", repo, "user", username, "error", err)
+		w.Header().Set(StaleHeader, "true")
+		return false
+	}
+	if starred {
+		a.writeFoundLogin(w, r, "", username)
+	} else {
+		a.writeQueryNotFound(w, r)
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreshQuery(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	newAPI := func(t *testing.T, store kv.Store, limit int) *starquery.API {
+		gh := &fakeGitHub{starred: map[string][]string{
+			"kylecarbs": {"coder/coder"},
+			"ammar":     {"coder/other"},
+		}}
+		api := starquery.New(context.Background(), starquery.Options{
+			Client:          gh.client(),
+			KV:              store,
+			Repos:           []starquery.Repo{repo},
+			FreshCheckLimit: limit,
+		})
+		t.Cleanup(api.Close)
+		return api
+	}
+
+	t.Run("Starred", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		api := newAPI(t, store, 0)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs").Code, "default queries use the store")
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs?fresh=true").Code)
+		_, err := store.Get(context.Background(), repo.Key("kylecarbs"))
+		require.NoError(t, err, "found stars are stored")
+	})
+
+	t.Run("Unstarred", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		require.NoError(t, store.Setex(context.Background(), 60, [][2]string{{repo.Key("ammar"), "true"}}))
+		api := newAPI(t, store, 0)
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/ammar").Code)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/ammar?fresh=true").Code)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/ammar").Code, "unstars are removed from the store")
+	})
+
+	t.Run("InFlightPage", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		fetching := make(chan struct{})
+		release := make(chan struct{})
+		releasePage := sync.OnceFunc(func() { close(release) })
+		synced := make(chan struct{})
+		var fetchOnce, syncOnce sync.Once
+		api := starquery.New(ctx, starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					res := &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     http.Header{"Content-Type": []string{"application/json"}},
+						Body:       io.NopCloser(bytes.NewBufferString(`{"data": {"repository": {"stargazers": {"edges": []}}, "rateLimit": {"remaining": 50}}}`)),
+						Request:    req,
+					}
+					switch req.URL.Path {
+					case "/graphql":
+						data, _ := io.ReadAll(req.Body)
+						if !bytes.Contains(data, []byte("stargazers(")) || bytes.Contains(data, []byte(`"after":"c1"`)) {
+							break
+						}
+						// Hold the page, fetched before the fresh check,
+						// until the check is done.
+						fetchOnce.Do(func() { close(fetching) })
+						<-release
+						res.Body = io.NopCloser(bytes.NewBufferString(`{"data": {"repository": {"stargazers": {"edges": [
+							{"node": {"login": "ammar", "databaseId": 7}, "cursor": "c1"}
+						]}}, "rateLimit": {"remaining": 50}}}`))
+					case "/users/ammar/starred":
+						res.Body = io.NopCloser(bytes.NewBufferString(`[{"starred_at": "2023-04-01T00:00:00Z", "repo": {"full_name": "coder/other"}}]`))
+					}
+					return res, nil
+				}),
+			},
+			KV:            store,
+			Repos:         []starquery.Repo{repo},
+			WebhookSecret: "secret",
+			StoreUserIDs:  true,
+			OnSyncComplete: func(starquery.Repo, int, *starquery.StarDelta, error) {
+				syncOnce.Do(func() { close(synced) })
+			},
+		})
+		t.Cleanup(api.Close)
+		// Cleanups run in reverse, so a failed test doesn't hold the
+		// page while closing the API.
+		t.Cleanup(releasePage)
+
+		event := generateEvent(repo, "ammar", "created")
+		id := int64(7)
+		event.Sender.ID = &id
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", event))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user-id/7").Code)
+
+		<-fetching
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/ammar?fresh=true").Code)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user-id/7").Code, "the ID is removed with the login")
+		releasePage()
+		<-synced
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/ammar").Code, "the page fetched before the check doesn't restore the star")
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user-id/7").Code)
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		t.Parallel()
+		api := newAPI(t, kv.NewMemory(), 1)
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs?fresh=true").Code)
+		res := query(api, "/coder/coder/user/kylecarbs?fresh=true")
+		require.Equal(t, http.StatusTooManyRequests, res.Code)
+		require.NotEmpty(t, res.Header().Get("Retry-After"))
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs").Code)
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		require.NoError(t, store.Setex(context.Background(), 60, [][2]string{{repo.Key("broken"), "true"}}))
+		api := newAPI(t, store, 0)
+		// Checking users GitHub doesn't list fails.
+		res := query(api, "/coder/coder/user/broken?fresh=true")
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, "true", res.Header().Get(starquery.StaleHeader))
+
+		res = query(api, "/coder/other/user/kylecarbs?fresh=true")
+		require.Equal(t, http.StatusNotFound, res.Code)
+		require.Equal(t, "true", res.Header().Get(starquery.StaleHeader), "untracked repos aren't checked")
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		api := newAPI(t, kv.NewMemory(), 0)
+		require.Equal(t, http.StatusBadRequest, query(api, "/coder/coder/user/kylecarbs?fresh=maybe").Code)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs?fresh=false").Code)
+	})
+}
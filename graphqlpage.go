@@ -0,0 +1,186 @@
+package starquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// graphQLBodyPrefix is how much of a GraphQL response is kept to
+// include in decoding errors.
+const graphQLBodyPrefix = 512
+
+// relationPage is a decoded page of a relation's GraphQL connection.
+type relationPage struct {
+	// RepositoryFound is false if the repository is null, i.e. it
+	// doesn't exist or isn't visible.
+	RepositoryFound bool
+	Stargazers      []Stargazer
+	RateLimit       struct {
+		Remaining int    `json:"remaining"`
+		ResetAt   string `json:"resetAt"`
+	}
+	Errors []graphQLError
+}
+
+// relationEdge is an edge of a relation's connection as GitHub
+// returns it.
+type relationEdge struct {
+	Node struct {
+		Login      string `json:"login"`
+		DatabaseID int64  `json:"databaseId"`
+		// Owner and CreatedAt are set for forks.
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+		CreatedAt time.Time `json:"createdAt"`
+	} `json:"node"`
+	Cursor    string    `json:"cursor"`
+	StarredAt time.Time `json:"starredAt"`
+}
+
+// decodeRelationPage decodes a GraphQL response listing the connection
+// from r as it's read, one edge at a time, rather than buffering the
+// whole response. Fields other than those in relationPage are skipped.
+func decodeRelationPage(r io.Reader, connection string) (relationPage, error) {
+	var page relationPage
+	dec := json.NewDecoder(r)
+	err := decodeObject(dec, func(key string) error {
+		switch key {
+		case "errors":
+			return dec.Decode(&page.Errors)
+		case "data":
+			// Data is null if the whole query failed, e.g. because it
+			// was rate limited, leaving only the errors.
+			return decodeNullableObject(dec, nil, func(key string) error {
+				switch key {
+				case "rateLimit":
+					return dec.Decode(&page.RateLimit)
+				case "repository":
+					return decodeNullableObject(dec, &page.RepositoryFound, func(key string) error {
+						if key != connection {
+							return skipValue(dec)
+						}
+						return decodeNullableObject(dec, nil, func(key string) error {
+							if key != "edges" {
+								return skipValue(dec)
+							}
+							return decodeEdges(dec, &page.Stargazers)
+						})
+					})
+				default:
+					return skipValue(dec)
+				}
+			})
+		default:
+			return skipValue(dec)
+		}
+	})
+	return page, err
+}
+
+// decodeEdges decodes a JSON array of edges one at a time, appending
+// each to stargazers.
+func decodeEdges(dec *json.Decoder, stargazers *[]Stargazer) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		var edge relationEdge
+		if err := dec.Decode(&edge); err != nil {
+			return err
+		}
+		stargazer := Stargazer{
+			Login:     edge.Node.Login,
+			ID:        edge.Node.DatabaseID,
+			Cursor:    edge.Cursor,
+			StarredAt: edge.StarredAt,
+		}
+		if stargazer.Login == "" {
+			stargazer.Login = edge.Node.Owner.Login
+			stargazer.StarredAt = edge.Node.CreatedAt
+		}
+		*stargazers = append(*stargazers, stargazer)
+	}
+	return expectDelim(dec, ']')
+}
+
+// decodeObject calls field with each key of the next JSON object, which
+// must consume the key's value.
+func decodeObject(dec *json.Decoder, field func(key string) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	return decodeFields(dec, field)
+}
+
+// decodeNullableObject is decodeObject for an object that may be null.
+// If found is set, it reports whether the object wasn't null.
+func decodeNullableObject(dec *json.Decoder, found *bool, field func(key string) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("unexpected %v, want an object", tok)
+	}
+	if found != nil {
+		*found = true
+	}
+	return decodeFields(dec, field)
+}
+
+// decodeFields calls field with each remaining key of the object whose
+// opening brace was consumed, then consumes the closing one.
+func decodeFields(dec *json.Decoder, field func(key string) error) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("unexpected %v, want an object key", tok)
+		}
+		if err := field(key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// expectDelim consumes the next token, which must be delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("unexpected %v, want %v", tok, delim)
+	}
+	return nil
+}
+
+// skipValue consumes the next value, however deeply nested.
+func skipValue(dec *json.Decoder) error {
+	var skipped json.RawMessage
+	return dec.Decode(&skipped)
+}
+
+// prefixWriter keeps the first limit bytes written to it, e.g. to
+// include the start of a streamed response in errors.
+type prefixWriter struct {
+	buf   []byte
+	limit int
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if n := w.limit - len(w.buf); n > 0 {
+		w.buf = append(w.buf, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
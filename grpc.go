@@ -0,0 +1,107 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/coder/starquery/kv"
+	"github.com/coder/starquery/starquerypb"
+)
+
+// maxAreStargazers bounds the users checked by one AreStargazers call,
+// so a single request can't hold the store for long.
+const maxAreStargazers = 1000
+
+// GRPCServer returns a gRPC server answering the starquerypb.Starquery
+// service from the store, for services that would rather call gRPC than
+// the HTTP API. Like IsStargazer, it never looks users up on GitHub.
+// Serve it on its own listener, and stop it before closing the API.
+func (a *API) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(opts...)
+	starquerypb.RegisterStarqueryServer(server, grpcServer{api: a})
+	return server
+}
+
+// grpcServer implements starquerypb.StarqueryServer with the API.
+type grpcServer struct {
+	starquerypb.UnimplementedStarqueryServer
+	api *API
+}
+
+func (s grpcServer) IsStargazer(ctx context.Context, req *starquerypb.IsStargazerRequest) (*starquerypb.IsStargazerResponse, error) {
+	repo, err := grpcRepo(req.GetOwner(), req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetUsername() == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.api.storeTimeout)
+	defer cancel()
+	starred, err := s.api.IsStargazer(ctx, repo, req.GetUsername())
+	if err != nil {
+		return nil, s.api.grpcStoreError(ctx, err)
+	}
+	return &starquerypb.IsStargazerResponse{Starred: starred}, nil
+}
+
+func (s grpcServer) AreStargazers(ctx context.Context, req *starquerypb.AreStargazersRequest) (*starquerypb.AreStargazersResponse, error) {
+	repo, err := grpcRepo(req.GetOwner(), req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetUsernames()) > maxAreStargazers {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d usernames can be checked at once", maxAreStargazers)
+	}
+	for _, username := range req.GetUsernames() {
+		if username == "" {
+			return nil, status.Error(codes.InvalidArgument, "usernames must not be empty")
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.api.storeTimeout)
+	defer cancel()
+	starred, err := s.api.AreStargazers(ctx, repo, req.GetUsernames())
+	if err != nil {
+		return nil, s.api.grpcStoreError(ctx, err)
+	}
+	return &starquerypb.AreStargazersResponse{Starred: starred}, nil
+}
+
+func (s grpcServer) Count(ctx context.Context, req *starquerypb.CountRequest) (*starquerypb.CountResponse, error) {
+	repo, err := grpcRepo(req.GetOwner(), req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	count, err := s.api.CountStargazers(ctx, repo)
+	if err != nil {
+		return nil, s.api.grpcStoreError(ctx, err)
+	}
+	return &starquerypb.CountResponse{Count: int64(count)}, nil
+}
+
+// grpcRepo returns the repo a request names, or an InvalidArgument
+// error if either part is missing.
+func grpcRepo(owner, name string) (Repo, error) {
+	if owner == "" || name == "" {
+		return Repo{}, status.Error(codes.InvalidArgument, "owner and name are required")
+	}
+	return Repo{Owner: owner, Name: name}, nil
+}
+
+// grpcStoreError is writeStoreError for gRPC: Unavailable if the store
+// is down or slow, so clients can retry, and Internal otherwise.
+func (a *API) grpcStoreError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, kv.ErrUnavailable) || errors.Is(err, context.DeadlineExceeded):
+		a.logger.WarnContext(ctx, "store unavailable", "error", err)
+		return status.Error(codes.Unavailable, "store unavailable")
+	case errors.Is(err, errHashedKeys):
+		return status.Error(codes.FailedPrecondition, errHashedKeys.Error())
+	}
+	a.logger.ErrorContext(ctx, "failed to get stargazer data", "error", err)
+	return status.Error(codes.Internal, "internal error")
+}
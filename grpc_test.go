@@ -0,0 +1,81 @@
+package starquery_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/coder/starquery/starquerypb"
+)
+
+func TestGRPCServer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	api := starquery.New(ctx, starquery.Options{KV: kv.NewMemory()})
+	defer api.Close()
+	_, err := api.Import(ctx, starquery.Repo{Owner: "coder", Name: "coder"}, strings.NewReader("kylecarbs\nbpmct\n"))
+	require.NoError(t, err)
+
+	listener := bufconn.Listen(1 << 20)
+	server := api.GRPCServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := starquerypb.NewStarqueryClient(conn)
+
+	t.Run("IsStargazer", func(t *testing.T) {
+		res, err := client.IsStargazer(ctx, &starquerypb.IsStargazerRequest{Owner: "Coder", Name: "coder", Username: "KyleCarbs"})
+		require.NoError(t, err)
+		require.True(t, res.GetStarred())
+		res, err = client.IsStargazer(ctx, &starquerypb.IsStargazerRequest{Owner: "coder", Name: "coder", Username: "other"})
+		require.NoError(t, err)
+		require.False(t, res.GetStarred())
+	})
+
+	t.Run("AreStargazers", func(t *testing.T) {
+		res, err := client.AreStargazers(ctx, &starquerypb.AreStargazersRequest{
+			Owner:     "coder",
+			Name:      "coder",
+			Usernames: []string{"bpmct", "other", "kylecarbs"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []bool{true, false, true}, res.GetStarred())
+	})
+
+	t.Run("Count", func(t *testing.T) {
+		res, err := client.Count(ctx, &starquerypb.CountRequest{Owner: "coder", Name: "coder"})
+		require.NoError(t, err)
+		require.EqualValues(t, 2, res.GetCount())
+		res, err = client.Count(ctx, &starquerypb.CountRequest{Owner: "coder", Name: "other"})
+		require.NoError(t, err)
+		require.Zero(t, res.GetCount())
+	})
+
+	t.Run("InvalidArgument", func(t *testing.T) {
+		_, err := client.IsStargazer(ctx, &starquerypb.IsStargazerRequest{Owner: "coder", Name: "coder"})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = client.AreStargazers(ctx, &starquerypb.AreStargazersRequest{Owner: "coder", Usernames: []string{"bpmct"}})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = client.AreStargazers(ctx, &starquerypb.AreStargazersRequest{Owner: "coder", Name: "coder", Usernames: make([]string, 1001)})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
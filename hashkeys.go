@@ -0,0 +1,30 @@
+package starquery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// errHashedKeys is returned when listing stargazers with HashKeys, as
+// their logins can't be recovered from the keys.
+var errHashedKeys = errors.New("stargazers can't be listed when keys are hashed")
+
+// HashedKey returns the storage key for the repo with the username
+// under the given namespace prefix when keys are hashed: the prefix
+// and the hex SHA-256 of the lowercased "owner/repo/username".
+func (r Repo) HashedKey(prefix, username string) string {
+	r = r.lower()
+	sum := sha256.Sum256([]byte(r.Owner + "/" + r.Name + "/" + strings.ToLower(username)))
+	return prefix + ":" + hex.EncodeToString(sum[:])
+}
+
+// userKey returns the storage key for the repo with the username under
+// the prefix, hashed with HashKeys.
+func (a *API) userKey(prefix string, repo Repo, username string) string {
+	if a.hashKeys {
+		return repo.HashedKey(prefix, username)
+	}
+	return repo.PrefixedKey(prefix, username)
+}
@@ -0,0 +1,65 @@
+package starquery_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	store := kv.NewMemory()
+	api := starquery.New(ctx, starquery.Options{
+		KV:               store,
+		WebhookSecret:    "secret",
+		AdminToken:       "token",
+		HashKeys:         true,
+		StoreLoginCasing: true,
+	})
+	defer api.Close()
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, "KyleCarbs", "created")))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs").Code)
+
+	// The key is fixed-length and doesn't name the repo or user, but the
+	// value keeps the login.
+	key := repo.HashedKey(starquery.DefaultKeyPrefix, "kylecarbs")
+	require.Len(t, key, len(starquery.DefaultKeyPrefix)+1+64)
+	require.Equal(t, key, starquery.Repo{Owner: "Coder", Name: "CODER"}.HashedKey(starquery.DefaultKeyPrefix, "KyleCarbs"))
+	value, err := store.Get(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, "KyleCarbs", value)
+	_, err = store.Get(ctx, repo.Key("kylecarbs"))
+	require.ErrorIs(t, err, kv.ErrNotFound)
+
+	imported, err := api.Import(ctx, repo, strings.NewReader("ammar"))
+	require.NoError(t, err)
+	require.Equal(t, 1, imported)
+	require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/Ammar").Code)
+	require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/nobody").Code)
+
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, "KyleCarbs", "deleted")))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs").Code)
+
+	// Logins can't be listed from hashed keys.
+	require.Error(t, api.Export(ctx, repo, io.Discard))
+	req := httptest.NewRequest(http.MethodGet, "/coder/coder/export", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, req)
+	require.Equal(t, http.StatusNotFound, res.Code)
+}
@@ -0,0 +1,82 @@
+package starquery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+)
+
+// healthResponse is the body returned by the health endpoint.
+type healthResponse struct {
+	Status string `json:"status"`
+	// Leader is set if leader election is enabled.
+	Leader *bool `json:"leader,omitempty"`
+	// ReadOnly is set while in read-only maintenance mode.
+	ReadOnly bool `json:"read_only,omitempty"`
+	// Errors holds problems that need operator attention keyed by repo.
+	// They only affect their repos, so they don't fail the check.
+	Errors map[string]string `json:"errors,omitempty"`
+	// Warming lists the repos not fully synced yet with
+	// RequireFirstSync.
+	Warming []string `json:"warming,omitempty"`
+}
+
+// handleHealth returns 200 if the API can serve queries, and 503 with
+// the problems found otherwise. Repos that can't be fetched only mark
+// it degraded, since queries for the others still work.
+func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
+	a.healthMu.Lock()
+	resp := healthResponse{
+		Status:   "ok",
+		ReadOnly: a.readOnly.Load(),
+		Errors:   maps.Clone(a.repoErrors),
+	}
+	a.healthMu.Unlock()
+	if a.locker != nil {
+		leader := a.leader.Load()
+		resp.Leader = &leader
+	}
+
+	status := http.StatusOK
+	if len(resp.Errors) > 0 {
+		resp.Status = "degraded"
+	}
+	if a.requireFirstSync {
+		ctx, cancel := context.WithTimeout(r.Context(), a.storeTimeout)
+		warming, err := a.warmingRepos(ctx)
+		cancel()
+		if err != nil {
+			a.logger.WarnContext(r.Context(), "failed to check for warming repos", "error", err)
+			resp.Status = "unhealthy"
+			status = http.StatusServiceUnavailable
+		}
+		if len(warming) > 0 {
+			resp.Status = "warming"
+			resp.Warming = warming
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// setRepoError records the result of the last fetch for the repo.
+// Only errors that need operator attention are surfaced in health, and
+// they're only cleared once the repo is fetched successfully, not by a
+// transient error.
+func (a *API) setRepoError(repo Repo, err error) {
+	a.healthMu.Lock()
+	defer a.healthMu.Unlock()
+	var scopeErr *ScopeError
+	switch {
+	case errors.As(err, &scopeErr):
+		a.repoErrors[repo.String()] = scopeErr.Error()
+	case errors.Is(err, ErrRepoNotFound):
+		a.repoErrors[repo.String()] = ErrRepoNotFound.Error()
+	case err == nil:
+		delete(a.repoErrors, repo.String())
+	}
+}
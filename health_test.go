@@ -0,0 +1,150 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Healthy", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory()})
+		defer api.Close()
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+	})
+
+	t.Run("InsufficientScopes", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     http.Header{"X-Oauth-Scopes": []string{"gist"}},
+						Body: io.NopCloser(bytes.NewBufferString(`{
+							"data": {"repository": null},
+							"errors": [{
+								"type": "INSUFFICIENT_SCOPES",
+								"message": "Your token has not been granted the required scopes to execute this query."
+							}]
+						}`)),
+					}, nil
+				}),
+			},
+			KV:    kv.NewMemory(),
+			Repos: []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		})
+		defer api.Close()
+
+		require.Eventually(t, func() bool {
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			require.Equal(t, http.StatusOK, res.Code, "other repos can still be queried")
+			var body struct {
+				Status string            `json:"status"`
+				Errors map[string]string `json:"errors"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			if len(body.Errors) == 0 {
+				return false
+			}
+			require.Equal(t, "degraded", body.Status)
+			require.Contains(t, body.Errors["coder/coder"], "INSUFFICIENT_SCOPES")
+			return true
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("RepoNotFound", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewBufferString(`{"data": {"repository": null, "rateLimit": {"remaining": 50}}}`)),
+					}, nil
+				}),
+			},
+			KV:    kv.NewMemory(),
+			Repos: []starquery.Repo{{Owner: "coder", Name: "cdoer"}},
+		})
+		defer api.Close()
+
+		require.Eventually(t, func() bool {
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			require.Equal(t, http.StatusOK, res.Code, "other repos can still be queried")
+			var body struct {
+				Status string            `json:"status"`
+				Errors map[string]string `json:"errors"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			if len(body.Errors) == 0 {
+				return false
+			}
+			require.Equal(t, "degraded", body.Status)
+			require.Equal(t, starquery.ErrRepoNotFound.Error(), body.Errors["coder/cdoer"])
+			return true
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("TransientErrorKeepsRepoError", func(t *testing.T) {
+		t.Parallel()
+		var response atomic.Value
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					status, body := http.StatusOK, response.Load().(string)
+					if body == "" {
+						status = http.StatusBadGateway
+					}
+					return &http.Response{
+						StatusCode: status,
+						Body:       io.NopCloser(bytes.NewBufferString(body)),
+					}, nil
+				}),
+			},
+			KV:               kv.NewMemory(),
+			Repos:            []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			DisableFetchLoop: true,
+		})
+		defer api.Close()
+		repoErrors := func() map[string]string {
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			var body struct {
+				Errors map[string]string `json:"errors"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			return body.Errors
+		}
+
+		response.Store(`{"data": {"repository": null}, "errors": [{"type": "INSUFFICIENT_SCOPES", "message": "missing scopes"}]}`)
+		require.Error(t, api.Sync(context.Background()))
+		require.Contains(t, repoErrors()["coder/coder"], "INSUFFICIENT_SCOPES")
+
+		// GitHub failing doesn't mean the token was fixed.
+		response.Store("")
+		require.Error(t, api.Sync(context.Background()))
+		require.Contains(t, repoErrors()["coder/coder"], "INSUFFICIENT_SCOPES")
+
+		response.Store(`{"data": {"repository": {"stargazers": {"edges": []}}, "rateLimit": {"remaining": 50}}}`)
+		require.NoError(t, api.Sync(context.Background()))
+		require.Empty(t, repoErrors())
+	})
+}
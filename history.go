@@ -0,0 +1,193 @@
+package starquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultHistoryRetention is how long daily star counts are kept by
+	// default.
+	DefaultHistoryRetention = 90 * 24 * time.Hour
+	// defaultHistoryDays is the number of days returned by the history
+	// endpoint when none are requested.
+	defaultHistoryDays = 30
+	// historyDateLayout formats the UTC day a star count was recorded.
+	historyDateLayout = "2006-01-02"
+)
+
+// starCountKey returns the storage key for the repo's star count on
+// the UTC day of t.
+func (a *API) starCountKey(repo Repo, t time.Time) string {
+	return fmt.Sprintf("%s:starcount:%s:%s", a.keyPrefix, repo.lower(), t.UTC().Format(historyDateLayout))
+}
+
+// recordStarCount stores the repo's current star count for today,
+// replacing any count already recorded today.
+func (a *API) recordStarCount(ctx context.Context, repo Repo) error {
+	count, err := a.fetchStarCount(ctx, repo)
+	if err != nil {
+		return fmt.Errorf("fetch star count: %w", err)
+	}
+	pair := [2]string{a.starCountKey(repo, a.clock.Now()), strconv.Itoa(count)}
+	if err := a.kv.Setex(ctx, uint(a.historyRetention.Seconds()), [][2]string{pair}); err != nil {
+		return fmt.Errorf("store star count: %w", err)
+	}
+	if a.onMilestone != nil {
+		if err := a.checkMilestones(ctx, repo, count); err != nil {
+			return fmt.Errorf("check milestones: %w", err)
+		}
+	}
+	return nil
+}
+
+// fetchStarCount fetches the repo's total star count using the
+// configured fetch strategy.
+func (a *API) fetchStarCount(ctx context.Context, repo Repo) (int, error) {
+	switch a.fetchStrategy {
+	case FetchREST:
+		return a.fetchStarCountFromREST(ctx, repo)
+	case FetchAuto:
+		count, err := a.fetchStarCountFromGitHub(ctx, repo)
+		if err == nil || !graphQLUnavailable(err) {
+			return count, err
+		}
+		return a.fetchStarCountFromREST(ctx, repo)
+	default:
+		return a.fetchStarCountFromGitHub(ctx, repo)
+	}
+}
+
+// fetchStarCountFromGitHub fetches the repo's star count from GitHub's
+// GraphQL API.
+func (a *API) fetchStarCountFromGitHub(ctx context.Context, repo Repo) (int, error) {
+	reqBody, err := json.Marshal(map[string]any{
+		"query": `
+	query($owner: String!, $name: String!) {
+		repository(owner: $owner, name: $name) {
+			stargazerCount
+		}
+	}`,
+		"variables": map[string]string{
+			"owner": repo.Owner,
+			"name":  repo.Name,
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("marshal query: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.graphQLTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.github.com/graphql", bytes.NewReader(reqBody))
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", a.userAgent)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+	defer closeOnDone(ctx, resp.Body)()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, statusError(resp.StatusCode)
+	}
+
+	var response struct {
+		Data struct {
+			Repository struct {
+				StargazerCount int `json:"stargazerCount"`
+			} `json:"repository"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("decode response: %w", err)
+	}
+	if len(response.Errors) > 0 {
+		return 0, fmt.Errorf("graphql error (%s): %s", response.Errors[0].Type, response.Errors[0].Message)
+	}
+	return response.Data.Repository.StargazerCount, nil
+}
+
+// fetchStarCountFromREST fetches the repo's star count from GitHub's
+// REST API.
+func (a *API) fetchStarCountFromREST(ctx context.Context, repo Repo) (int, error) {
+	ghRepo, _, err := a.github.Repositories.Get(ctx, repo.Owner, repo.Name)
+	if err != nil {
+		return 0, fmt.Errorf("get repo: %w", err)
+	}
+	return ghRepo.GetStargazersCount(), nil
+}
+
+// historyResponse is returned by the history endpoint.
+type historyResponse struct {
+	Repo string       `json:"repo"`
+	Days []historyDay `json:"days"`
+}
+
+// historyDay is the star count recorded on a UTC day. Count is nil if
+// none was recorded, e.g. because no fetch succeeded that day.
+type historyDay struct {
+	Date  string `json:"date"`
+	Count *int   `json:"count"`
+}
+
+// handleHistory returns the repo's daily star counts for the last
+// ?days= days (default 30), oldest first and ending today.
+func (a *API) handleHistory(w http.ResponseWriter, r *http.Request) {
+	repo := Repo{Owner: r.PathValue("org"), Name: r.PathValue("repo")}
+
+	days := defaultHistoryDays
+	if raw := r.URL.Query().Get("days"); raw != "" {
+		var err error
+		days, err = strconv.Atoi(raw)
+		maxDays := int(a.historyRetention / (24 * time.Hour))
+		if err != nil || days < 1 || days > maxDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxDays), http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := a.clock.Now().UTC()
+	resp := historyResponse{
+		Repo: repo.String(),
+		Days: make([]historyDay, days),
+	}
+	keys := make([]string, days)
+	for i := range days {
+		day := now.AddDate(0, 0, i-days+1)
+		resp.Days[i].Date = day.Format(historyDateLayout)
+		keys[i] = a.starCountKey(repo, day)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), a.storeTimeout)
+	defer cancel()
+	values, err := a.kv.MGet(ctx, keys)
+	if err != nil {
+		a.writeStoreError(w, r, err)
+		return
+	}
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			a.logger.WarnContext(r.Context(), "invalid star count", "key", keys[i], "value", value)
+			continue
+		}
+		resp.Days[i].Count = &count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
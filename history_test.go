@@ -0,0 +1,88 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	type history struct {
+		Repo string `json:"repo"`
+		Days []struct {
+			Date  string `json:"date"`
+			Count *int   `json:"count"`
+		} `json:"days"`
+	}
+
+	newAPI := func(t *testing.T, store kv.Store) *starquery.API {
+		gh := &fakeGitHub{starCount: 42}
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: gh.client(),
+			KV:     store,
+			Repos:  []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		})
+		t.Cleanup(api.Close)
+		return api
+	}
+
+	t.Run("Series", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		twoDaysAgo := time.Now().UTC().AddDate(0, 0, -2).Format("2006-01-02")
+		err := store.Setex(ctx, 60, [][2]string{{"stargazers:starcount:coder/coder:" + twoDaysAgo, "40"}})
+		require.NoError(t, err)
+		api := newAPI(t, store)
+
+		var resp history
+		require.Eventually(t, func() bool {
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/history?days=3", nil))
+			if res.Code != http.StatusOK {
+				return false
+			}
+			resp = history{}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+			return len(resp.Days) == 3 && resp.Days[2].Count != nil
+		}, time.Second, time.Millisecond)
+
+		require.Equal(t, "coder/coder", resp.Repo)
+		require.Equal(t, twoDaysAgo, resp.Days[0].Date)
+		require.Equal(t, 40, *resp.Days[0].Count)
+		// Days without a recorded count are gaps.
+		require.Nil(t, resp.Days[1].Count)
+		require.Equal(t, time.Now().UTC().Format("2006-01-02"), resp.Days[2].Date)
+		require.Equal(t, 42, *resp.Days[2].Count)
+	})
+
+	t.Run("DefaultDays", func(t *testing.T) {
+		t.Parallel()
+		api := newAPI(t, kv.NewMemory())
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/history", nil))
+		require.Equal(t, http.StatusOK, res.Code)
+		var resp history
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+		require.Len(t, resp.Days, 30)
+	})
+
+	t.Run("InvalidDays", func(t *testing.T) {
+		t.Parallel()
+		api := newAPI(t, kv.NewMemory())
+		for _, days := range []string{"0", "-1", "abc", "91"} {
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/history?days="+days, nil))
+			require.Equal(t, http.StatusBadRequest, res.Code, days)
+		}
+	})
+}
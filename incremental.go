@@ -0,0 +1,158 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coder/starquery/kv"
+)
+
+const (
+	// DefaultFullSyncInterval is how often incremental syncs fall back
+	// to a full sync by default.
+	DefaultFullSyncInterval = 6 * time.Hour
+	// highWaterMargin is subtracted from the sync start time when
+	// recording the high-water mark, to allow for clock skew between
+	// GitHub and us.
+	highWaterMargin = 5 * time.Minute
+)
+
+// starredAtDesc is the GraphQL StarOrder listing the newest stars first.
+var starredAtDesc = map[string]string{"field": "STARRED_AT", "direction": "DESC"}
+
+// highWaterKey returns the storage key holding the time before which
+// every star of the repo has been stored.
+func (a *API) highWaterKey(repo Repo) string {
+	return a.keyPrefix + ":highwater:" + repo.lower().String()
+}
+
+// fullSyncKey returns the storage key that exists until the repo's
+// next full sync is due.
+func (a *API) fullSyncKey(repo Repo) string {
+	return a.keyPrefix + ":fullsync:" + repo.lower().String()
+}
+
+// fullPassKey returns the storage key holding when a sync last walked
+// every page of the repo's stargazers.
+func (a *API) fullPassKey(repo Repo) string {
+	return a.keyPrefix + ":fullpass:" + repo.lower().String()
+}
+
+// fetchRecentStargazersFromGitHub fetches stargazers for the given repo
+// from GitHub, newest first.
+func (a *API) fetchRecentStargazersFromGitHub(ctx context.Context, repo Repo, cursor string) ([]Stargazer, time.Time, int, error) {
+	return a.fetchRelationFromGitHub(ctx, stars, repo, cursor, starredAtDesc)
+}
+
+// syncStargazers fetches and stores the repo's stargazers, returning the
+// number stored. With IncrementalSync, only stars since the last sync
+// are fetched unless a full sync is due. Full syncs refresh the TTL of
+// older stargazers, which incremental syncs never see, and are forced
+// once the last full pass is older than MaxSyncAge.
+func (a *API) syncStargazers(ctx context.Context, repo Repo) (int, *StarDelta, error) {
+	if !a.incrementalSync {
+		return a.fullPass(ctx, repo)
+	}
+	start := a.clock.Now()
+	since, err := a.incrementalSince(ctx, repo)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	full := since.IsZero()
+	if !full {
+		if full, err = a.reconciliationDue(ctx, repo); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var stored int
+	var delta *StarDelta
+	if !full {
+		stored, err = a.paginate(ctx, repo, a.fetchRecentStargazersFromGitHub, since)
+		if err != nil && a.fetchStrategy == FetchAuto && graphQLUnavailable(err) {
+			a.logger.Warn("graphql unavailable, falling back to a full rest sync", "repo", repo, "error", err)
+			full = true
+		}
+	}
+	if full {
+		stored, delta, err = a.fullPass(ctx, repo)
+	}
+	if err != nil {
+		return stored, delta, err
+	}
+
+	pairs := [][2]string{{a.highWaterKey(repo), start.Add(-highWaterMargin).UTC().Format(time.RFC3339)}}
+	if err := a.kv.Setex(ctx, uint(a.ttl.Seconds()), pairs); err != nil {
+		return stored, delta, fmt.Errorf("store high-water mark: %w", err)
+	}
+	if full {
+		pairs := [][2]string{{a.fullSyncKey(repo), stargazerValue}}
+		if err := a.kv.Setex(ctx, uint(a.fullSyncInterval.Seconds()), pairs); err != nil {
+			return stored, delta, fmt.Errorf("store full sync time: %w", err)
+		}
+	}
+	return stored, delta, nil
+}
+
+// fullPass fetches every page of the repo's stargazers, recording when
+// it finished for MaxSyncAge. A capped sync finishes the pass when a
+// later sync resumes it and reaches the last page.
+func (a *API) fullPass(ctx context.Context, repo Repo) (int, *StarDelta, error) {
+	stored, delta, err := a.fetchByRepo(ctx, repo)
+	if err != nil || a.maxSyncAge <= 0 {
+		return stored, delta, err
+	}
+	pairs := [][2]string{{a.fullPassKey(repo), a.clock.Now().UTC().Format(time.RFC3339)}}
+	if err := a.kv.Setex(ctx, uint(max(a.ttl, 2*a.maxSyncAge).Seconds()), pairs); err != nil {
+		return stored, delta, fmt.Errorf("store full pass time: %w", err)
+	}
+	return stored, delta, nil
+}
+
+// reconciliationDue reports whether the repo hasn't completed a full
+// pass within MaxSyncAge, logging the forced reconciliation if so.
+func (a *API) reconciliationDue(ctx context.Context, repo Repo) (bool, error) {
+	if a.maxSyncAge <= 0 {
+		return false, nil
+	}
+	value, err := a.kv.Get(ctx, a.fullPassKey(repo))
+	if errors.Is(err, kv.ErrNotFound) {
+		a.logger.Info("forcing a full reconciliation sync", "repo", repo, "max_sync_age", a.maxSyncAge, "last_full_pass", "never")
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get full pass time: %w", err)
+	}
+	last, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		a.logger.Warn("invalid full pass time, forcing a full reconciliation sync", "repo", repo, "value", value)
+		return true, nil
+	}
+	age := a.clock.Now().Sub(last)
+	if age < a.maxSyncAge {
+		return false, nil
+	}
+	a.logger.Info("forcing a full reconciliation sync", "repo", repo, "max_sync_age", a.maxSyncAge, "last_full_pass", last, "age", age)
+	return true, nil
+}
+
+// incrementalSince returns the high-water mark to sync the repo from,
+// or the zero time if a full sync is due.
+func (a *API) incrementalSince(ctx context.Context, repo Repo) (time.Time, error) {
+	values, err := a.kv.MGet(ctx, []string{a.fullSyncKey(repo), a.highWaterKey(repo)})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get sync state: %w", err)
+	}
+	if values[0] == "" || values[1] == "" {
+		return time.Time{}, nil
+	}
+	since, err := time.Parse(time.RFC3339, values[1])
+	if err != nil {
+		a.logger.Warn("invalid high-water mark, running a full sync", "repo", repo, "value", values[1])
+		return time.Time{}, nil
+	}
+	return since, nil
+}
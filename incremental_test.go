@@ -0,0 +1,182 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrementalSync(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	store := kv.NewMemoryWithClock(clk)
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+
+	var mu sync.Mutex
+	var fullPages, recentPages []string
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				var body struct {
+					Query     string `json:"query"`
+					Variables struct {
+						After   string            `json:"after"`
+						OrderBy map[string]string `json:"orderBy"`
+					} `json:"variables"`
+				}
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					return nil, err
+				}
+				edges := "[]"
+				if strings.Contains(body.Query, "stargazers(") {
+					mu.Lock()
+					if body.Variables.OrderBy == nil {
+						fullPages = append(fullPages, body.Variables.After)
+					} else {
+						recentPages = append(recentPages, body.Variables.After)
+						// The second star predates the last sync, so paging stops.
+						edges = fmt.Sprintf(`[
+							{"node": {"login": "new"}, "cursor": "c1", "starredAt": %q},
+							{"node": {"login": "old"}, "cursor": "c2", "starredAt": %q}
+						]`, start.Add(10*time.Minute).Format(time.RFC3339), start.Add(-time.Hour).Format(time.RFC3339))
+					}
+					mu.Unlock()
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body: io.NopCloser(strings.NewReader(fmt.Sprintf(
+						`{"data": {"repository": {"stargazers": {"edges": %s}, "stargazerCount": 1}, "rateLimit": {"remaining": 5000}}}`, edges))),
+				}, nil
+			}),
+		},
+		KV:              store,
+		Repos:           []starquery.Repo{repo},
+		Clock:           clk,
+		IncrementalSync: true,
+	})
+	defer api.Close()
+
+	syncs := func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(fullPages), len(recentPages)
+	}
+	highWater := func() string {
+		value, _ := store.Get(context.Background(), "stargazers:highwater:coder/coder")
+		return value
+	}
+
+	// Without a high-water mark, the first sync is a full one.
+	require.Eventually(t, func() bool {
+		return highWater() == start.Add(-5*time.Minute).Format(time.RFC3339)
+	}, 5*time.Second, time.Millisecond)
+	full, recent := syncs()
+	require.Equal(t, 1, full)
+	require.Zero(t, recent)
+
+	clk.Advance(starquery.DefaultFetchInterval)
+	require.Eventually(t, func() bool {
+		return highWater() == start.Add(starquery.DefaultFetchInterval-5*time.Minute).Format(time.RFC3339)
+	}, 5*time.Second, time.Millisecond)
+	full, recent = syncs()
+	require.Equal(t, 1, full)
+	require.Equal(t, 1, recent, "paging stops at stars older than the high-water mark")
+	_, err := store.Get(context.Background(), repo.Key("new"))
+	require.NoError(t, err)
+
+	// Once the full sync interval passes, the next sync is a full one.
+	clk.Advance(starquery.DefaultFullSyncInterval)
+	require.Eventually(t, func() bool {
+		full, _ := syncs()
+		return full == 2
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestMaxSyncAge(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := kv.NewMemoryWithClock(clk)
+
+	var mu sync.Mutex
+	var fullPages, recentPages int
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				var body struct {
+					Query     string `json:"query"`
+					Variables struct {
+						OrderBy map[string]string `json:"orderBy"`
+					} `json:"variables"`
+				}
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					return nil, err
+				}
+				if strings.Contains(body.Query, "stargazers(") {
+					mu.Lock()
+					if body.Variables.OrderBy == nil {
+						fullPages++
+					} else {
+						recentPages++
+					}
+					mu.Unlock()
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"data": {"repository": {"stargazers": {"edges": []}, "stargazerCount": 0}, "rateLimit": {"remaining": 5000}}}`)),
+				}, nil
+			}),
+		},
+		KV:               store,
+		Repos:            []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		Clock:            clk,
+		IncrementalSync:  true,
+		TTL:              30 * 24 * time.Hour,
+		FullSyncInterval: 7 * 24 * time.Hour,
+		MaxSyncAge:       time.Hour,
+	})
+	defer api.Close()
+
+	syncs := func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return fullPages, recentPages
+	}
+	require.Eventually(t, func() bool {
+		full, _ := syncs()
+		return full == 1
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, err := store.Get(context.Background(), "stargazers:fullpass:coder/coder")
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+
+	clk.Advance(starquery.DefaultFetchInterval)
+	require.Eventually(t, func() bool {
+		_, recent := syncs()
+		return recent == 1
+	}, 5*time.Second, time.Millisecond)
+
+	// The full sync interval is far off, but the last full pass is now
+	// older than MaxSyncAge.
+	clk.Advance(time.Hour)
+	require.Eventually(t, func() bool {
+		full, _ := syncs()
+		return full == 2
+	}, 5*time.Second, time.Millisecond)
+	_, recent := syncs()
+	require.Equal(t, 1, recent, "the forced sync isn't an incremental one")
+}
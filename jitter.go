@@ -0,0 +1,37 @@
+package starquery
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// ttlJitterBuckets is the number of distinct TTLs setexJittered spreads
+// keys over, so a batch takes at most that many writes.
+const ttlJitterBuckets = 8
+
+// setexJittered stores the pairs with the TTL varied per key by up to
+// the TTL jitter either way, so keys written together, e.g. a page of
+// stargazers, don't all expire together and leave queries missing
+// until the next sync writes them again.
+func (a *API) setexJittered(ctx context.Context, ttl time.Duration, pairs [][2]string) error {
+	if a.ttlJitter <= 0 || len(pairs) == 0 {
+		return a.kv.Setex(ctx, uint(ttl.Seconds()), pairs)
+	}
+	var buckets [ttlJitterBuckets][][2]string
+	for _, pair := range pairs {
+		i := rand.IntN(ttlJitterBuckets)
+		buckets[i] = append(buckets[i], pair)
+	}
+	for i, bucket := range buckets {
+		if len(bucket) == 0 {
+			continue
+		}
+		// The buckets' TTLs are spaced evenly across the jitter band.
+		offset := a.ttlJitter * (2*float64(i)/(ttlJitterBuckets-1) - 1)
+		if err := a.kv.Setex(ctx, uint(ttl.Seconds()*(1+offset)), bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package starquery_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLJitter(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	logins := make([]string, 200)
+	for i := range logins {
+		logins[i] = fmt.Sprintf("user%d", i)
+	}
+	ttls := func(opts starquery.Options) map[time.Duration]int {
+		ctx := context.Background()
+		store := kv.NewMemory()
+		opts.KV = store
+		api := starquery.New(ctx, opts)
+		defer api.Close()
+		_, err := api.Import(ctx, repo, strings.NewReader(strings.Join(logins, "\n")))
+		require.NoError(t, err)
+
+		counts := make(map[time.Duration]int)
+		for _, login := range logins {
+			ttl, err := store.TTL(ctx, repo.Key(login))
+			require.NoError(t, err)
+			counts[ttl.Round(time.Minute)]++
+		}
+		return counts
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		counts := ttls(starquery.Options{})
+		require.Greater(t, len(counts), 1, "every stargazer has the same TTL")
+		for ttl := range counts {
+			require.GreaterOrEqual(t, ttl, time.Duration(float64(starquery.DefaultTTL)*(1-starquery.DefaultTTLJitter)))
+			require.LessOrEqual(t, ttl, time.Duration(float64(starquery.DefaultTTL)*(1+starquery.DefaultTTLJitter)))
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		counts := ttls(starquery.Options{TTLJitter: -1})
+		require.Equal(t, map[time.Duration]int{starquery.DefaultTTL: len(logins)}, counts)
+	})
+}
@@ -2,78 +2,88 @@ package kv
 
 import (
 	"context"
-	"sync"
+	"errors"
+	"io"
+	"time"
+)
 
-	"github.com/coder/redjet"
+var (
+	// ErrNotFound is returned by Get when the key does not exist.
+	ErrNotFound = errors.New("kv: key not found")
+	// ErrUnavailable wraps errors caused by the backend being unreachable
+	// or temporarily overloaded. Retrying later may succeed.
+	ErrUnavailable = errors.New("kv: store unavailable")
 )
 
 type Store interface {
+	// Setex stores the pairs, replacing both the value and the expiry
+	// of keys that already exist, like Redis' SET with EX.
 	Setex(ctx context.Context, seconds uint, pairs [][2]string) error
+	// Get returns ErrNotFound if the key does not exist.
 	Get(ctx context.Context, key string) (string, error)
+	// MGet returns the values for keys in order. Missing keys
+	// have an empty value.
+	MGet(ctx context.Context, keys []string) ([]string, error)
+	// TTL returns how long until the key expires, a negative duration
+	// if it never expires, or ErrNotFound if the key does not exist.
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	// Delete does not return an error if the key does not exist.
 	Delete(ctx context.Context, key string) error
+	// Ping checks that the store is reachable.
+	Ping(ctx context.Context) error
 }
 
-func NewRedis(addr string) Store {
-	return &redis{
-		Client: redjet.New(addr),
-	}
-}
-
-type redis struct {
-	Client *redjet.Client
-}
-
-func (r *redis) Setex(ctx context.Context, seconds uint, pairs [][2]string) error {
-	var p *redjet.Pipeline
-	for _, pair := range pairs {
-		p = r.Client.Pipeline(ctx, p, "SET", pair[0], pair[1], "EX", seconds)
-	}
-	return p.Ok()
-}
-
-func (r *redis) Get(ctx context.Context, key string) (string, error) {
-	return r.Client.Command(ctx, "GET", key).String()
-}
-
-func (r *redis) Delete(ctx context.Context, key string) error {
-	// DEL returns the number of records deleted.
-	// We don't care if it exists or not for our impl.
-	_, err := r.Client.Command(ctx, "DEL", key).Int()
-	if err != nil {
-		return err
-	}
-	return nil
+// Expirer is implemented by stores that can change a key's expiry
+// without rewriting its value.
+type Expirer interface {
+	// Expire makes the key expire after ttl, whether that's sooner or
+	// later than before. It returns ErrNotFound if the key does not
+	// exist.
+	Expire(ctx context.Context, key string, ttl time.Duration) error
 }
 
-func NewMemory() Store {
-	return &memory{
-		data: make(map[string]string),
-	}
+// Locker is implemented by stores that support distributed locks.
+type Locker interface {
+	// Lock acquires the lock named key for owner, or extends it if owner
+	// already holds it, until ttl elapses. It reports whether owner
+	// holds the lock.
+	Lock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// Unlock releases the lock if owner holds it.
+	Unlock(ctx context.Context, key, owner string) error
 }
 
-type memory struct {
-	data map[string]string
-	mu   sync.RWMutex
+// Scanner is implemented by stores that can list their keys.
+type Scanner interface {
+	// Keys returns every unexpired key that starts with prefix, in no
+	// particular order.
+	Keys(ctx context.Context, prefix string) ([]string, error)
 }
 
-func (m *memory) Setex(ctx context.Context, seconds uint, pairs [][2]string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	for _, pair := range pairs {
-		m.data[pair[0]] = pair[1]
-	}
-	return nil
+// Counter is implemented by stores that can count keys without
+// listing them.
+type Counter interface {
+	// Count returns the number of keys that start with prefix. It may
+	// include expired keys that haven't been removed yet.
+	Count(ctx context.Context, prefix string) (int, error)
 }
 
-func (m *memory) Get(ctx context.Context, key string) (string, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.data[key], nil
+// PubSub is implemented by stores that can broadcast messages to every
+// process sharing them.
+type PubSub interface {
+	// Publish sends message to the current subscribers of channel.
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe calls fn with each message published to channel until
+	// ctx is done or the subscription fails. Messages published while
+	// not subscribed are lost.
+	Subscribe(ctx context.Context, channel string, fn func(message string)) error
 }
 
-func (m *memory) Delete(ctx context.Context, key string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	delete(m.data, key)
-	return nil
+// Snapshotter is implemented by stores that can save their contents, so
+// a store without its own persistence survives restarts.
+type Snapshotter interface {
+	// Snapshot writes every unexpired key with its value and expiry.
+	Snapshot(w io.Writer) error
+	// Restore stores the unexpired keys of a snapshot, replacing any
+	// existing values.
+	Restore(r io.Reader) error
 }
@@ -1,11 +1,17 @@
 package kv_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/coder/starquery/clock"
 	"github.com/coder/starquery/kv"
 )
 
@@ -55,15 +61,343 @@ func TestMemoryStore(t *testing.T) {
 			t.Fatalf("Delete() error = %v", err)
 		}
 
-		got, err := store.Get(ctx, key)
-		if err != nil {
-			t.Fatalf("Get() error = %v", err)
+		_, err := store.Get(ctx, key)
+		if !errors.Is(err, kv.ErrNotFound) {
+			t.Errorf("Get() error = %v, want %v", err, kv.ErrNotFound)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+
+		got, err := store.Get(context.Background(), "missing")
+		if !errors.Is(err, kv.ErrNotFound) {
+			t.Errorf("Get() error = %v, want %v", err, kv.ErrNotFound)
 		}
 		if got != "" {
 			t.Errorf("Get() = %q, want empty string", got)
 		}
 	})
 
+	t.Run("MGet", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		ctx := context.Background()
+
+		if err := store.Setex(ctx, 1, [][2]string{{"key1", "value1"}, {"key3", "value3"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+
+		got, err := store.MGet(ctx, []string{"key1", "key2", "key3"})
+		if err != nil {
+			t.Fatalf("MGet() error = %v", err)
+		}
+		want := []string{"value1", "", "value3"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("MGet() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("MGetChunks", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		ctx := context.Background()
+
+		// Enough keys to span several of the chunks MGet locks for.
+		keys := make([]string, 1000)
+		var pairs [][2]string
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key%d", i)
+			if i%3 == 0 {
+				pairs = append(pairs, [2]string{keys[i], keys[i]})
+			}
+		}
+		if err := store.Setex(ctx, 60, pairs); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+
+		got, err := store.MGet(ctx, keys)
+		if err != nil {
+			t.Fatalf("MGet() error = %v", err)
+		}
+		for i, value := range got {
+			want := ""
+			if i%3 == 0 {
+				want = keys[i]
+			}
+			if value != want {
+				t.Fatalf("MGet()[%d] = %q, want %q", i, value, want)
+			}
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		ctx := context.Background()
+
+		if err := store.Setex(ctx, 60, [][2]string{{"key", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		ttl, err := store.TTL(ctx, "key")
+		if err != nil {
+			t.Fatalf("TTL() error = %v", err)
+		}
+		if ttl <= 0 || ttl > time.Minute {
+			t.Errorf("TTL() = %s, want within a minute", ttl)
+		}
+		if _, err := store.TTL(ctx, "missing"); !errors.Is(err, kv.ErrNotFound) {
+			t.Errorf("TTL() error = %v, want %v", err, kv.ErrNotFound)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		ctx := context.Background()
+
+		if err := store.Setex(ctx, 0, [][2]string{{"key", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		if _, err := store.Get(ctx, "key"); !errors.Is(err, kv.ErrNotFound) {
+			t.Errorf("Get() error = %v, want %v", err, kv.ErrNotFound)
+		}
+		got, err := store.MGet(ctx, []string{"key"})
+		if err != nil {
+			t.Fatalf("MGet() error = %v", err)
+		}
+		if got[0] != "" {
+			t.Errorf("MGet() = %q, want expired key to be empty", got)
+		}
+	})
+
+	t.Run("ExpiryWithClock", func(t *testing.T) {
+		t.Parallel()
+		clk := clock.NewFake(time.Now())
+		store := kv.NewMemoryWithClock(clk)
+		ctx := context.Background()
+
+		if err := store.Setex(ctx, uint((24 * time.Hour).Seconds()), [][2]string{{"key", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		clk.Advance(24*time.Hour - time.Second)
+		ttl, err := store.TTL(ctx, "key")
+		if err != nil {
+			t.Fatalf("TTL() error = %v", err)
+		}
+		if ttl != time.Second {
+			t.Errorf("TTL() = %s, want %s", ttl, time.Second)
+		}
+		clk.Advance(time.Second)
+		if _, err := store.Get(ctx, "key"); !errors.Is(err, kv.ErrNotFound) {
+			t.Errorf("Get() error = %v, want %v", err, kv.ErrNotFound)
+		}
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		t.Parallel()
+		clk := clock.NewFake(time.Now())
+		store := kv.NewMemoryWithClock(clk)
+		ctx := context.Background()
+
+		if err := store.Setex(ctx, 60, [][2]string{{"short", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		if err := store.Setex(ctx, 3600, [][2]string{{"long", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		var buf bytes.Buffer
+		if err := store.(kv.Snapshotter).Snapshot(&buf); err != nil {
+			t.Fatalf("Snapshot() error = %v", err)
+		}
+
+		// Keys that expired while the snapshot was stored aren't restored.
+		clk.Advance(time.Minute)
+		restored := kv.NewMemoryWithClock(clk)
+		if err := restored.(kv.Snapshotter).Restore(&buf); err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		if _, err := restored.Get(ctx, "short"); !errors.Is(err, kv.ErrNotFound) {
+			t.Errorf("Get() error = %v, want %v", err, kv.ErrNotFound)
+		}
+		ttl, err := restored.TTL(ctx, "long")
+		if err != nil {
+			t.Fatalf("TTL() error = %v", err)
+		}
+		if ttl != 59*time.Minute {
+			t.Errorf("TTL() = %s, want %s", ttl, 59*time.Minute)
+		}
+	})
+
+	t.Run("Keys", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		ctx := context.Background()
+
+		if err := store.Setex(ctx, 1, [][2]string{{"a/1", "v"}, {"a/2", "v"}, {"b/1", "v"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+
+		got, err := store.(kv.Scanner).Keys(ctx, "a/")
+		if err != nil {
+			t.Fatalf("Keys() error = %v", err)
+		}
+		slices.Sort(got)
+		want := []string{"a/1", "a/2"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Keys() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		ctx := context.Background()
+
+		if err := store.Setex(ctx, 1, [][2]string{{"key1", "value1"}, {"key2", "value2"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		if err := store.Setex(ctx, 1, [][2]string{{"key1", "v"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		if err := store.Delete(ctx, "key2"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		_, _ = store.Get(ctx, "key1")
+		_, _ = store.Get(ctx, "key2")
+		_, _ = store.MGet(ctx, []string{"key1", "key3"})
+
+		got := store.(interface{ Stats() kv.MemoryStats }).Stats()
+		want := kv.MemoryStats{Entries: 1, Bytes: 5, Hits: 2, Misses: 2}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Stats() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Compact", func(t *testing.T) {
+		t.Parallel()
+		c := clock.NewFake(time.Unix(0, 0))
+		store := kv.NewMemoryWithClock(c)
+		ctx := context.Background()
+
+		var churn [][2]string
+		for i := range 10 {
+			churn = append(churn, [2]string{fmt.Sprintf("churn%d", i), "value"})
+		}
+		if err := store.Setex(ctx, 60, churn); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		if err := store.Setex(ctx, 3600, [][2]string{{"kept1", "value"}, {"kept2", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		c.Advance(time.Minute)
+
+		compactor := store.(interface{ Compact() kv.MemoryCompaction })
+		want := kv.MemoryCompaction{Time: c.Now(), Removed: 10, Shrunk: true}
+		if got := compactor.Compact(); got != want {
+			t.Errorf("Compact() = %+v, want %+v", got, want)
+		}
+		stats := store.(interface{ Stats() kv.MemoryStats }).Stats()
+		if stats.Entries != 2 || stats.LastCompaction != want {
+			t.Errorf("Stats() = %+v, want 2 entries and the compaction", stats)
+		}
+		if got, err := store.Get(ctx, "kept1"); err != nil || got != "value" {
+			t.Errorf("Get(kept1) = %q, %v, want it kept", got, err)
+		}
+
+		// Nothing left to reclaim.
+		if got := compactor.Compact(); got.Removed != 0 || got.Shrunk {
+			t.Errorf("Compact() = %+v, want nothing removed", got)
+		}
+	})
+
+	t.Run("CompactMemory", func(t *testing.T) {
+		t.Parallel()
+		c := clock.NewFake(time.Unix(0, 0))
+		store := kv.NewMemoryWithClock(c)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			kv.CompactMemory(ctx, store, time.Minute)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		if err := store.Setex(ctx, 30, [][2]string{{"key", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		for c.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		c.Advance(time.Minute)
+		stats := store.(interface{ Stats() kv.MemoryStats })
+		deadline := time.Now().Add(time.Second)
+		for stats.Stats().LastCompaction.Removed != 1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Stats() = %+v, want the expired entry compacted", stats.Stats())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	t.Run("MaxEntriesPerGroup", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemoryWithOptions(kv.MemoryOptions{
+			Clock:              clock.NewFake(time.Unix(0, 0)),
+			MaxEntriesPerGroup: 3,
+		})
+		ctx := context.Background()
+
+		if err := store.Setex(ctx, 60, [][2]string{{"s:coder/small/a", "true"}, {"s:coder/small/b", "true"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		// Later big repo entries expire later, so the earlier ones are
+		// evicted, and never the small repo's.
+		for i := range 10 {
+			if err := store.Setex(ctx, uint(100+i), [][2]string{{fmt.Sprintf("s:coder/big/user%d", i), "true"}}); err != nil {
+				t.Fatalf("Setex() error = %v", err)
+			}
+		}
+		// Overwriting a key of a full group doesn't evict another.
+		if err := store.Setex(ctx, 200, [][2]string{{"s:coder/big/user9", "true"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		// Keys without a group aren't capped.
+		for i := range 5 {
+			if err := store.Setex(ctx, 60, [][2]string{{fmt.Sprintf("meta%d", i), "true"}}); err != nil {
+				t.Fatalf("Setex() error = %v", err)
+			}
+		}
+
+		var keys []string
+		for _, prefix := range []string{"s:coder/big/", "s:coder/small/", "meta"} {
+			found, err := store.(kv.Scanner).Keys(ctx, prefix)
+			if err != nil {
+				t.Fatalf("Keys() error = %v", err)
+			}
+			slices.Sort(found)
+			keys = append(keys, found...)
+		}
+		want := []string{
+			"s:coder/big/user7", "s:coder/big/user8", "s:coder/big/user9",
+			"s:coder/small/a", "s:coder/small/b",
+			"meta0", "meta1", "meta2", "meta3", "meta4",
+		}
+		if !slices.Equal(keys, want) {
+			t.Errorf("Keys() = %q, want %q", keys, want)
+		}
+
+		stats := store.(interface{ Stats() kv.MemoryStats }).Stats()
+		wantGroups := map[string]int{"s:coder/big": 3, "s:coder/small": 2}
+		if !reflect.DeepEqual(stats.GroupEntries, wantGroups) || stats.Evicted != 7 {
+			t.Errorf("Stats() = %+v, want groups %v and 7 evicted", stats, wantGroups)
+		}
+	})
+
 	t.Run("ConcurrentAccess", func(t *testing.T) {
 		t.Parallel()
 		store := kv.NewMemory()
@@ -98,3 +432,82 @@ func TestMemoryStore(t *testing.T) {
 		wg.Wait()
 	})
 }
+
+func BenchmarkMemoryGetDuringBulkSetex(b *testing.B) {
+	store := kv.NewMemory()
+	ctx := context.Background()
+
+	pairs := make([][2]string, 100_000)
+	for i := range pairs {
+		pairs[i] = [2]string{fmt.Sprintf("bulk-key-%d", i), "true"}
+	}
+	if err := store.Setex(ctx, 60, [][2]string{{"read-key", "true"}}); err != nil {
+		b.Fatalf("Setex() error = %v", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := store.Setex(ctx, 60, pairs); err != nil {
+				b.Errorf("Setex() error = %v", err)
+				return
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Get(ctx, "read-key"); err != nil {
+			b.Fatalf("Get() error = %v", err)
+		}
+	}
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkMemorySetexDuringBulkMGet(b *testing.B) {
+	store := kv.NewMemory()
+	ctx := context.Background()
+
+	keys := make([]string, 100_000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("bulk-key-%d", i)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := store.MGet(ctx, keys); err != nil {
+				b.Errorf("MGet() error = %v", err)
+				return
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := store.Setex(ctx, 60, [][2]string{{"write-key", "true"}}); err != nil {
+			b.Fatalf("Setex() error = %v", err)
+		}
+	}
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,464 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/starquery/clock"
+)
+
+func NewMemory() Store {
+	return NewMemoryWithClock(clock.Real())
+}
+
+// NewMemoryWithClock returns a memory store that expires entries by c,
+// e.g. a clock.Fake in tests.
+func NewMemoryWithClock(c clock.Clock) Store {
+	return NewMemoryWithOptions(MemoryOptions{Clock: c})
+}
+
+// MemoryOptions configures a memory store.
+type MemoryOptions struct {
+	// Clock expires entries. Defaults to the real clock.
+	Clock clock.Clock
+	// MaxEntriesPerGroup, if positive, caps the entries of each group
+	// of keys that share everything up to their final "/", e.g. the
+	// stargazers of a repo, so a large group can't crowd out the
+	// others. Writing a new key to a full group first evicts the entry
+	// expiring soonest among a sample of memoryEvictionSamples of the
+	// group's entries. Keys without a "/" aren't capped.
+	MaxEntriesPerGroup int
+}
+
+// memoryEvictionSamples is how many of a full group's entries are
+// considered for eviction. Like Redis' approximated LRU, sampling
+// avoids ordering every group by expiry.
+const memoryEvictionSamples = 5
+
+// NewMemoryWithOptions returns a memory store configured by opts.
+func NewMemoryWithOptions(opts MemoryOptions) Store {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	m := &memory{
+		data:     make(map[string]memoryEntry),
+		locks:    make(map[string]memoryLock),
+		clock:    opts.Clock,
+		groupCap: opts.MaxEntriesPerGroup,
+	}
+	if m.groupCap > 0 {
+		m.groups = make(map[string]map[string]struct{})
+	}
+	return m
+}
+
+type memory struct {
+	data  map[string]memoryEntry
+	bytes int64
+	locks map[string]memoryLock
+	clock clock.Clock
+	mu    sync.RWMutex
+
+	// groups holds the keys of each group when groupCap is set.
+	groups   map[string]map[string]struct{}
+	groupCap int
+	evicted  atomic.Uint64
+
+	// peak is the most entries held since the data map was last
+	// rebuilt, which is roughly what it still takes up.
+	peak           int
+	lastCompaction MemoryCompaction
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// memoryEntry is a stored value and when it expires. Expired entries
+// read as missing, and are removed when overwritten, deleted, or
+// compacted.
+type memoryEntry struct {
+	value   string
+	expires time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !now.Before(e.expires)
+}
+
+// MemoryStats describes the size and usage of the memory store.
+type MemoryStats struct {
+	Entries int
+	// Bytes approximates memory use as the total length of keys and values.
+	Bytes int64
+	// Hits and Misses count key lookups since the store was created.
+	Hits   uint64
+	Misses uint64
+	// GroupEntries counts the entries of each group of keys, and
+	// Evicted the entries evicted from full groups, when
+	// MaxEntriesPerGroup is set.
+	GroupEntries map[string]int
+	Evicted      uint64
+	// LastCompaction describes the last Compact, if any.
+	LastCompaction MemoryCompaction
+}
+
+// MemoryCompaction describes a compaction of the memory store.
+type MemoryCompaction struct {
+	Time time.Time
+	// Removed counts the expired entries removed.
+	Removed int
+	// Shrunk reports whether the map was rebuilt to release the space
+	// left by removed entries.
+	Shrunk bool
+}
+
+// Stats returns the current size and usage of the store.
+func (m *memory) Stats() MemoryStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stats := MemoryStats{
+		Entries:        len(m.data),
+		Bytes:          m.bytes,
+		Hits:           m.hits.Load(),
+		Misses:         m.misses.Load(),
+		Evicted:        m.evicted.Load(),
+		LastCompaction: m.lastCompaction,
+	}
+	if m.groups != nil {
+		stats.GroupEntries = make(map[string]int, len(m.groups))
+		for group, keys := range m.groups {
+			stats.GroupEntries[group] = len(keys)
+		}
+	}
+	return stats
+}
+
+// memorySetexChunk is the number of pairs Setex writes per lock hold,
+// so large batches don't stall concurrent reads.
+const memorySetexChunk = 256
+
+func (m *memory) Setex(ctx context.Context, seconds uint, pairs [][2]string) error {
+	expires := m.clock.Now().Add(time.Duration(seconds) * time.Second)
+	for len(pairs) > 0 {
+		n := min(len(pairs), memorySetexChunk)
+		m.mu.Lock()
+		for _, pair := range pairs[:n] {
+			m.insert(pair[0], memoryEntry{value: pair[1], expires: expires})
+		}
+		m.mu.Unlock()
+		pairs = pairs[n:]
+	}
+	return nil
+}
+
+// memoryCompactBatch is the number of expired entries Compact removes
+// per lock hold, so compacting doesn't stall concurrent reads.
+const memoryCompactBatch = 1024
+
+// Compact removes every expired entry, which otherwise stay until
+// they're overwritten or deleted. If the map holds fewer than half the
+// entries it has since it was last rebuilt, it's rebuilt to release
+// their space, which Go maps otherwise keep.
+func (m *memory) Compact() MemoryCompaction {
+	m.mu.RLock()
+	now := m.clock.Now()
+	var expired []string
+	for key, entry := range m.data {
+		if entry.expired(now) {
+			expired = append(expired, key)
+		}
+	}
+	m.mu.RUnlock()
+
+	compaction := MemoryCompaction{Time: now}
+	for len(expired) > 0 {
+		n := min(len(expired), memoryCompactBatch)
+		m.mu.Lock()
+		for _, key := range expired[:n] {
+			// The key may have been written again since.
+			if entry, ok := m.data[key]; ok && entry.expired(now) {
+				m.remove(key)
+				compaction.Removed++
+			}
+		}
+		m.mu.Unlock()
+		expired = expired[n:]
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.data) < m.peak/2 {
+		data := make(map[string]memoryEntry, len(m.data))
+		for key, entry := range m.data {
+			data[key] = entry
+		}
+		m.data = data
+		m.peak = len(data)
+		compaction.Shrunk = true
+	}
+	m.lastCompaction = compaction
+	return compaction
+}
+
+// CompactMemory compacts the memory store every interval until ctx is
+// done. It returns right away for other stores, or if interval isn't
+// positive.
+func CompactMemory(ctx context.Context, store Store, interval time.Duration) {
+	m, ok := store.(*memory)
+	if !ok || interval <= 0 {
+		return
+	}
+	ticker := m.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			m.Compact()
+		}
+	}
+}
+
+// insert stores the entry, replacing the key's and evicting from its
+// group if it's full. m.mu must be held.
+func (m *memory) insert(key string, entry memoryEntry) {
+	m.remove(key)
+	if m.groups != nil {
+		if group, ok := memoryGroup(key); ok {
+			keys := m.groups[group]
+			for len(keys) >= m.groupCap {
+				m.evict(keys)
+			}
+			if len(keys) == 0 {
+				// Evicting may have dropped the emptied group.
+				keys = make(map[string]struct{})
+				m.groups[group] = keys
+			}
+			keys[key] = struct{}{}
+		}
+	}
+	m.data[key] = entry
+	m.bytes += int64(len(key) + len(entry.value))
+	m.peak = max(m.peak, len(m.data))
+}
+
+// evict removes the entry expiring soonest among a sample of the
+// group's keys. Map iteration order is random, so the sample is too.
+// m.mu must be held.
+func (m *memory) evict(keys map[string]struct{}) {
+	var victim string
+	var soonest time.Time
+	sampled := 0
+	for key := range keys {
+		if expires := m.data[key].expires; victim == "" || expires.Before(soonest) {
+			victim, soonest = key, expires
+		}
+		sampled++
+		if sampled == memoryEvictionSamples {
+			break
+		}
+	}
+	m.remove(victim)
+	m.evicted.Add(1)
+}
+
+// remove deletes the key. m.mu must be held.
+func (m *memory) remove(key string) {
+	old, ok := m.data[key]
+	if !ok {
+		return
+	}
+	m.bytes -= int64(len(key) + len(old.value))
+	delete(m.data, key)
+	if m.groups == nil {
+		return
+	}
+	if group, ok := memoryGroup(key); ok {
+		delete(m.groups[group], key)
+		if len(m.groups[group]) == 0 {
+			delete(m.groups, group)
+		}
+	}
+}
+
+// memoryGroup returns the group of the key: everything up to its final
+// "/", as with LayoutHashPerRepo.
+func memoryGroup(key string) (string, bool) {
+	i := strings.LastIndexByte(key, '/')
+	if i < 0 {
+		return "", false
+	}
+	return key[:i], true
+}
+
+// lookup returns the unexpired value of key. m.mu must be held.
+func (m *memory) lookup(key string, now time.Time) (memoryEntry, bool) {
+	entry, ok := m.data[key]
+	if !ok || entry.expired(now) {
+		return memoryEntry{}, false
+	}
+	return entry, true
+}
+
+func (m *memory) Get(ctx context.Context, key string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.lookup(key, m.clock.Now())
+	if !ok {
+		m.misses.Add(1)
+		return "", ErrNotFound
+	}
+	m.hits.Add(1)
+	return entry.value, nil
+}
+
+// memoryMGetChunk is the number of keys MGet reads per lock hold, so
+// large bulk reads don't stall concurrent writes, e.g. from webhooks.
+const memoryMGetChunk = 256
+
+// MGet reads the keys in chunks of memoryMGetChunk, releasing the lock
+// between them. A write made during a large MGet may be seen by later
+// chunks and not earlier ones, which is fine as keys are independent.
+func (m *memory) MGet(ctx context.Context, keys []string) ([]string, error) {
+	now := m.clock.Now()
+	values := make([]string, len(keys))
+	var hits uint64
+	for start := 0; start < len(keys); start += memoryMGetChunk {
+		end := min(start+memoryMGetChunk, len(keys))
+		m.mu.RLock()
+		for i, key := range keys[start:end] {
+			entry, ok := m.lookup(key, now)
+			if ok {
+				hits++
+			}
+			values[start+i] = entry.value
+		}
+		m.mu.RUnlock()
+	}
+	m.hits.Add(hits)
+	m.misses.Add(uint64(len(keys)) - hits)
+	return values, nil
+}
+
+func (m *memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(key)
+	return nil
+}
+
+func (m *memory) TTL(ctx context.Context, key string) (time.Duration, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := m.clock.Now()
+	entry, ok := m.lookup(key, now)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return entry.expires.Sub(now), nil
+}
+
+func (m *memory) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	entry, ok := m.lookup(key, now)
+	if !ok {
+		return ErrNotFound
+	}
+	entry.expires = now.Add(ttl)
+	m.data[key] = entry
+	return nil
+}
+
+func (m *memory) Keys(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := m.clock.Now()
+	var keys []string
+	for key, entry := range m.data {
+		if strings.HasPrefix(key, prefix) && !entry.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+type memoryLock struct {
+	owner   string
+	expires time.Time
+}
+
+func (m *memory) Lock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, ok := m.locks[key]
+	if ok && lock.owner != owner && m.clock.Now().Before(lock.expires) {
+		return false, nil
+	}
+	m.locks[key] = memoryLock{owner: owner, expires: m.clock.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *memory) Unlock(ctx context.Context, key, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locks[key].owner == owner {
+		delete(m.locks, key)
+	}
+	return nil
+}
+
+// memorySnapshotEntry is a key in a memory store snapshot.
+type memorySnapshotEntry struct {
+	Key     string    `json:"key"`
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// Snapshot writes the unexpired entries as a JSON array. Locks aren't
+// included.
+func (m *memory) Snapshot(w io.Writer) error {
+	m.mu.RLock()
+	now := m.clock.Now()
+	entries := make([]memorySnapshotEntry, 0, len(m.data))
+	for key, entry := range m.data {
+		if !entry.expired(now) {
+			entries = append(entries, memorySnapshotEntry{Key: key, Value: entry.value, Expires: entry.expires})
+		}
+	}
+	m.mu.RUnlock()
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	return nil
+}
+
+func (m *memory) Restore(r io.Reader) error {
+	var entries []memorySnapshotEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	for _, entry := range entries {
+		stored := memoryEntry{value: entry.Value, expires: entry.Expires}
+		if stored.expired(now) {
+			continue
+		}
+		m.insert(entry.Key, stored)
+	}
+	return nil
+}
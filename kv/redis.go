@@ -0,0 +1,799 @@
+package kv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/redjet"
+)
+
+// Layout selects how the Redis backend arranges keys.
+type Layout int
+
+const (
+	// LayoutKeyPerUser stores each key as its own Redis string with a TTL.
+	LayoutKeyPerUser Layout = iota
+	// LayoutHashPerRepo groups keys that share everything up to their
+	// final "/" (e.g. all stargazers of a repo) into one Redis hash, so
+	// lookups are HGET/HEXISTS and counts are HLEN.
+	//
+	// Redis hashes have no per-field TTL, so every field stores its own
+	// expiry. Expired fields read as missing, but only CleanupRedis
+	// removes them, so run it with this layout or hashes that keep being
+	// written will grow without bound. The hash itself expires once none
+	// of its fields have been written for the TTL. Writes only ever
+	// extend a hash's expiry, which requires Redis 7 or later.
+	LayoutHashPerRepo
+)
+
+// Codec selects how the Redis backend encodes values.
+type Codec int
+
+const (
+	// CodecNone stores values as they are.
+	CodecNone Codec = iota
+	// CodecGzip gzips values of at least the compress threshold. Values
+	// are decoded when read regardless of the codec, so it can be
+	// changed without rewriting the store.
+	CodecGzip
+)
+
+// DefaultCompressThreshold is the smallest value CodecGzip compresses by
+// default. Smaller values rarely shrink.
+const DefaultCompressThreshold = 256
+
+// gzipMagic starts every gzipped value.
+const gzipMagic = "\x1f\x8b"
+
+// RedisOptions configures the Redis backend.
+type RedisOptions struct {
+	Layout Layout
+	// Retry configures retries of commands that fail with ErrUnavailable.
+	// Defaults to DefaultRetryPolicy.
+	Retry RetryPolicy
+	// DialRetry configures retries of the ping made by DialRedis.
+	// Defaults to DefaultDialRetryPolicy.
+	DialRetry RetryPolicy
+	// Codec encodes values before they're stored.
+	Codec Codec
+	// CompressThreshold is the smallest value in bytes that's compressed.
+	// Defaults to DefaultCompressThreshold.
+	CompressThreshold int
+	// Logger receives connection failures and retried commands, which
+	// callers otherwise only see once retries are exhausted. Defaults
+	// to discarding logs.
+	Logger *slog.Logger
+	// KeyPrefix namespaces the keys the store keeps for itself, e.g.
+	// the index of hashes in the hash layout. Set it to the API's
+	// KeyPrefix when several deployments share one Redis.
+	// Defaults to DefaultRedisKeyPrefix.
+	KeyPrefix string
+}
+
+// DefaultRedisKeyPrefix matches the API's default key prefix.
+const DefaultRedisKeyPrefix = "stargazers"
+
+// RetryPolicy bounds how commands are retried with exponential backoff.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts including the first.
+	// Set it to 1 to disable retries.
+	Attempts int
+	// Delay is the wait before the first retry, doubled after each
+	// retry up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy retries briefly, to ride out connection resets
+// without holding up queries for long.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts: 3,
+	Delay:    50 * time.Millisecond,
+	MaxDelay: time.Second,
+}
+
+// DefaultDialRetryPolicy retries for a few seconds, to wait out Redis
+// starting alongside starquery.
+var DefaultDialRetryPolicy = RetryPolicy{
+	Attempts: 5,
+	Delay:    250 * time.Millisecond,
+	MaxDelay: 2 * time.Second,
+}
+
+func NewRedis(addr string) Store {
+	return NewRedisWithOptions(addr, RedisOptions{})
+}
+
+func NewRedisWithOptions(addr string, opts RedisOptions) Store {
+	if opts.Retry == (RetryPolicy{}) {
+		opts.Retry = DefaultRetryPolicy
+	}
+	if opts.CompressThreshold <= 0 {
+		opts.CompressThreshold = DefaultCompressThreshold
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = DefaultRedisKeyPrefix
+	}
+	client := redjet.New(addr)
+	dial := client.Dial
+	client.Dial = func(ctx context.Context) (net.Conn, error) {
+		conn, err := dial(ctx)
+		if err != nil && ctx.Err() == nil {
+			opts.Logger.WarnContext(ctx, "failed to connect to redis", "addr", addr, "error", err)
+		}
+		return conn, err
+	}
+	return &redis{
+		Client:            client,
+		layout:            opts.Layout,
+		retry:             opts.Retry,
+		codec:             opts.Codec,
+		compressThreshold: opts.CompressThreshold,
+		logger:            opts.Logger,
+		hashIndexKey:      opts.KeyPrefix + ":hashes",
+	}
+}
+
+// DialRedis is like NewRedisWithOptions, but pings the server so an
+// unreachable address is reported immediately rather than on the first
+// command. The ping is retried per opts.DialRetry, or until ctx is
+// done. The store is returned even if the ping fails, so callers may
+// carry on without Redis until it comes back.
+func DialRedis(ctx context.Context, addr string, opts RedisOptions) (Store, error) {
+	store := NewRedisWithOptions(addr, opts)
+	dial := *store.(*redis)
+	dial.retry = opts.DialRetry
+	if dial.retry == (RetryPolicy{}) {
+		dial.retry = DefaultDialRetryPolicy
+	}
+	if err := dial.Ping(ctx); err != nil {
+		return store, fmt.Errorf("ping redis at %s: %w", addr, err)
+	}
+	return store, nil
+}
+
+type redis struct {
+	Client            *redjet.Client
+	layout            Layout
+	retry             RetryPolicy
+	codec             Codec
+	compressThreshold int
+	logger            *slog.Logger
+	// hashIndexKey holds the set of hashes written in the hash layout,
+	// so they can be cleaned up without scanning the keyspace.
+	hashIndexKey string
+}
+
+// withRetry calls fn until it returns an error other than
+// ErrUnavailable, the attempts are exhausted, or ctx is done. Logical
+// errors, e.g. ErrNotFound or a wrong type, are returned immediately.
+func (r *redis) withRetry(ctx context.Context, fn func() error) error {
+	delay := r.retry.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !errors.Is(err, ErrUnavailable) || attempt >= r.retry.Attempts || ctx.Err() != nil {
+			return err
+		}
+		r.logger.WarnContext(ctx, "redis command failed, retrying", "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay = min(delay*2, r.retry.MaxDelay)
+	}
+}
+
+func (r *redis) Setex(ctx context.Context, seconds uint, pairs [][2]string) error {
+	return r.withRetry(ctx, func() error {
+		return r.setex(ctx, seconds, pairs)
+	})
+}
+
+func (r *redis) setex(ctx context.Context, seconds uint, pairs [][2]string) error {
+	if r.layout == LayoutHashPerRepo {
+		return r.hashSetex(ctx, seconds, pairs)
+	}
+	var p *redjet.Pipeline
+	for _, pair := range pairs {
+		value, err := r.encode(pair[1])
+		if err != nil {
+			p.Close()
+			return err
+		}
+		p = r.Client.Pipeline(ctx, p, "SET", pair[0], value, "EX", seconds)
+	}
+	return readReplies(p)
+}
+
+// readReplies reads every reply of the pipeline, so the connection can
+// be reused, and returns the first error.
+func readReplies(p *redjet.Pipeline) error {
+	defer p.Close()
+	for p.Next() {
+		if _, err := p.Bytes(); err != nil {
+			return wrapError(err)
+		}
+	}
+	return nil
+}
+
+// encode compresses value if the codec calls for it.
+func (r *redis) encode(value string) (string, error) {
+	if r.codec != CodecGzip || len(value) < r.compressThreshold {
+		return value, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, value); err != nil {
+		return "", fmt.Errorf("compress value: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("compress value: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// decode is the inverse of encode for any codec.
+func decode(raw string) (string, error) {
+	if !strings.HasPrefix(raw, gzipMagic) {
+		return raw, nil
+	}
+	zr, err := gzip.NewReader(strings.NewReader(raw))
+	if err != nil {
+		return "", fmt.Errorf("decompress value: %w", err)
+	}
+	value, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("decompress value: %w", err)
+	}
+	return string(value), nil
+}
+
+func (r *redis) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := r.withRetry(ctx, func() error {
+		var err error
+		value, err = r.get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (r *redis) get(ctx context.Context, key string) (string, error) {
+	if r.layout == LayoutHashPerRepo {
+		return r.hashGet(ctx, key)
+	}
+	value, err := r.Client.Command(ctx, "GET", key).String()
+	if err != nil {
+		return "", wrapError(err)
+	}
+	// We never store empty values, so an empty reply is a nil reply.
+	if value == "" {
+		return "", ErrNotFound
+	}
+	return decode(value)
+}
+
+func (r *redis) MGet(ctx context.Context, keys []string) ([]string, error) {
+	var values []string
+	err := r.withRetry(ctx, func() error {
+		var err error
+		values, err = r.mget(ctx, keys)
+		return err
+	})
+	return values, err
+}
+
+func (r *redis) mget(ctx context.Context, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	if r.layout == LayoutHashPerRepo {
+		return r.hashMGet(ctx, keys)
+	}
+	args := make([]any, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	values, err := r.Client.Command(ctx, "MGET", args...).Strings()
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	for i, value := range values {
+		if values[i], err = decode(value); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (r *redis) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if r.layout == LayoutHashPerRepo {
+		if hash, field, ok := splitHashKey(key); ok {
+			raw, err := r.Client.Command(ctx, "HGET", hash, field).String()
+			if err != nil {
+				return 0, wrapError(err)
+			}
+			expires, _ := decodeHashValue(raw)
+			ttl := time.Until(time.Unix(expires, 0))
+			if raw == "" || ttl <= 0 {
+				return 0, ErrNotFound
+			}
+			return ttl, nil
+		}
+	}
+	// PTTL returns -2 if the key doesn't exist, and -1 if it has no expiry.
+	ms, err := r.Client.Command(ctx, "PTTL", key).Int()
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	if ms == -2 {
+		return 0, ErrNotFound
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (r *redis) Delete(ctx context.Context, key string) error {
+	return r.withRetry(ctx, func() error {
+		return r.delete(ctx, key)
+	})
+}
+
+func (r *redis) delete(ctx context.Context, key string) error {
+	cmd, args := "DEL", []any{key}
+	if r.layout == LayoutHashPerRepo {
+		if hash, field, ok := splitHashKey(key); ok {
+			cmd, args = "HDEL", []any{hash, field}
+		}
+	}
+	// DEL/HDEL return the number of records deleted.
+	// We don't care if it exists or not for our impl.
+	_, err := r.Client.Command(ctx, cmd, args...).Int()
+	if err != nil {
+		return wrapError(err)
+	}
+	return nil
+}
+
+func (r *redis) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return r.withRetry(ctx, func() error {
+		return r.expire(ctx, key, ttl)
+	})
+}
+
+func (r *redis) expire(ctx context.Context, key string, ttl time.Duration) error {
+	if r.layout == LayoutHashPerRepo {
+		if hash, field, ok := splitHashKey(key); ok {
+			return r.hashExpire(ctx, hash, field, ttl)
+		}
+	}
+	updated, err := r.Client.Command(ctx, "PEXPIRE", key, ttl.Milliseconds()).Int()
+	if err != nil {
+		return wrapError(err)
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// expireScript rewrites the expiry encoded in field ARGV[1] of the hash
+// in KEYS[1] to the unix time in ARGV[3], unless it had already expired
+// by the unix time in ARGV[2]. GT extends the hash's own expiry to ARGV[4]
+// milliseconds if the field would now outlive it, as in hashSetex. It
+// returns 0 if the field is missing or expired.
+const expireScript = `
+local raw = redis.call("HGET", KEYS[1], ARGV[1])
+if not raw then
+	return 0
+end
+local expires, value = string.match(raw, "^(%d+):(.*)$")
+if not expires then
+	value = raw
+elseif tonumber(expires) <= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[3] .. ":" .. value)
+redis.call("PEXPIRE", KEYS[1], ARGV[4], "GT")
+return 1`
+
+// hashExpire rewrites the expiry encoded in the field, and extends the
+// hash's own expiry if the field would now outlive it. Both happen in
+// one script, so a field expiring or rewritten concurrently is never
+// revived with a stale value.
+func (r *redis) hashExpire(ctx context.Context, hash, field string, ttl time.Duration) error {
+	now := time.Now()
+	updated, err := r.Client.Command(ctx, "EVAL", expireScript, 1, hash, field, now.Unix(), now.Add(ttl).Unix(), ttl.Milliseconds()).Int()
+	if err != nil {
+		return wrapError(err)
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *redis) Ping(ctx context.Context) error {
+	return r.withRetry(ctx, func() error {
+		reply, err := r.Client.Command(ctx, "PING").String()
+		if err != nil {
+			return wrapError(err)
+		}
+		if reply != "PONG" {
+			return fmt.Errorf("unexpected ping reply %q", reply)
+		}
+		return nil
+	})
+}
+
+func (r *redis) Publish(ctx context.Context, channel, message string) error {
+	return r.withRetry(ctx, func() error {
+		_, err := r.Client.Command(ctx, "PUBLISH", channel, message).Int()
+		return wrapError(err)
+	})
+}
+
+// Subscribe holds a connection for the subscription, outside the pool.
+func (r *redis) Subscribe(ctx context.Context, channel string, fn func(message string)) error {
+	sub := r.Client.Pipeline(ctx, nil, "SUBSCRIBE", channel)
+	defer sub.Close()
+	for {
+		msg, err := sub.NextSubMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return wrapError(err)
+		}
+		if msg.Type == redjet.SubMessageMessage {
+			fn(msg.Payload)
+		}
+	}
+}
+
+// scanScript runs one SCAN iteration and flattens its reply to the
+// next cursor followed by the matched keys.
+const scanScript = `
+local reply = redis.call("SCAN", ARGV[1], "MATCH", ARGV[2], "COUNT", 1000)
+local result = {reply[1]}
+for _, key in ipairs(reply[2]) do
+	result[#result+1] = key
+end
+return result`
+
+// Keys uses SCAN rather than KEYS so listing doesn't block the server.
+func (r *redis) Keys(ctx context.Context, prefix string) ([]string, error) {
+	if r.layout == LayoutHashPerRepo && strings.HasSuffix(prefix, "/") {
+		return r.hashKeys(ctx, strings.TrimSuffix(prefix, "/"))
+	}
+	pattern := globEscaper.Replace(prefix) + "*"
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := r.Client.Command(ctx, "EVAL", scanScript, 0, cursor, pattern).Strings()
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		if len(reply) == 0 {
+			return nil, fmt.Errorf("%w: empty scan reply", ErrUnavailable)
+		}
+		keys = append(keys, reply[1:]...)
+		cursor = reply[0]
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// globEscaper escapes the characters SCAN MATCH treats as patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// lockScript extends the lock if the owner holds it, and otherwise
+// acquires it if it isn't held.
+const lockScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0`
+
+// unlockScript deletes the lock only if the owner holds it.
+const unlockScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`
+
+func (r *redis) Lock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	held, err := r.Client.Command(ctx, "EVAL", lockScript, 1, key, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, wrapError(err)
+	}
+	return held == 1, nil
+}
+
+func (r *redis) Unlock(ctx context.Context, key, owner string) error {
+	_, err := r.Client.Command(ctx, "EVAL", unlockScript, 1, key, owner).Int()
+	return wrapError(err)
+}
+
+func (r *redis) hashSetex(ctx context.Context, seconds uint, pairs [][2]string) error {
+	expires := time.Now().Add(time.Duration(seconds) * time.Second).Unix()
+	hashes := make(map[string]struct{})
+	var p *redjet.Pipeline
+	for _, pair := range pairs {
+		value, err := r.encode(pair[1])
+		if err != nil {
+			p.Close()
+			return err
+		}
+		hash, field, ok := splitHashKey(pair[0])
+		if !ok {
+			p = r.Client.Pipeline(ctx, p, "SET", pair[0], value, "EX", seconds)
+			continue
+		}
+		p = r.Client.Pipeline(ctx, p, "HSET", hash, field, encodeHashValue(expires, value))
+		hashes[hash] = struct{}{}
+	}
+	// NX sets the expiry of new hashes and GT extends others, so writing
+	// a short-lived field never expires longer-lived ones with the hash.
+	// The index expires with the last of them.
+	for hash := range hashes {
+		p = r.Client.Pipeline(ctx, p, "SADD", r.hashIndexKey, hash)
+		p = r.Client.Pipeline(ctx, p, "EXPIRE", hash, seconds, "NX")
+		p = r.Client.Pipeline(ctx, p, "EXPIRE", hash, seconds, "GT")
+	}
+	if len(hashes) > 0 {
+		p = r.Client.Pipeline(ctx, p, "EXPIRE", r.hashIndexKey, seconds, "NX")
+		p = r.Client.Pipeline(ctx, p, "EXPIRE", r.hashIndexKey, seconds, "GT")
+	}
+	return readReplies(p)
+}
+
+// cleanupScript removes the expired fields among one HSCAN batch of
+// the hash in KEYS[1], checking each field's expiry against the unix
+// time in ARGV[2] as it's deleted, so a field rewritten concurrently is
+// never removed. Hashes that no longer exist are dropped from the index
+// in KEYS[2]. It returns the next cursor and the number removed.
+const cleanupScript = `
+local reply = redis.call("HSCAN", KEYS[1], ARGV[1], "COUNT", 1000)
+local removed = 0
+local fields = reply[2]
+for i = 1, #fields, 2 do
+	local expires = string.match(fields[i+1], "^(%d+):")
+	if expires and tonumber(expires) <= tonumber(ARGV[2]) then
+		removed = removed + redis.call("HDEL", KEYS[1], fields[i])
+	end
+end
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	redis.call("SREM", KEYS[2], KEYS[1])
+end
+return {reply[1], tostring(removed)}`
+
+// Cleanup removes the expired fields of every hash written in the hash
+// layout, and forgets hashes that have expired entirely. It returns the
+// number of fields removed. Hashes are scanned in batches, so large
+// ones don't block the server.
+func (r *redis) Cleanup(ctx context.Context) (int, error) {
+	hashes, err := r.Client.Command(ctx, "SMEMBERS", r.hashIndexKey).Strings()
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	var removed int
+	for _, hash := range hashes {
+		cursor := "0"
+		for {
+			reply, err := r.Client.Command(ctx, "EVAL", cleanupScript, 2, hash, r.hashIndexKey, cursor, time.Now().Unix()).Strings()
+			if err != nil {
+				return removed, wrapError(err)
+			}
+			if len(reply) != 2 {
+				return removed, fmt.Errorf("%w: unexpected cleanup reply %q", ErrUnavailable, reply)
+			}
+			n, err := strconv.Atoi(reply[1])
+			if err != nil {
+				return removed, fmt.Errorf("parse cleanup reply: %w", err)
+			}
+			removed += n
+			cursor = reply[0]
+			if cursor == "0" {
+				break
+			}
+		}
+	}
+	return removed, nil
+}
+
+// CleanupRedis cleans up the expired fields of a Redis store in the hash
+// layout every interval until ctx is done. It returns right away for
+// other stores and layouts, or if interval isn't positive.
+func CleanupRedis(ctx context.Context, store Store, interval time.Duration) {
+	r, ok := store.(*redis)
+	if !ok || r.layout != LayoutHashPerRepo || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := r.Cleanup(ctx)
+			if err != nil && ctx.Err() == nil {
+				r.logger.WarnContext(ctx, "failed to clean up expired redis fields", "removed", removed, "error", err)
+			}
+		}
+	}
+}
+
+// Count counts a hash's fields with HLEN in the hash layout, including
+// expired fields that haven't been removed yet, and otherwise scans.
+func (r *redis) Count(ctx context.Context, prefix string) (int, error) {
+	if r.layout != LayoutHashPerRepo || !strings.HasSuffix(prefix, "/") {
+		keys, err := r.Keys(ctx, prefix)
+		return len(keys), err
+	}
+	n, err := r.Client.Command(ctx, "HLEN", strings.TrimSuffix(prefix, "/")).Int()
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	return n, nil
+}
+
+func (r *redis) hashGet(ctx context.Context, key string) (string, error) {
+	values, err := r.hashMGet(ctx, []string{key})
+	if err != nil {
+		return "", err
+	}
+	if values[0] == "" {
+		return "", ErrNotFound
+	}
+	return values[0], nil
+}
+
+func (r *redis) hashMGet(ctx context.Context, keys []string) ([]string, error) {
+	var p *redjet.Pipeline
+	for _, key := range keys {
+		if hash, field, ok := splitHashKey(key); ok {
+			p = r.Client.Pipeline(ctx, p, "HGET", hash, field)
+		} else {
+			p = r.Client.Pipeline(ctx, p, "GET", key)
+		}
+	}
+	defer p.Close()
+
+	// Expired fields read as missing and are left to Cleanup, so reads
+	// never write and can't remove a field rewritten since they ran.
+	now := time.Now().Unix()
+	values := make([]string, 0, len(keys))
+	for p.Next() {
+		raw, err := p.String()
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		key := keys[len(values)]
+		value := raw
+		if _, _, ok := splitHashKey(key); ok && raw != "" {
+			var expires int64
+			expires, value = decodeHashValue(raw)
+			if expires <= now {
+				value = ""
+			}
+		}
+		if value, err = decode(value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if len(values) != len(keys) {
+		return nil, fmt.Errorf("%w: got %d replies for %d keys", ErrUnavailable, len(values), len(keys))
+	}
+	return values, nil
+}
+
+// hscanScript runs one HSCAN iteration of the hash in KEYS[1] and
+// flattens its reply to the next cursor followed by the fields and their
+// values.
+const hscanScript = `
+local reply = redis.call("HSCAN", KEYS[1], ARGV[1], "COUNT", 1000)
+local result = {reply[1]}
+for _, item in ipairs(reply[2]) do
+	result[#result+1] = item
+end
+return result`
+
+// hashKeys returns the keys of the unexpired fields in hash. Like Keys,
+// it pages through the hash with HSCAN so large ones don't block the
+// server.
+func (r *redis) hashKeys(ctx context.Context, hash string) ([]string, error) {
+	now := time.Now().Unix()
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := r.Client.Command(ctx, "EVAL", hscanScript, 1, hash, cursor).Strings()
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		if len(reply) == 0 {
+			return nil, fmt.Errorf("%w: empty hscan reply", ErrUnavailable)
+		}
+		for i := 1; i+1 < len(reply); i += 2 {
+			if expires, _ := decodeHashValue(reply[i+1]); expires > now {
+				keys = append(keys, hash+"/"+reply[i])
+			}
+		}
+		cursor = reply[0]
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// splitHashKey splits key at its final "/" into a hash and field.
+func splitHashKey(key string) (hash, field string, ok bool) {
+	i := strings.LastIndexByte(key, '/')
+	if i < 0 {
+		return "", "", false
+	}
+	return key[:i], key[i+1:], true
+}
+
+// encodeHashValue prefixes value with its unix expiry.
+func encodeHashValue(expires int64, value string) string {
+	return strconv.FormatInt(expires, 10) + ":" + value
+}
+
+// decodeHashValue is the inverse of encodeHashValue. Values that weren't
+// encoded never expire.
+func decodeHashValue(raw string) (expires int64, value string) {
+	prefix, value, ok := strings.Cut(raw, ":")
+	if !ok {
+		return 1<<63 - 1, raw
+	}
+	expires, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return 1<<63 - 1, raw
+	}
+	return expires, value
+}
+
+// transientRedisErrors are prefixes of Redis server errors that indicate
+// the server is temporarily unable to serve requests.
+var transientRedisErrors = []string{"LOADING", "BUSY", "TRYAGAIN", "MASTERDOWN", "CLUSTERDOWN"}
+
+// wrapError marks errors that weren't returned by the Redis server
+// itself (e.g. connection failures), or that indicate the server is
+// temporarily overloaded, as ErrUnavailable.
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var redisErr *redjet.Error
+	if errors.As(err, &redisErr) {
+		// redjet prefixes the server's error with "server: ".
+		msg := strings.TrimPrefix(redisErr.Error(), "server: ")
+		for _, prefix := range transientRedisErrors {
+			if strings.HasPrefix(msg, prefix) {
+				return fmt.Errorf("%w: %w", ErrUnavailable, err)
+			}
+		}
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
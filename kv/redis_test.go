@@ -0,0 +1,814 @@
+package kv_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery/kv"
+)
+
+// flakyRedis is a stub Redis server that answers the nth command it
+// receives (counting from 0) with reply(n). An empty reply closes the
+// connection instead.
+type flakyRedis struct {
+	listener net.Listener
+	commands atomic.Int64
+	reply    func(n int64) string
+}
+
+func newFlakyRedis(t *testing.T, reply func(n int64) string) *flakyRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	f := &flakyRedis{listener: listener, reply: reply}
+	go f.serve()
+	return f
+}
+
+func (f *flakyRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			rd := bufio.NewReader(conn)
+			for {
+				if _, err := readCommand(rd); err != nil {
+					return
+				}
+				reply := f.reply(f.commands.Add(1) - 1)
+				if reply == "" {
+					return
+				}
+				if _, err := io.WriteString(conn, reply); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// readCommand reads one RESP array of bulk strings.
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for range n {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg[:size]))
+	}
+	return args, nil
+}
+
+// fakeRedis is an in-memory Redis server supporting the commands the
+// store sends for lookups, expiry, and pub/sub. Expiries are tracked
+// for PTTL, but keys aren't removed when they pass.
+type fakeRedis struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	strings     map[string]string
+	hashes      map[string]map[string]string
+	sets        map[string]map[string]bool
+	expires     map[string]time.Time
+	subscribers map[string][]net.Conn
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	f := &fakeRedis{
+		listener:    listener,
+		strings:     make(map[string]string),
+		hashes:      make(map[string]map[string]string),
+		sets:        make(map[string]map[string]bool),
+		expires:     make(map[string]time.Time),
+		subscribers: make(map[string][]net.Conn),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(rd)
+					if err != nil {
+						return
+					}
+					if strings.EqualFold(args[0], "SUBSCRIBE") {
+						f.subscribe(conn, args[1])
+						continue
+					}
+					if _, err := io.WriteString(conn, f.handle(args)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) handle(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bulk := func(value string, ok bool) string {
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	}
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "SET":
+		f.strings[args[1]] = args[2]
+		delete(f.expires, args[1])
+		if len(args) == 5 && strings.EqualFold(args[3], "EX") {
+			seconds, _ := strconv.Atoi(args[4])
+			f.expires[args[1]] = time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+		return "+OK\r\n"
+	case "GET":
+		value, ok := f.strings[args[1]]
+		return bulk(value, ok)
+	case "MGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			value, ok := f.strings[key]
+			reply += bulk(value, ok)
+		}
+		return reply
+	case "DEL":
+		delete(f.strings, args[1])
+		delete(f.expires, args[1])
+		return ":1\r\n"
+	case "HSET":
+		if f.hashes[args[1]] == nil {
+			f.hashes[args[1]] = make(map[string]string)
+		}
+		f.hashes[args[1]][args[2]] = args[3]
+		return ":1\r\n"
+	case "HGET":
+		value, ok := f.hashes[args[1]][args[2]]
+		return bulk(value, ok)
+	case "HDEL":
+		var deleted int
+		for _, field := range args[2:] {
+			if _, ok := f.hashes[args[1]][field]; ok {
+				delete(f.hashes[args[1]], field)
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case "HLEN":
+		return fmt.Sprintf(":%d\r\n", len(f.hashes[args[1]]))
+	case "SADD":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = make(map[string]bool)
+		}
+		f.sets[args[1]][args[2]] = true
+		return ":1\r\n"
+	case "SREM":
+		delete(f.sets[args[1]], args[2])
+		return ":1\r\n"
+	case "SMEMBERS":
+		reply := fmt.Sprintf("*%d\r\n", len(f.sets[args[1]]))
+		for member := range f.sets[args[1]] {
+			reply += bulk(member, true)
+		}
+		return reply
+	case "EXPIRE", "PEXPIRE":
+		if !f.exists(args[1]) {
+			return ":0\r\n"
+		}
+		n, _ := strconv.Atoi(args[2])
+		unit := time.Second
+		if strings.EqualFold(args[0], "PEXPIRE") {
+			unit = time.Millisecond
+		}
+		expires := time.Now().Add(time.Duration(n) * unit)
+		current, ok := f.expires[args[1]]
+		if len(args) == 4 {
+			// NX sets only missing expiries, and GT only extends them.
+			switch strings.ToUpper(args[3]) {
+			case "NX":
+				if ok {
+					return ":0\r\n"
+				}
+			case "GT":
+				if !ok || !expires.After(current) {
+					return ":0\r\n"
+				}
+			}
+		}
+		f.expires[args[1]] = expires
+		return ":1\r\n"
+	case "PTTL":
+		if !f.exists(args[1]) {
+			return ":-2\r\n"
+		}
+		expires, ok := f.expires[args[1]]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", time.Until(expires).Milliseconds())
+	case "EVAL":
+		if strings.Contains(args[1], "HGET") {
+			// Emulate the expire script.
+			hash, field := args[3], args[4]
+			now, _ := strconv.ParseInt(args[5], 10, 64)
+			raw, ok := f.hashes[hash][field]
+			if !ok {
+				return ":0\r\n"
+			}
+			value := raw
+			if prefix, rest, ok := strings.Cut(raw, ":"); ok {
+				if expires, err := strconv.ParseInt(prefix, 10, 64); err == nil {
+					if expires <= now {
+						return ":0\r\n"
+					}
+					value = rest
+				}
+			}
+			f.hashes[hash][field] = args[6] + ":" + value
+			ms, _ := strconv.Atoi(args[7])
+			if current, ok := f.expires[hash]; ok {
+				if expires := time.Now().Add(time.Duration(ms) * time.Millisecond); expires.After(current) {
+					f.expires[hash] = expires
+				}
+			}
+			return ":1\r\n"
+		}
+		if !strings.Contains(args[1], "HSCAN") {
+			break
+		}
+		if !strings.Contains(args[1], "HDEL") {
+			// Emulate the hscan script, returning the whole hash at once.
+			hash := f.hashes[args[3]]
+			reply := fmt.Sprintf("*%d\r\n", 1+2*len(hash)) + bulk("0", true)
+			for field, value := range hash {
+				reply += bulk(field, true) + bulk(value, true)
+			}
+			return reply
+		}
+		// Emulate the cleanup script, scanning the whole hash at once.
+		hash, index := args[3], args[4]
+		now, _ := strconv.ParseInt(args[6], 10, 64)
+		var removed int
+		for field, value := range f.hashes[hash] {
+			prefix, _, ok := strings.Cut(value, ":")
+			expires, err := strconv.ParseInt(prefix, 10, 64)
+			if ok && err == nil && expires <= now {
+				delete(f.hashes[hash], field)
+				removed++
+			}
+		}
+		if !f.exists(hash) {
+			delete(f.sets[index], hash)
+		}
+		return "*2\r\n" + bulk("0", true) + bulk(strconv.Itoa(removed), true)
+	case "PUBLISH":
+		subscribers := f.subscribers[args[1]]
+		for _, conn := range subscribers {
+			_, _ = fmt.Fprintf(conn, "*3\r\n%s%s%s", bulk("message", true), bulk(args[1], true), bulk(args[2], true))
+		}
+		return fmt.Sprintf(":%d\r\n", len(subscribers))
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+// exists reports whether key holds a string, hash or set. f.mu must be
+// held.
+func (f *fakeRedis) exists(key string) bool {
+	_, isString := f.strings[key]
+	return isString || len(f.hashes[key]) > 0 || len(f.sets[key]) > 0
+}
+
+// subscribe adds conn to the subscribers of channel and acknowledges it.
+func (f *fakeRedis) subscribe(conn net.Conn, channel string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscribers[channel] = append(f.subscribers[channel], conn)
+	_, _ = fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(channel), channel)
+}
+
+// subscriberCount returns the number of subscriptions to channel.
+func (f *fakeRedis) subscriberCount(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers[channel])
+}
+
+// stored returns the raw value of a key in either layout.
+func (f *fakeRedis) stored(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if value, ok := f.strings[key]; ok {
+		return value
+	}
+	i := strings.LastIndexByte(key, '/')
+	return f.hashes[key[:i]][key[i+1:]]
+}
+
+func TestRedisRetry(t *testing.T) {
+	t.Parallel()
+
+	policy := kv.RetryPolicy{Attempts: 3, Delay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	for _, tc := range []struct {
+		name         string
+		reply        func(n int64) string
+		wantValue    string
+		wantErr      error
+		wantCommands int64
+	}{
+		{
+			name: "ConnectionReset",
+			reply: func(n int64) string {
+				if n == 0 {
+					return ""
+				}
+				return "$4\r\ntrue\r\n"
+			},
+			wantValue:    "true",
+			wantCommands: 2,
+		},
+		{
+			name: "Loading",
+			reply: func(n int64) string {
+				if n == 0 {
+					return "-LOADING Redis is loading the dataset in memory\r\n"
+				}
+				return "$4\r\ntrue\r\n"
+			},
+			wantValue:    "true",
+			wantCommands: 2,
+		},
+		{
+			name:         "AttemptsExhausted",
+			reply:        func(int64) string { return "" },
+			wantErr:      kv.ErrUnavailable,
+			wantCommands: 3,
+		},
+		{
+			name:         "LogicalError",
+			reply:        func(int64) string { return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n" },
+			wantCommands: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			server := newFlakyRedis(t, tc.reply)
+			store := kv.NewRedisWithOptions(server.listener.Addr().String(), kv.RedisOptions{Retry: policy})
+
+			value, err := store.Get(context.Background(), "key")
+			switch {
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Get() error = %v, want %v", err, tc.wantErr)
+				}
+			case tc.wantValue == "":
+				if err == nil || errors.Is(err, kv.ErrUnavailable) {
+					t.Errorf("Get() error = %v, want a logical error", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Get() error = %v", err)
+				}
+				if value != tc.wantValue {
+					t.Errorf("Get() = %q, want %q", value, tc.wantValue)
+				}
+			}
+			if got := server.commands.Load(); got != tc.wantCommands {
+				t.Errorf("server received %d commands, want %d", got, tc.wantCommands)
+			}
+		})
+	}
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		t.Parallel()
+		server := newFlakyRedis(t, func(int64) string { return "" })
+		store := kv.NewRedisWithOptions(server.listener.Addr().String(), kv.RedisOptions{
+			Retry: kv.RetryPolicy{Attempts: 100, Delay: time.Hour, MaxDelay: time.Hour},
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := store.Get(ctx, "key")
+		if !errors.Is(err, kv.ErrUnavailable) {
+			t.Errorf("Get() error = %v, want %v", err, kv.ErrUnavailable)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("Get() took %s, want it to stop when the context is done", elapsed)
+		}
+	})
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		reply           string
+		wantUnavailable bool
+	}{
+		{reply: "-LOADING Redis is loading the dataset in memory\r\n", wantUnavailable: true},
+		{reply: "-BUSY Redis is busy running a script\r\n", wantUnavailable: true},
+		{reply: "-TRYAGAIN Multiple keys request during rehashing of slot\r\n", wantUnavailable: true},
+		{reply: "-MASTERDOWN Link with MASTER is down\r\n", wantUnavailable: true},
+		{reply: "-CLUSTERDOWN The cluster is down\r\n", wantUnavailable: true},
+		{reply: "", wantUnavailable: true},
+		{reply: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{reply: "-ERR syntax error\r\n"},
+	} {
+		name, _, _ := strings.Cut(strings.TrimPrefix(tc.reply, "-"), " ")
+		if name == "" {
+			name = "ConnectionClosed"
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			server := newFlakyRedis(t, func(int64) string { return tc.reply })
+			store := kv.NewRedisWithOptions(server.listener.Addr().String(), kv.RedisOptions{
+				Retry: kv.RetryPolicy{Attempts: 1},
+			})
+			_, err := store.Get(context.Background(), "key")
+			if err == nil {
+				t.Fatal("Get() error = nil, want an error")
+			}
+			if got := errors.Is(err, kv.ErrUnavailable); got != tc.wantUnavailable {
+				t.Errorf("Get() error = %v, unavailable = %t, want %t", err, got, tc.wantUnavailable)
+			}
+		})
+	}
+}
+
+func TestRedisCodec(t *testing.T) {
+	t.Parallel()
+
+	small := "true"
+	large := strings.Repeat(`{"starred_at":"2024-01-01T00:00:00Z"}`, 20)
+
+	for _, tc := range []struct {
+		name     string
+		opts     kv.RedisOptions
+		wantGzip bool
+	}{
+		{name: "None", opts: kv.RedisOptions{}},
+		{name: "Gzip", opts: kv.RedisOptions{Codec: kv.CodecGzip}, wantGzip: true},
+		{name: "GzipHash", opts: kv.RedisOptions{Codec: kv.CodecGzip, Layout: kv.LayoutHashPerRepo}, wantGzip: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			server := newFakeRedis(t)
+			store := kv.NewRedisWithOptions(server.listener.Addr().String(), tc.opts)
+			ctx := context.Background()
+
+			pairs := [][2]string{
+				{"prefix:coder/coder/small", small},
+				{"prefix:coder/coder/large", large},
+			}
+			if err := store.Setex(ctx, 60, pairs); err != nil {
+				t.Fatalf("Setex() error = %v", err)
+			}
+
+			raw := server.stored("prefix:coder/coder/large")
+			if compressed := strings.Contains(raw, "\x1f\x8b"); compressed != tc.wantGzip {
+				t.Errorf("large value compressed = %t, want %t", compressed, tc.wantGzip)
+			}
+			if tc.wantGzip && len(raw) >= len(large) {
+				t.Errorf("compressed value is %d bytes, want fewer than %d", len(raw), len(large))
+			}
+			if raw := server.stored("prefix:coder/coder/small"); !strings.HasSuffix(raw, small) {
+				t.Errorf("small value stored as %q, want it uncompressed", raw)
+			}
+
+			for _, pair := range pairs {
+				got, err := store.Get(ctx, pair[0])
+				if err != nil {
+					t.Fatalf("Get(%q) error = %v", pair[0], err)
+				}
+				if got != pair[1] {
+					t.Errorf("Get(%q) = %q, want %q", pair[0], got, pair[1])
+				}
+			}
+			got, err := store.MGet(ctx, []string{pairs[0][0], "prefix:coder/coder/missing", pairs[1][0]})
+			if err != nil {
+				t.Fatalf("MGet() error = %v", err)
+			}
+			if want := []string{small, "", large}; !slices.Equal(got, want) {
+				t.Errorf("MGet() = %q, want %q", got, want)
+			}
+		})
+	}
+
+	t.Run("ReadsWithoutCodec", func(t *testing.T) {
+		t.Parallel()
+		server := newFakeRedis(t)
+		addr := server.listener.Addr().String()
+		ctx := context.Background()
+		err := kv.NewRedisWithOptions(addr, kv.RedisOptions{Codec: kv.CodecGzip}).Setex(ctx, 60, [][2]string{{"key", large}})
+		if err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		got, err := kv.NewRedis(addr).Get(ctx, "key")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if got != large {
+			t.Errorf("Get() = %q, want %q", got, large)
+		}
+	})
+}
+
+func TestDialRedis(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Reachable", func(t *testing.T) {
+		t.Parallel()
+		server := newFakeRedis(t)
+		if _, err := kv.DialRedis(context.Background(), server.listener.Addr().String(), kv.RedisOptions{}); err != nil {
+			t.Fatalf("DialRedis() error = %v", err)
+		}
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		t.Parallel()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+		addr := listener.Addr().String()
+		_ = listener.Close()
+
+		var logs bytes.Buffer
+		store, err := kv.DialRedis(context.Background(), addr, kv.RedisOptions{
+			DialRetry: kv.RetryPolicy{Attempts: 2, Delay: time.Millisecond, MaxDelay: time.Millisecond},
+			Logger:    slog.New(slog.NewTextHandler(&logs, nil)),
+		})
+		if !errors.Is(err, kv.ErrUnavailable) {
+			t.Errorf("DialRedis() error = %v, want %v", err, kv.ErrUnavailable)
+		}
+		if err == nil || !strings.Contains(err.Error(), addr) {
+			t.Errorf("DialRedis() error = %v, want it to name %s", err, addr)
+		}
+		if store == nil {
+			t.Error("DialRedis() store = nil, want a store to carry on with")
+		}
+		for _, want := range []string{"failed to connect to redis", "redis command failed, retrying"} {
+			if !strings.Contains(logs.String(), want) {
+				t.Errorf("logs = %q, want %q", logs.String(), want)
+			}
+		}
+
+		// The context bounds the retries.
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err = kv.DialRedis(ctx, addr, kv.RedisOptions{
+			DialRetry: kv.RetryPolicy{Attempts: 100, Delay: time.Second, MaxDelay: time.Second},
+		})
+		if err == nil {
+			t.Error("DialRedis() error = nil, want an error")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("DialRedis() took %v, want it to stop at the context deadline", elapsed)
+		}
+	})
+}
+
+func TestExpire(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeRedis(t)
+	addr := server.listener.Addr().String()
+	for _, tc := range []struct {
+		name  string
+		store kv.Store
+	}{
+		{name: "Memory", store: kv.NewMemory()},
+		{name: "Redis", store: kv.NewRedis(addr)},
+		{name: "RedisHash", store: kv.NewRedisWithOptions(addr, kv.RedisOptions{Layout: kv.LayoutHashPerRepo})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			// Keys differ per store, as the Redis stores share the server.
+			key := "ttl:" + tc.name + "/key"
+			wantTTL := func(want time.Duration) {
+				t.Helper()
+				got, err := tc.store.TTL(ctx, key)
+				if err != nil {
+					t.Fatalf("TTL() error = %v", err)
+				}
+				if got > want || got < want-2*time.Second {
+					t.Errorf("TTL() = %v, want about %v", got, want)
+				}
+			}
+
+			// Setex replaces the expiry whether it's sooner or later.
+			for _, seconds := range []uint{100, 10, 1000} {
+				value := fmt.Sprint("value", seconds)
+				if err := tc.store.Setex(ctx, seconds, [][2]string{{key, value}}); err != nil {
+					t.Fatalf("Setex() error = %v", err)
+				}
+				wantTTL(time.Duration(seconds) * time.Second)
+				if got, err := tc.store.Get(ctx, key); err != nil || got != value {
+					t.Errorf("Get() = %q, %v, want %q", got, err, value)
+				}
+			}
+
+			// Expire does too, keeping the value.
+			expirer := tc.store.(kv.Expirer)
+			for _, ttl := range []time.Duration{50 * time.Second, 2000 * time.Second} {
+				if err := expirer.Expire(ctx, key, ttl); err != nil {
+					t.Fatalf("Expire() error = %v", err)
+				}
+				wantTTL(ttl)
+				if got, err := tc.store.Get(ctx, key); err != nil || got != "value1000" {
+					t.Errorf("Get() = %q, %v, want the value kept", got, err)
+				}
+			}
+			if err := expirer.Expire(ctx, "ttl:"+tc.name+"/missing", time.Minute); !errors.Is(err, kv.ErrNotFound) {
+				t.Errorf("Expire(missing) error = %v, want %v", err, kv.ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestRedisHashLayout(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeRedis(t)
+	store := kv.NewRedisWithOptions(server.listener.Addr().String(), kv.RedisOptions{Layout: kv.LayoutHashPerRepo})
+	ctx := context.Background()
+	hashTTL := func() time.Duration {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return time.Until(server.expires["stargazers:coder/coder"])
+	}
+
+	err := store.Setex(ctx, 1000, [][2]string{
+		{"stargazers:coder/coder/kylecarbs", "true"},
+		{"stargazers:coder/coder/ammario", "true"},
+		{"stargazers:coder/code-server/kylecarbs", "true"},
+	})
+	if err != nil {
+		t.Fatalf("Setex() error = %v", err)
+	}
+	if got := server.stored("stargazers:coder/coder/kylecarbs"); !strings.HasSuffix(got, ":true") {
+		t.Errorf("field stored as %q, want it prefixed with its expiry", got)
+	}
+
+	// A shorter-lived field doesn't shorten the hash's expiry.
+	if err := store.Setex(ctx, 10, [][2]string{{"stargazers:coder/coder/bpmct", "true"}}); err != nil {
+		t.Fatalf("Setex() error = %v", err)
+	}
+	if got := hashTTL(); got < 990*time.Second {
+		t.Errorf("hash TTL = %v, want about %v", got, 1000*time.Second)
+	}
+	if err := store.Setex(ctx, 2000, [][2]string{{"stargazers:coder/coder/bpmct", "true"}}); err != nil {
+		t.Fatalf("Setex() error = %v", err)
+	}
+	if got := hashTTL(); got < 1990*time.Second {
+		t.Errorf("hash TTL = %v, want it extended to about %v", got, 2000*time.Second)
+	}
+
+	if got, err := store.Get(ctx, "stargazers:coder/coder/kylecarbs"); err != nil || got != "true" {
+		t.Errorf("Get() = %q, %v, want %q", got, err, "true")
+	}
+	if _, err := store.Get(ctx, "stargazers:coder/coder/missing"); !errors.Is(err, kv.ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want %v", err, kv.ErrNotFound)
+	}
+	got, err := store.MGet(ctx, []string{
+		"stargazers:coder/coder/ammario",
+		"stargazers:coder/coder/missing",
+		"stargazers:coder/code-server/kylecarbs",
+	})
+	if err != nil {
+		t.Fatalf("MGet() error = %v", err)
+	}
+	if want := []string{"true", "", "true"}; !slices.Equal(got, want) {
+		t.Errorf("MGet() = %q, want %q", got, want)
+	}
+
+	counter := store.(kv.Counter)
+	if n, err := counter.Count(ctx, "stargazers:coder/coder/"); err != nil || n != 3 {
+		t.Errorf("Count() = %d, %v, want 3", n, err)
+	}
+	scanner := store.(kv.Scanner)
+	keys, err := scanner.Keys(ctx, "stargazers:coder/coder/")
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+	slices.Sort(keys)
+	if want := []string{"stargazers:coder/coder/ammario", "stargazers:coder/coder/bpmct", "stargazers:coder/coder/kylecarbs"}; !slices.Equal(keys, want) {
+		t.Errorf("Keys() = %q, want %q", keys, want)
+	}
+
+	// Expire fields behind the store's back, as if their TTL passed.
+	server.mu.Lock()
+	server.hashes["stargazers:coder/coder"]["ammario"] = "1:true"
+	server.hashes["stargazers:coder/code-server"]["kylecarbs"] = "1:true"
+	server.mu.Unlock()
+	if _, err := store.Get(ctx, "stargazers:coder/coder/ammario"); !errors.Is(err, kv.ErrNotFound) {
+		t.Errorf("Get(expired) error = %v, want %v", err, kv.ErrNotFound)
+	}
+	if got := server.stored("stargazers:coder/coder/ammario"); got != "1:true" {
+		t.Errorf("expired field stored as %q after a read, want it left to Cleanup", got)
+	}
+	keys, err = scanner.Keys(ctx, "stargazers:coder/coder/")
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+	slices.Sort(keys)
+	if want := []string{"stargazers:coder/coder/bpmct", "stargazers:coder/coder/kylecarbs"}; !slices.Equal(keys, want) {
+		t.Errorf("Keys() = %q, want the unexpired %q", keys, want)
+	}
+	server.mu.Lock()
+	server.hashes["stargazers:coder/coder"]["bpmct"] = "1:true"
+	server.mu.Unlock()
+
+	cleaner := store.(interface {
+		Cleanup(ctx context.Context) (int, error)
+	})
+	// Reads leave expired fields to Cleanup, so they're all removed.
+	if removed, err := cleaner.Cleanup(ctx); err != nil || removed != 3 {
+		t.Errorf("Cleanup() = %d, %v, want 3 removed", removed, err)
+	}
+	if n, err := counter.Count(ctx, "stargazers:coder/coder/"); err != nil || n != 1 {
+		t.Errorf("Count() = %d, %v, want 1 after cleanup", n, err)
+	}
+	if removed, err := cleaner.Cleanup(ctx); err != nil || removed != 0 {
+		t.Errorf("Cleanup() = %d, %v, want nothing removed", removed, err)
+	}
+	// The emptied hash is dropped from the index, which expires with
+	// the hashes.
+	server.mu.Lock()
+	indexed := len(server.sets["stargazers:hashes"])
+	indexTTL := time.Until(server.expires["stargazers:hashes"])
+	server.mu.Unlock()
+	if indexed != 1 {
+		t.Errorf("%d hashes indexed, want 1", indexed)
+	}
+	if indexTTL < 1990*time.Second {
+		t.Errorf("index TTL = %v, want about %v", indexTTL, 2000*time.Second)
+	}
+
+	// Stores with another key prefix keep their own index.
+	other := kv.NewRedisWithOptions(server.listener.Addr().String(), kv.RedisOptions{
+		Layout:    kv.LayoutHashPerRepo,
+		KeyPrefix: "other",
+	})
+	if err := other.Setex(ctx, 1000, [][2]string{{"other:coder/coder/kylecarbs", "true"}}); err != nil {
+		t.Fatalf("Setex() error = %v", err)
+	}
+	server.mu.Lock()
+	otherIndexed := server.sets["other:hashes"]["other:coder/coder"]
+	indexed = len(server.sets["stargazers:hashes"])
+	server.mu.Unlock()
+	if !otherIndexed || indexed != 1 {
+		t.Errorf("other prefix indexed = %t with %d default hashes, want true with 1", otherIndexed, indexed)
+	}
+}
@@ -0,0 +1,265 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// TieredCacheTTL is the longest a tiered store keeps a value in its
+// primary store. It bounds how stale a read can be after another
+// writer changes the secondary, and how much the primary holds to the
+// keys written or read within it.
+const TieredCacheTTL = time.Minute
+
+// DefaultInvalidationChannel is the channel tiered stores publish
+// deleted keys to by default.
+const DefaultInvalidationChannel = "kv:invalidate"
+
+// invalidationRetryDelay is the wait before resubscribing after the
+// invalidation subscription fails.
+const invalidationRetryDelay = 5 * time.Second
+
+// NewTiered returns a store that caches the secondary store, e.g.
+// Redis, in the primary one, e.g. memory. Reads check the primary
+// first and cache what they find in the secondary, and writes and
+// deletes go to both. The secondary is the source of truth: TTLs, key
+// listings, and locks are served by it alone, so it should implement
+// Scanner and Locker if callers need them.
+func NewTiered(primary, secondary Store) Store {
+	return &tiered{primary: primary, secondary: secondary}
+}
+
+// TieredOptions configures a tiered store.
+type TieredOptions struct {
+	// PubSub, if set, broadcasts deleted keys to the other processes
+	// sharing the secondary, so they evict them from their primaries
+	// rather than serving them for up to TieredCacheTTL. Usually the
+	// secondary itself.
+	PubSub PubSub
+	// Channel is where deleted keys are published.
+	// Defaults to DefaultInvalidationChannel.
+	Channel string
+	// Logger receives failures of the invalidation subscription.
+	// Defaults to discarding logs.
+	Logger *slog.Logger
+}
+
+// NewTieredWithOptions is like NewTiered, and subscribes to
+// invalidations from opts.PubSub until ctx is done.
+func NewTieredWithOptions(ctx context.Context, primary, secondary Store, opts TieredOptions) Store {
+	if opts.Channel == "" {
+		opts.Channel = DefaultInvalidationChannel
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	t := &tiered{
+		primary:   primary,
+		secondary: secondary,
+		pubsub:    opts.PubSub,
+		channel:   opts.Channel,
+		logger:    opts.Logger,
+	}
+	if t.pubsub != nil {
+		go t.subscribe(ctx)
+	}
+	return t
+}
+
+type tiered struct {
+	primary   Store
+	secondary Store
+	pubsub    PubSub
+	channel   string
+	logger    *slog.Logger
+
+	primaryHits   atomic.Uint64
+	secondaryHits atomic.Uint64
+	misses        atomic.Uint64
+}
+
+// TieredStats counts the key lookups of a tiered store by the tier
+// that answered them, e.g. to size the primary.
+type TieredStats struct {
+	// PrimaryHits were found in the primary.
+	PrimaryHits uint64
+	// SecondaryHits missed the primary and were found in the
+	// secondary.
+	SecondaryHits uint64
+	// Misses were found in neither.
+	Misses uint64
+}
+
+// TieredStats returns the store's lookup counts since it was created.
+func (t *tiered) TieredStats() TieredStats {
+	return TieredStats{
+		PrimaryHits:   t.primaryHits.Load(),
+		SecondaryHits: t.secondaryHits.Load(),
+		Misses:        t.misses.Load(),
+	}
+}
+
+// subscribe evicts keys deleted by other processes from the primary
+// until ctx is done, resubscribing after failures. Deletes published
+// while resubscribing are missed, and their keys are served from the
+// primary until they expire from it.
+func (t *tiered) subscribe(ctx context.Context) {
+	for {
+		err := t.pubsub.Subscribe(ctx, t.channel, func(key string) {
+			if err := t.primary.Delete(ctx, key); err != nil {
+				t.logger.WarnContext(ctx, "failed to evict invalidated key", "key", key, "error", err)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		t.logger.WarnContext(ctx, "invalidation subscription failed, resubscribing", "delay", invalidationRetryDelay, "error", err)
+		select {
+		case <-time.After(invalidationRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cacheSeconds returns the TTL for values cached in the primary.
+func cacheSeconds(seconds uint) uint {
+	return min(seconds, uint(TieredCacheTTL.Seconds()))
+}
+
+func (t *tiered) Setex(ctx context.Context, seconds uint, pairs [][2]string) error {
+	if err := t.secondary.Setex(ctx, seconds, pairs); err != nil {
+		return err
+	}
+	return t.primary.Setex(ctx, cacheSeconds(seconds), pairs)
+}
+
+func (t *tiered) Get(ctx context.Context, key string) (string, error) {
+	value, err := t.primary.Get(ctx, key)
+	if err == nil {
+		t.primaryHits.Add(1)
+		return value, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+	value, err = t.secondary.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		t.misses.Add(1)
+	}
+	if err != nil {
+		return "", err
+	}
+	t.secondaryHits.Add(1)
+	if err := t.primary.Setex(ctx, uint(TieredCacheTTL.Seconds()), [][2]string{{key, value}}); err != nil {
+		return "", fmt.Errorf("cache %q: %w", key, err)
+	}
+	return value, nil
+}
+
+func (t *tiered) MGet(ctx context.Context, keys []string) ([]string, error) {
+	values, err := t.primary.MGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	var missing []int
+	for i, value := range values {
+		if value == "" {
+			missing = append(missing, i)
+		}
+	}
+	t.primaryHits.Add(uint64(len(keys) - len(missing)))
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	missingKeys := make([]string, len(missing))
+	for i, index := range missing {
+		missingKeys[i] = keys[index]
+	}
+	found, err := t.secondary.MGet(ctx, missingKeys)
+	if err != nil {
+		return nil, err
+	}
+	var cache [][2]string
+	for i, index := range missing {
+		if found[i] == "" {
+			t.misses.Add(1)
+			continue
+		}
+		t.secondaryHits.Add(1)
+		values[index] = found[i]
+		cache = append(cache, [2]string{keys[index], found[i]})
+	}
+	if len(cache) > 0 {
+		if err := t.primary.Setex(ctx, uint(TieredCacheTTL.Seconds()), cache); err != nil {
+			return nil, fmt.Errorf("cache values: %w", err)
+		}
+	}
+	return values, nil
+}
+
+func (t *tiered) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return t.secondary.TTL(ctx, key)
+}
+
+func (t *tiered) Delete(ctx context.Context, key string) error {
+	if err := t.secondary.Delete(ctx, key); err != nil {
+		return err
+	}
+	if err := t.primary.Delete(ctx, key); err != nil {
+		return err
+	}
+	if t.pubsub != nil {
+		if err := t.pubsub.Publish(ctx, t.channel, key); err != nil {
+			return fmt.Errorf("publish invalidation: %w", err)
+		}
+	}
+	return nil
+}
+
+// Expire changes the secondary's expiry and drops the key from the
+// primary, so it's cached again with the new expiry when next read.
+func (t *tiered) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	expirer, ok := t.secondary.(Expirer)
+	if !ok {
+		return errors.New("secondary store does not support expiry")
+	}
+	if err := expirer.Expire(ctx, key, ttl); err != nil {
+		return err
+	}
+	return t.primary.Delete(ctx, key)
+}
+
+func (t *tiered) Ping(ctx context.Context) error {
+	return errors.Join(t.primary.Ping(ctx), t.secondary.Ping(ctx))
+}
+
+func (t *tiered) Keys(ctx context.Context, prefix string) ([]string, error) {
+	scanner, ok := t.secondary.(Scanner)
+	if !ok {
+		return nil, errors.New("secondary store does not support listing keys")
+	}
+	return scanner.Keys(ctx, prefix)
+}
+
+func (t *tiered) Lock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	locker, ok := t.secondary.(Locker)
+	if !ok {
+		return false, errors.New("secondary store does not support locks")
+	}
+	return locker.Lock(ctx, key, owner, ttl)
+}
+
+func (t *tiered) Unlock(ctx context.Context, key, owner string) error {
+	locker, ok := t.secondary.(Locker)
+	if !ok {
+		return errors.New("secondary store does not support locks")
+	}
+	return locker.Unlock(ctx, key, owner)
+}
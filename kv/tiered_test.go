@@ -0,0 +1,206 @@
+package kv_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+)
+
+func TestTieredStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	newStores := func() (*clock.Fake, kv.Store, kv.Store, kv.Store) {
+		c := clock.NewFake(time.Unix(0, 0))
+		primary := kv.NewMemoryWithClock(c)
+		secondary := kv.NewMemoryWithClock(c)
+		return c, primary, secondary, kv.NewTiered(primary, secondary)
+	}
+
+	t.Run("MissPopulatesPrimary", func(t *testing.T) {
+		t.Parallel()
+		c, primary, secondary, store := newStores()
+		if err := secondary.Setex(ctx, 3600, [][2]string{{"key", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+
+		got, err := store.Get(ctx, "key")
+		if err != nil || got != "value" {
+			t.Fatalf("Get() = %q, %v, want %q", got, err, "value")
+		}
+		if got, err := primary.Get(ctx, "key"); err != nil || got != "value" {
+			t.Errorf("primary Get() = %q, %v, want the value cached", got, err)
+		}
+
+		// Cached values outlive changes to the secondary for at most
+		// TieredCacheTTL.
+		if err := secondary.Setex(ctx, 3600, [][2]string{{"key", "changed"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		if got, _ := store.Get(ctx, "key"); got != "value" {
+			t.Errorf("Get() = %q, want the cached %q", got, "value")
+		}
+		c.Advance(kv.TieredCacheTTL)
+		if got, _ := store.Get(ctx, "key"); got != "changed" {
+			t.Errorf("Get() = %q, want %q after the cache expired", got, "changed")
+		}
+
+		if _, err := store.Get(ctx, "missing"); !errors.Is(err, kv.ErrNotFound) {
+			t.Errorf("Get(missing) error = %v, want %v", err, kv.ErrNotFound)
+		}
+	})
+
+	t.Run("MGet", func(t *testing.T) {
+		t.Parallel()
+		_, primary, secondary, store := newStores()
+		if err := primary.Setex(ctx, 60, [][2]string{{"a", "primary"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		if err := secondary.Setex(ctx, 3600, [][2]string{{"a", "secondary"}, {"b", "secondary"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+
+		got, err := store.MGet(ctx, []string{"a", "b", "c"})
+		if err != nil {
+			t.Fatalf("MGet() error = %v", err)
+		}
+		if want := []string{"primary", "secondary", ""}; !slices.Equal(got, want) {
+			t.Errorf("MGet() = %q, want %q", got, want)
+		}
+		if got, err := primary.Get(ctx, "b"); err != nil || got != "secondary" {
+			t.Errorf("primary Get(b) = %q, %v, want the value cached", got, err)
+		}
+	})
+
+	t.Run("WriteThrough", func(t *testing.T) {
+		t.Parallel()
+		_, primary, secondary, store := newStores()
+		if err := store.Setex(ctx, 3600, [][2]string{{"key", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		for name, s := range map[string]kv.Store{"primary": primary, "secondary": secondary} {
+			if got, err := s.Get(ctx, "key"); err != nil || got != "value" {
+				t.Errorf("%s Get() = %q, %v, want %q", name, got, err, "value")
+			}
+		}
+		if ttl, err := primary.TTL(ctx, "key"); err != nil || ttl > kv.TieredCacheTTL {
+			t.Errorf("primary TTL() = %v, %v, want at most %v", ttl, err, kv.TieredCacheTTL)
+		}
+		if ttl, err := store.TTL(ctx, "key"); err != nil || ttl != time.Hour {
+			t.Errorf("TTL() = %v, %v, want the secondary's %v", ttl, err, time.Hour)
+		}
+
+		if err := store.Delete(ctx, "key"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		for name, s := range map[string]kv.Store{"primary": primary, "secondary": secondary} {
+			if _, err := s.Get(ctx, "key"); !errors.Is(err, kv.ErrNotFound) {
+				t.Errorf("%s Get() error = %v, want %v", name, err, kv.ErrNotFound)
+			}
+		}
+	})
+
+	t.Run("Secondary", func(t *testing.T) {
+		t.Parallel()
+		_, _, secondary, store := newStores()
+		if err := secondary.Setex(ctx, 3600, [][2]string{{"prefix:a", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		keys, err := store.(kv.Scanner).Keys(ctx, "prefix:")
+		if err != nil || !slices.Equal(keys, []string{"prefix:a"}) {
+			t.Errorf("Keys() = %q, %v, want the secondary's keys", keys, err)
+		}
+		locked, err := store.(kv.Locker).Lock(ctx, "lock", "owner", time.Minute)
+		if err != nil || !locked {
+			t.Fatalf("Lock() = %v, %v, want true", locked, err)
+		}
+		if locked, _ := secondary.(kv.Locker).Lock(ctx, "lock", "other", time.Minute); locked {
+			t.Error("secondary Lock() = true, want the lock held in the secondary")
+		}
+	})
+
+	t.Run("Invalidation", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		server := newFakeRedis(t)
+		addr := server.listener.Addr().String()
+
+		// Two replicas, each with its own memory cache over the same Redis.
+		newReplica := func() (kv.Store, kv.Store) {
+			primary := kv.NewMemory()
+			secondary := kv.NewRedis(addr)
+			return primary, kv.NewTieredWithOptions(ctx, primary, secondary, kv.TieredOptions{
+				PubSub: secondary.(kv.PubSub),
+			})
+		}
+		_, first := newReplica()
+		secondPrimary, second := newReplica()
+		for server.subscriberCount(kv.DefaultInvalidationChannel) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+
+		if err := first.Setex(ctx, 3600, [][2]string{{"key", "value"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		if got, err := second.Get(ctx, "key"); err != nil || got != "value" {
+			t.Fatalf("Get() = %q, %v, want %q", got, err, "value")
+		}
+		if err := first.Delete(ctx, "key"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, err := secondPrimary.Get(ctx, "key")
+			if errors.Is(err, kv.ErrNotFound) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("the other replica's cache still holds the deleted key")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if _, err := second.Get(ctx, "key"); !errors.Is(err, kv.ErrNotFound) {
+			t.Errorf("Get() error = %v, want %v", err, kv.ErrNotFound)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		t.Parallel()
+		_, primary, secondary, store := newStores()
+		if err := primary.Setex(ctx, 60, [][2]string{{"a", "primary"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+		if err := secondary.Setex(ctx, 3600, [][2]string{{"a", "secondary"}, {"b", "secondary"}, {"c", "secondary"}}); err != nil {
+			t.Fatalf("Setex() error = %v", err)
+		}
+
+		stats := store.(interface{ TieredStats() kv.TieredStats })
+		if _, err := store.Get(ctx, "a"); err != nil {
+			t.Fatalf("Get(a) error = %v", err)
+		}
+		if _, err := store.Get(ctx, "b"); err != nil {
+			t.Fatalf("Get(b) error = %v", err)
+		}
+		if _, err := store.Get(ctx, "missing"); !errors.Is(err, kv.ErrNotFound) {
+			t.Fatalf("Get(missing) error = %v, want %v", err, kv.ErrNotFound)
+		}
+		if got, want := stats.TieredStats(), (kv.TieredStats{PrimaryHits: 1, SecondaryHits: 1, Misses: 1}); got != want {
+			t.Errorf("TieredStats() after Get = %+v, want %+v", got, want)
+		}
+
+		// b is now cached in the primary.
+		if _, err := store.MGet(ctx, []string{"a", "b", "c", "missing"}); err != nil {
+			t.Fatalf("MGet() error = %v", err)
+		}
+		if got, want := stats.TieredStats(), (kv.TieredStats{PrimaryHits: 3, SecondaryHits: 2, Misses: 2}); got != want {
+			t.Errorf("TieredStats() after MGet = %+v, want %+v", got, want)
+		}
+	})
+}
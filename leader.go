@@ -0,0 +1,62 @@
+package starquery
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultLeaderLockTTL is how long leadership lasts without renewal.
+const DefaultLeaderLockTTL = 30 * time.Second
+
+// isLeader reports whether this instance should run the fetch loop.
+func (a *API) isLeader() bool {
+	return a.locker == nil || a.leader.Load()
+}
+
+// leaderKey is the store key of the leader lock.
+func (a *API) leaderKey() string {
+	return a.keyPrefix + ":leader"
+}
+
+// leaderLoop acquires and renews the leader lock until ctx is done,
+// then releases it.
+func (a *API) leaderLoop(ctx context.Context) {
+	defer a.wg.Done()
+
+	ticker := a.clock.NewTicker(a.leaderLockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		held, err := a.locker.Lock(ctx, a.leaderKey(), a.instanceID, a.leaderLockTTL)
+		if err != nil && ctx.Err() == nil {
+			// The lock may expire before we can renew it, so step down.
+			a.logger.Warn("failed to renew leader lock", "error", err)
+			held = false
+		}
+		if ctx.Err() != nil {
+			held = a.leader.Load()
+		}
+		if held != a.leader.Swap(held) {
+			a.logger.Info("leadership changed", "leader", held, "instance", a.instanceID)
+			if held {
+				select {
+				case a.leaderAcquired <- struct{}{}:
+				default:
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			if a.leader.Swap(false) {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := a.locker.Unlock(ctx, a.leaderKey(), a.instanceID); err != nil {
+					a.logger.Warn("failed to release leader lock", "error", err)
+				}
+				cancel()
+			}
+			return
+		}
+	}
+}
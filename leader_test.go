@@ -0,0 +1,58 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaderElection(t *testing.T) {
+	t.Parallel()
+
+	store := kv.NewMemory()
+	newAPI := func(gh *fakeGitHub) *starquery.API {
+		return starquery.New(context.Background(), starquery.Options{
+			Client:         gh.client(),
+			KV:             store,
+			Repos:          []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			LeaderElection: true,
+			LeaderLockTTL:  60 * time.Millisecond,
+		})
+	}
+	leader := func(api *starquery.API) bool {
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		var body struct {
+			Leader *bool `json:"leader"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		require.NotNil(t, body.Leader)
+		return *body.Leader
+	}
+
+	var firstGitHub, secondGitHub fakeGitHub
+	first := newAPI(&firstGitHub)
+	require.Eventually(t, func() bool {
+		return leader(first) && firstGitHub.fetches.Load() > 0
+	}, time.Second, time.Millisecond)
+
+	second := newAPI(&secondGitHub)
+	defer second.Close()
+	// Give the second instance several renewal periods to (wrongly) take over.
+	time.Sleep(100 * time.Millisecond)
+	require.False(t, leader(second))
+	require.Zero(t, secondGitHub.fetches.Load())
+
+	// Closing the leader releases the lock for the other instance.
+	first.Close()
+	require.Eventually(t, func() bool {
+		return leader(second) && secondGitHub.fetches.Load() > 0
+	}, time.Second, time.Millisecond)
+}
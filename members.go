@@ -0,0 +1,87 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coder/starquery/kv"
+	"github.com/google/go-github/v52/github"
+)
+
+// DefaultMemberTTL is how long org members are stored by default.
+const DefaultMemberTTL = 30 * 24 * time.Hour
+
+// memberKey returns the storage key for the org member.
+func (a *API) memberKey(org, username string) string {
+	return fmt.Sprintf("%s:members:%s/%s", a.keyPrefix, strings.ToLower(org), strings.ToLower(username))
+}
+
+// handleMemberEvent updates the org members from an organization or
+// membership webhook. Team memberships imply org membership, but
+// leaving a team doesn't mean leaving the org, so team removals are
+// ignored.
+func (a *API) handleMemberEvent(w http.ResponseWriter, r *http.Request, event any) {
+	var org, username, action string
+	switch event := event.(type) {
+	case *github.OrganizationEvent:
+		org = event.Organization.GetLogin()
+		username = event.Membership.GetUser().GetLogin()
+		switch event.GetAction() {
+		case "member_added":
+			action = "added"
+		case "member_removed":
+			action = "removed"
+		}
+	case *github.MembershipEvent:
+		org = event.Org.GetLogin()
+		username = event.Member.GetLogin()
+		if event.GetAction() == "added" {
+			action = "added"
+		}
+	}
+	if action == "" {
+		// Invitations, renames, and team removals don't change members.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if org == "" || username == "" {
+		http.Error(w, "missing organization or member", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	key := a.memberKey(org, username)
+	if action == "added" {
+		a.logger.InfoContext(r.Context(), "member added", "org", org, "user", username)
+		err = a.kv.Setex(r.Context(), uint(a.memberTTL.Seconds()), [][2]string{{key, stargazerValue}})
+	} else {
+		a.logger.InfoContext(r.Context(), "member removed", "org", org, "user", username)
+		err = a.kv.Delete(r.Context(), key)
+	}
+	if err != nil {
+		a.writeWebhookStoreError(w, r, "failed to update member data", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleOrgMember returns 404 if the user isn't a known member of the
+// org, and 200 (or 204 with OmitQueryBody) if they are.
+func (a *API) handleOrgMember(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), a.storeTimeout)
+	defer cancel()
+	_, err := a.kv.Get(ctx, a.memberKey(r.PathValue("org"), r.PathValue("username")))
+	if errors.Is(err, kv.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		a.writeStoreError(w, r, err)
+		return
+	}
+	a.writeFound(w, r)
+}
@@ -0,0 +1,88 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/google/go-github/v52/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgMembers(t *testing.T) {
+	t.Parallel()
+
+	organizationEvent := func(action, username string) github.OrganizationEvent {
+		return github.OrganizationEvent{
+			Action:       github.String(action),
+			Membership:   &github.Membership{User: &github.User{Login: github.String(username)}},
+			Organization: &github.Organization{Login: github.String("coder")},
+		}
+	}
+	isMember := func(t *testing.T, api *starquery.API, username string) int {
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/orgs/coder/members/"+username, nil))
+		return res.Code
+	}
+
+	t.Run("AddedAndRemoved", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:            kv.NewMemory(),
+			WebhookSecret: "secret",
+			OrgMembers:    true,
+		})
+		defer api.Close()
+		require.Equal(t, http.StatusNotFound, isMember(t, api, "kylecarbs"))
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateEventWebhook(t, "secret", "organization", organizationEvent("member_added", "kylecarbs")))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, http.StatusOK, isMember(t, api, "kylecarbs"))
+
+		res = httptest.NewRecorder()
+		api.ServeHTTP(res, generateEventWebhook(t, "secret", "organization", organizationEvent("member_removed", "kylecarbs")))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, http.StatusNotFound, isMember(t, api, "kylecarbs"))
+	})
+
+	t.Run("TeamMembership", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:            kv.NewMemory(),
+			WebhookSecret: "secret",
+			OrgMembers:    true,
+		})
+		defer api.Close()
+		for _, action := range []string{"added", "removed"} {
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, generateEventWebhook(t, "secret", "membership", github.MembershipEvent{
+				Action: github.String(action),
+				Scope:  github.String("team"),
+				Member: &github.User{Login: github.String("kylecarbs")},
+				Org:    &github.Organization{Login: github.String("coder")},
+			}))
+			require.Equal(t, http.StatusOK, res.Code)
+		}
+		// Leaving a team doesn't mean leaving the org.
+		require.Equal(t, http.StatusOK, isMember(t, api, "kylecarbs"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:            store,
+			WebhookSecret: "secret",
+		})
+		defer api.Close()
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateEventWebhook(t, "secret", "organization", organizationEvent("member_added", "kylecarbs")))
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		_, err := store.Get(context.Background(), "stargazers:members:coder/kylecarbs")
+		require.ErrorIs(t, err, kv.ErrNotFound)
+	})
+}
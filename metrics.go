@@ -0,0 +1,85 @@
+package starquery
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/coder/starquery/kv"
+)
+
+// handleMetrics writes metrics in the Prometheus text exposition format.
+func (a *API) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetric(w, "starquery_webhooks_dropped_total", "counter", "Webhooks acknowledged without updating the store because it failed.", a.webhooksDropped.Load())
+
+	if a.querySlots != nil {
+		writeMetric(w, "starquery_queries_in_flight", "gauge", "Queries being served.", len(a.querySlots))
+		writeMetric(w, "starquery_queries_rejected_total", "counter", "Queries rejected with a 503 because too many were in flight.", a.queriesRejected.Load())
+	}
+
+	if store, ok := a.kv.(interface{ Stats() kv.MemoryStats }); ok {
+		stats := store.Stats()
+		writeMetric(w, "starquery_memory_store_entries", "gauge", "Number of entries in the memory store.", stats.Entries)
+		writeMetric(w, "starquery_memory_store_bytes", "gauge", "Approximate size of keys and values in the memory store.", stats.Bytes)
+		writeMetric(w, "starquery_memory_store_hits_total", "counter", "Memory store lookups that found the key.", stats.Hits)
+		writeMetric(w, "starquery_memory_store_misses_total", "counter", "Memory store lookups that did not find the key.", stats.Misses)
+		if !stats.LastCompaction.Time.IsZero() {
+			writeMetric(w, "starquery_memory_store_last_compaction_timestamp_seconds", "gauge", "When the memory store was last compacted.", stats.LastCompaction.Time.Unix())
+			writeMetric(w, "starquery_memory_store_last_compaction_removed", "gauge", "Expired entries removed by the last memory store compaction.", stats.LastCompaction.Removed)
+		}
+		if stats.GroupEntries != nil {
+			writeMetric(w, "starquery_memory_store_evicted_total", "counter", "Memory store entries evicted from full groups.", stats.Evicted)
+			groups := make([]string, 0, len(stats.GroupEntries))
+			for group := range stats.GroupEntries {
+				groups = append(groups, group)
+			}
+			slices.Sort(groups)
+			fmt.Fprintf(w, "# HELP starquery_memory_store_group_entries Number of entries in each group of the memory store, e.g. a repo's stargazers.\n# TYPE starquery_memory_store_group_entries gauge\n")
+			for _, group := range groups {
+				fmt.Fprintf(w, "starquery_memory_store_group_entries{group=%q} %d\n", group, stats.GroupEntries[group])
+			}
+		}
+	}
+
+	if store, ok := a.kv.(interface{ TieredStats() kv.TieredStats }); ok {
+		stats := store.TieredStats()
+		writeMetric(w, "starquery_tiered_store_primary_hits_total", "counter", "Tiered store lookups found in the memory cache.", stats.PrimaryHits)
+		writeMetric(w, "starquery_tiered_store_secondary_hits_total", "counter", "Tiered store lookups that missed the memory cache and were found in Redis.", stats.SecondaryHits)
+		writeMetric(w, "starquery_tiered_store_misses_total", "counter", "Tiered store lookups found in neither the memory cache nor Redis.", stats.Misses)
+	}
+
+	if a.reconcile {
+		repos := a.Repos()
+		a.deltaMu.Lock()
+		totals := make([]StarDelta, len(repos))
+		for i, repo := range repos {
+			totals[i] = a.starDeltas[repo.lower()]
+		}
+		a.deltaMu.Unlock()
+		fmt.Fprintf(w, "# HELP starquery_reconciled_stars_added_total Stargazers found by full passes that weren't stored before them.\n# TYPE starquery_reconciled_stars_added_total counter\n")
+		for i, repo := range repos {
+			fmt.Fprintf(w, "starquery_reconciled_stars_added_total{repo=%q} %d\n", repo.String(), totals[i].Added)
+		}
+		fmt.Fprintf(w, "# HELP starquery_reconciled_stars_removed_total Stored stargazers deleted because full passes didn't find them.\n# TYPE starquery_reconciled_stars_removed_total counter\n")
+		for i, repo := range repos {
+			fmt.Fprintf(w, "starquery_reconciled_stars_removed_total{repo=%q} %d\n", repo.String(), totals[i].Removed)
+		}
+	}
+
+	if a.budget != nil {
+		repos := a.Repos()
+		used, share := a.budget.usage(len(repos))
+		writeMetric(w, "starquery_rate_budget_share", "gauge", "GraphQL requests each repo may make per rate limit window.", share)
+		fmt.Fprintf(w, "# HELP starquery_rate_budget_used GraphQL requests made for the repo this rate limit window.\n# TYPE starquery_rate_budget_used gauge\n")
+		for _, repo := range repos {
+			fmt.Fprintf(w, "starquery_rate_budget_used{repo=%q} %d\n", repo.String(), used[repo])
+		}
+	}
+}
+
+// writeMetric writes a single unlabeled sample with its metadata.
+func writeMetric(w io.Writer, name, typ, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+}
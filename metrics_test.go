@@ -0,0 +1,99 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	t.Run("MemoryStore", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{KV: store})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		err := store.Setex(ctx, 60, [][2]string{{repo.Key("kylecarbs"), "true"}})
+		require.NoError(t, err)
+		api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil))
+		api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/coder/coder/user/other", nil))
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+		body := res.Body.String()
+		require.Contains(t, body, "starquery_memory_store_entries 1\n")
+		require.Contains(t, body, "starquery_memory_store_hits_total 1\n")
+		require.Contains(t, body, "starquery_memory_store_misses_total 1\n")
+		require.NotContains(t, body, "starquery_memory_store_group_entries")
+		require.NotContains(t, body, "starquery_memory_store_last_compaction")
+
+		store.(interface{ Compact() kv.MemoryCompaction }).Compact()
+		res = httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Contains(t, res.Body.String(), "starquery_memory_store_last_compaction_removed 0\n")
+	})
+
+	t.Run("MemoryStoreGroups", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemoryWithOptions(kv.MemoryOptions{MaxEntriesPerGroup: 1})
+		api := starquery.New(ctx, starquery.Options{KV: store})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		err := store.Setex(ctx, 60, [][2]string{{repo.Key("kylecarbs"), "true"}, {repo.Key("ammar"), "true"}})
+		require.NoError(t, err)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		body := res.Body.String()
+		require.Contains(t, body, "starquery_memory_store_group_entries{group=\"stargazers:coder/coder\"} 1\n")
+		require.Contains(t, body, "starquery_memory_store_evicted_total 1\n")
+	})
+
+	t.Run("TieredStore", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		primary, secondary := kv.NewMemory(), kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{
+			KV:               kv.NewTiered(primary, secondary),
+			DisableFetchLoop: true,
+		})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		err := secondary.Setex(ctx, 60, [][2]string{{repo.Key("kylecarbs"), "true"}})
+		require.NoError(t, err)
+		// Found in Redis, then in the memory cache, then in neither.
+		api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil))
+		api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil))
+		api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/coder/coder/user/other", nil))
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		body := res.Body.String()
+		require.Contains(t, body, "starquery_tiered_store_primary_hits_total 1\n")
+		require.Contains(t, body, "starquery_tiered_store_secondary_hits_total 1\n")
+		require.Contains(t, body, "starquery_tiered_store_misses_total 1\n")
+	})
+
+	t.Run("OtherStore", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV: errorStore{Store: kv.NewMemory()},
+		})
+		defer api.Close()
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+		require.NotContains(t, res.Body.String(), "starquery_memory_store")
+		require.NotContains(t, res.Body.String(), "starquery_tiered_store")
+	})
+}
@@ -0,0 +1,59 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/coder/starquery/kv"
+)
+
+// milestoneKey returns the storage key holding the highest milestone
+// the repo has reached, which has already been notified.
+func (a *API) milestoneKey(repo Repo) string {
+	return a.keyPrefix + ":milestone:" + repo.lower().String()
+}
+
+// checkMilestones calls OnMilestone if the repo's star count has
+// reached a higher milestone than when it was last checked. The first
+// check only records the milestone reached, so deploying doesn't notify
+// milestones passed long ago. A milestone whose callback fails is
+// retried on the next check.
+func (a *API) checkMilestones(ctx context.Context, repo Repo, count int) error {
+	reached := 0
+	for _, milestone := range a.milestones {
+		if count >= milestone {
+			reached = max(reached, milestone)
+		}
+	}
+
+	key := a.milestoneKey(repo)
+	raw, err := a.kv.Get(ctx, key)
+	switch {
+	case errors.Is(err, kv.ErrNotFound):
+	case err != nil:
+		return fmt.Errorf("get milestone: %w", err)
+	default:
+		last, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("parse milestone %q: %w", raw, err)
+		}
+		if reached > last {
+			a.logger.Info("repo reached a star milestone", "repo", repo, "milestone", reached, "count", count)
+			if err := a.onMilestone(ctx, repo, reached); err != nil {
+				return fmt.Errorf("milestone callback: %w", err)
+			}
+		}
+		// Unstars that drop the count below a milestone don't let it
+		// be notified again.
+		reached = max(reached, last)
+	}
+
+	// Rewriting the milestone every check keeps it from expiring.
+	pair := [2]string{key, strconv.Itoa(reached)}
+	if err := a.kv.Setex(ctx, uint(a.historyRetention.Seconds()), [][2]string{pair}); err != nil {
+		return fmt.Errorf("store milestone: %w", err)
+	}
+	return nil
+}
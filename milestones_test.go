@@ -0,0 +1,145 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMilestones(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := kv.NewMemoryWithClock(clk)
+	var count atomic.Int64
+	var mu sync.Mutex
+	var notified []int
+	newAPI := func() *starquery.API {
+		return starquery.New(context.Background(), starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					data, _ := io.ReadAll(req.Body)
+					body := `{"data": {"repository": {"stargazers": {"edges": []}}, "rateLimit": {"remaining": 50}}}`
+					if bytes.Contains(data, []byte("stargazerCount")) {
+						body = fmt.Sprintf(`{"data": {"repository": {"stargazerCount": %d}}}`, count.Load())
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewBufferString(body)),
+					}, nil
+				}),
+			},
+			KV:         store,
+			Repos:      []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			Clock:      clk,
+			Milestones: []int{1000, 5000, 10000},
+			OnMilestone: func(_ context.Context, _ starquery.Repo, milestone int) error {
+				mu.Lock()
+				defer mu.Unlock()
+				notified = append(notified, milestone)
+				return nil
+			},
+		})
+	}
+	getNotified := func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), notified...)
+	}
+	// recorded reports whether the sync recorded the star count.
+	recorded := func(want int64) func() bool {
+		return func() bool {
+			value, err := store.Get(context.Background(), "stargazers:starcount:coder/coder:"+clk.Now().Format("2006-01-02"))
+			return err == nil && value == strconv.FormatInt(want, 10)
+		}
+	}
+
+	// Milestones already passed on the first check aren't notified.
+	count.Store(1200)
+	api := newAPI()
+	require.Eventually(t, func() bool {
+		value, _ := store.Get(context.Background(), "stargazers:milestone:coder/coder")
+		return value == "1000"
+	}, 5*time.Second, time.Millisecond)
+	require.Empty(t, getNotified())
+
+	// Not crossing a milestone doesn't notify.
+	count.Store(4999)
+	clk.Advance(starquery.DefaultFetchInterval)
+	require.Eventually(t, recorded(4999), 5*time.Second, time.Millisecond)
+	require.Empty(t, getNotified())
+
+	// Crossing several at once notifies the highest.
+	count.Store(10001)
+	clk.Advance(starquery.DefaultFetchInterval)
+	require.Eventually(t, func() bool {
+		return len(getNotified()) == 1
+	}, 5*time.Second, time.Millisecond)
+	require.Equal(t, []int{10000}, getNotified())
+	api.Close()
+
+	// The notified milestone survives a restart, and dropping below it
+	// and back doesn't notify it again.
+	count.Store(9999)
+	api = newAPI()
+	defer api.Close()
+	require.Eventually(t, recorded(9999), 5*time.Second, time.Millisecond)
+	count.Store(10002)
+	clk.Advance(starquery.DefaultFetchInterval)
+	require.Eventually(t, recorded(10002), 5*time.Second, time.Millisecond)
+	require.Equal(t, []int{10000}, getNotified())
+}
+
+// TestMilestonesCappedSync verifies that a repo whose star sync is
+// always capped still records its star count and milestones.
+func TestMilestonesCappedSync(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := kv.NewMemoryWithClock(clk)
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				data, _ := io.ReadAll(req.Body)
+				// Every page has another after it, so the sync is capped.
+				body := `{"data": {"repository": {"stargazers": {"edges": [{"node": {"login": "user"}, "cursor": "c1"}]}}, "rateLimit": {"remaining": 50}}}`
+				if bytes.Contains(data, []byte("stargazerCount")) {
+					body = `{"data": {"repository": {"stargazerCount": 1200}}}`
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(body)),
+				}, nil
+			}),
+		},
+		KV:                   store,
+		Repos:                []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		Clock:                clk,
+		MaxStargazersPerSync: 1,
+		Milestones:           []int{1000},
+		OnMilestone: func(context.Context, starquery.Repo, int) error {
+			return nil
+		},
+	})
+	defer api.Close()
+
+	require.Eventually(t, func() bool {
+		value, _ := store.Get(context.Background(), "stargazers:milestone:coder/coder")
+		return value == "1000"
+	}, 5*time.Second, time.Millisecond)
+	value, err := store.Get(context.Background(), "stargazers:starcount:coder/coder:2024-01-01")
+	require.NoError(t, err)
+	require.Equal(t, "1200", value)
+}
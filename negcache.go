@@ -0,0 +1,71 @@
+package starquery
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultNegativeCacheSize is the default number of missing keys the
+// negative cache remembers.
+const DefaultNegativeCacheSize = 100_000
+
+// negativeCache remembers store keys that were recently missing, so
+// repeated queries for users who haven't starred don't reach the store.
+// It's local to the instance. Entries expire after ttl, and the oldest
+// are evicted once it holds size keys.
+type negativeCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	size int
+	// expires holds the live entries. queue holds them in the order
+	// they were added, which is also the order they expire in, along
+	// with entries since removed or re-added, which are skipped.
+	expires map[string]time.Time
+	queue   []negativeEntry
+}
+
+type negativeEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newNegativeCache(ttl time.Duration, size int) *negativeCache {
+	return &negativeCache{
+		ttl:     ttl,
+		size:    size,
+		expires: make(map[string]time.Time),
+	}
+}
+
+// contains reports whether the key was recently missing.
+func (c *negativeCache) contains(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires, ok := c.expires[key]
+	return ok && now.Before(expires)
+}
+
+// add remembers that the key is missing.
+func (c *negativeCache) add(key string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := negativeEntry{key: key, expires: now.Add(c.ttl)}
+	c.expires[key] = entry.expires
+	c.queue = append(c.queue, entry)
+	for len(c.queue) > 0 && (len(c.expires) > c.size || !now.Before(c.queue[0].expires)) {
+		oldest := c.queue[0]
+		c.queue = c.queue[1:]
+		if c.expires[oldest.key] == oldest.expires {
+			delete(c.expires, oldest.key)
+		}
+	}
+}
+
+// remove forgets the keys, e.g. once they're stored.
+func (c *negativeCache) remove(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.expires, key)
+	}
+}
@@ -0,0 +1,80 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore is a kv.Store that counts Gets.
+type countingStore struct {
+	kv.Store
+	gets atomic.Int64
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) (string, error) {
+	s.gets.Add(1)
+	return s.Store.Get(ctx, key)
+}
+
+func TestNegativeCache(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	newAPI := func(t *testing.T, size int) (*starquery.API, *countingStore, *clock.Fake) {
+		clk := clock.NewFake(time.Now())
+		store := &countingStore{Store: kv.NewMemoryWithClock(clk)}
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:                store,
+			Clock:             clk,
+			WebhookSecret:     "secret",
+			NegativeCacheTTL:  time.Minute,
+			NegativeCacheSize: size,
+		})
+		t.Cleanup(api.Close)
+		return api, store, clk
+	}
+
+	t.Run("WebhookInvalidates", func(t *testing.T) {
+		t.Parallel()
+		api, store, _ := newAPI(t, 0)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs").Code)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs").Code)
+		require.EqualValues(t, 1, store.gets.Load(), "repeated misses are cached")
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", "created")))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs").Code)
+	})
+
+	t.Run("Expires", func(t *testing.T) {
+		t.Parallel()
+		api, store, clk := newAPI(t, 0)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs").Code)
+		// Stars stored by another instance show once the miss expires.
+		require.NoError(t, store.Setex(context.Background(), 3600, [][2]string{{repo.Key("kylecarbs"), "true"}}))
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs").Code)
+		clk.Advance(time.Minute)
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs").Code)
+	})
+
+	t.Run("Bounded", func(t *testing.T) {
+		t.Parallel()
+		api, store, _ := newAPI(t, 1)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/user1").Code)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/user2").Code)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/user1").Code)
+		require.EqualValues(t, 3, store.gets.Load(), "the oldest miss is evicted")
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/user1").Code)
+		require.EqualValues(t, 3, store.gets.Load())
+	})
+}
@@ -0,0 +1,24 @@
+package starquery
+
+import "net/http"
+
+// limitQueries responds to queries beyond MaxConcurrentQueries with a
+// 503, so a spike backs off instead of exhausting the store's
+// connections.
+func (a *API) limitQueries(next http.HandlerFunc) http.HandlerFunc {
+	if a.querySlots == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case a.querySlots <- struct{}{}:
+		default:
+			a.queriesRejected.Add(1)
+			w.Header().Set("Retry-After", retryAfterSeconds)
+			http.Error(w, "Too many queries in flight", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-a.querySlots }()
+		next(w, r)
+	}
+}
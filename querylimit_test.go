@@ -0,0 +1,75 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingStore is a kv.Store whose Get signals entered, then blocks
+// until release is closed.
+type blockingStore struct {
+	kv.Store
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s blockingStore) Get(ctx context.Context, key string) (string, error) {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.Store.Get(ctx, key)
+}
+
+func TestMaxConcurrentQueries(t *testing.T) {
+	t.Parallel()
+
+	store := blockingStore{
+		Store:   kv.NewMemory(),
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	api := starquery.New(context.Background(), starquery.Options{
+		KV:                   store,
+		DisableFetchLoop:     true,
+		MaxConcurrentQueries: 1,
+	})
+	defer api.Close()
+
+	done := make(chan int)
+	go func() {
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil))
+		done <- res.Code
+	}()
+	<-store.entered
+
+	// The first query holds the only slot, so the next is turned away
+	// without reaching the store.
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil))
+	require.Equal(t, http.StatusServiceUnavailable, res.Code)
+	require.NotEmpty(t, res.Header().Get("Retry-After"))
+
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Contains(t, res.Body.String(), "starquery_queries_in_flight 1\n")
+	require.Contains(t, res.Body.String(), "starquery_queries_rejected_total 1\n")
+
+	close(store.release)
+	require.Equal(t, http.StatusNotFound, <-done)
+
+	// The slot is freed once the query is answered.
+	go func() { <-store.entered }()
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil))
+	require.Equal(t, http.StatusNotFound, res.Code)
+}
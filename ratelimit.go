@@ -0,0 +1,36 @@
+package starquery
+
+import (
+	"context"
+	"time"
+
+	"github.com/coder/starquery/clock"
+)
+
+// RateLimitWaiter decides how long a sync pauses once a page reports
+// that GitHub's rate limit has been reached.
+type RateLimitWaiter interface {
+	// Wait blocks until paging may resume, given when the rate limit
+	// resets and the requests remaining until then. It returns an
+	// error, e.g. ctx.Err(), to stop the sync instead.
+	Wait(ctx context.Context, resetAt time.Time, remaining int) error
+}
+
+// ResetWaiter returns the default RateLimitWaiter, which waits until a
+// second after the rate limit resets.
+func ResetWaiter(c clock.Clock) RateLimitWaiter {
+	return resetWaiter{clock: c}
+}
+
+type resetWaiter struct {
+	clock clock.Clock
+}
+
+func (w resetWaiter) Wait(ctx context.Context, resetAt time.Time, _ int) error {
+	select {
+	case <-w.clock.After(resetAt.Sub(w.clock.Now()) + time.Second):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
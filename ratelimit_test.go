@@ -0,0 +1,112 @@
+package starquery_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+// waiterFunc adapts a function to starquery.RateLimitWaiter.
+type waiterFunc func(ctx context.Context, resetAt time.Time, remaining int) error
+
+func (f waiterFunc) Wait(ctx context.Context, resetAt time.Time, remaining int) error {
+	return f(ctx, resetAt, remaining)
+}
+
+func TestRateLimitWaiter(t *testing.T) {
+	t.Parallel()
+
+	resetAt := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	// exhausted returns a client whose first response reports the rate
+	// limit reached, counting the requests.
+	exhausted := func(requests *atomic.Int64) *http.Client {
+		return &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				body := `{"data": {"repository": {"stargazers": {"edges": []}}, "rateLimit": {"remaining": 5000}}}`
+				if requests.Add(1) == 1 {
+					body = fmt.Sprintf(
+						`{"data": {"repository": {"stargazers": {"edges": [{"node": {"login": "user1"}, "cursor": "c1"}]}}, "rateLimit": {"remaining": 0, "resetAt": %q}}}`,
+						resetAt.Format(time.RFC3339))
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(body)),
+				}, nil
+			}),
+		}
+	}
+
+	t.Run("Custom", func(t *testing.T) {
+		t.Parallel()
+		var requests atomic.Int64
+		var mu sync.Mutex
+		var waits []time.Time
+		var remainings []int
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: exhausted(&requests),
+			KV:     kv.NewMemory(),
+			Repos:  []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			RateLimitWaiter: waiterFunc(func(_ context.Context, resetAt time.Time, remaining int) error {
+				mu.Lock()
+				defer mu.Unlock()
+				waits = append(waits, resetAt)
+				remainings = append(remainings, remaining)
+				return errors.New("give up")
+			}),
+		})
+		defer api.Close()
+
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(waits) == 1
+		}, 5*time.Second, time.Millisecond)
+		require.Equal(t, resetAt, waits[0])
+		require.Equal(t, []int{0}, remainings)
+		// The waiter's error stops the sync instead of fetching the next page.
+		require.Never(t, func() bool {
+			return requests.Load() > 1
+		}, 50*time.Millisecond, time.Millisecond)
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		clk := clock.NewFake(resetAt.Add(-time.Minute))
+		var requests atomic.Int64
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: exhausted(&requests),
+			KV:     kv.NewMemoryWithClock(clk),
+			Repos:  []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			Clock:  clk,
+		})
+		defer api.Close()
+
+		// The fetch loop's ticker and the rate limit wait.
+		require.Eventually(t, func() bool {
+			return clk.Waiters() == 2
+		}, 5*time.Second, time.Millisecond)
+		require.EqualValues(t, 1, requests.Load())
+
+		// Paging resumes a second after the reset.
+		clk.Advance(time.Minute)
+		require.Never(t, func() bool {
+			return requests.Load() > 1
+		}, 50*time.Millisecond, time.Millisecond)
+		clk.Advance(time.Second)
+		require.Eventually(t, func() bool {
+			return requests.Load() >= 2
+		}, 5*time.Second, time.Millisecond)
+	})
+}
@@ -0,0 +1,101 @@
+package starquery
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+)
+
+// errReadOnly is returned instead of storing a fetched page once the
+// API has turned read-only mid-sync.
+var errReadOnly = errors.New("read-only, pausing sync until writes resume")
+
+// pausedSync identifies a sync interrupted by read-only mode.
+type pausedSync struct {
+	relation string
+	repo     Repo
+}
+
+// pauseSync remembers the cursor a full sync of the relation stopped at
+// when the API turned read-only, so the next sync resumes from it. The
+// cursor is kept in memory, as the store can't be written. Incremental
+// syncs start from the newest page anyway, so they aren't remembered.
+func (a *API) pauseSync(rel relation, repo Repo, cursor string, since time.Time) {
+	if cursor == "" || !since.IsZero() {
+		return
+	}
+	a.pausedMu.Lock()
+	defer a.pausedMu.Unlock()
+	a.pausedCursors[pausedSync{relation: rel.name, repo: repo}] = cursor
+}
+
+// takePausedCursor returns and forgets the cursor a sync of the
+// relation was paused at, or "" if it wasn't paused.
+func (a *API) takePausedCursor(rel relation, repo Repo) string {
+	a.pausedMu.Lock()
+	defer a.pausedMu.Unlock()
+	key := pausedSync{relation: rel.name, repo: repo}
+	cursor := a.pausedCursors[key]
+	delete(a.pausedCursors, key)
+	return cursor
+}
+
+// readOnlyRequest is the body of PUT /read-only, and readOnlyResponse
+// the body of both read-only endpoints.
+type readOnlyRequest struct {
+	ReadOnly *bool `json:"read_only"`
+}
+
+type readOnlyResponse struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+// ReadOnly reports whether the API is in read-only maintenance mode.
+func (a *API) ReadOnly() bool {
+	return a.readOnly.Load()
+}
+
+// SetReadOnly turns read-only maintenance mode on or off. While it's
+// on, queries are answered from the store, but nothing is written to
+// it: the fetch loop pauses, syncs in progress stop before storing
+// their next page, webhooks are refused or dropped, and read-through
+// and fresh checks are skipped. It only affects this
+// process, not other replicas.
+func (a *API) SetReadOnly(readOnly bool) {
+	if a.readOnly.Swap(readOnly) != readOnly {
+		a.logger.Warn("read-only mode changed", "read_only", readOnly)
+	}
+}
+
+// handleReadOnly responds with whether the API is read-only.
+func (a *API) handleReadOnly(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(readOnlyResponse{ReadOnly: a.ReadOnly()})
+}
+
+// handleSetReadOnly turns read-only mode on or off as the JSON body
+// asks, e.g. {"read_only": true}.
+func (a *API) handleSetReadOnly(w http.ResponseWriter, r *http.Request) {
+	var req readOnlyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ReadOnly == nil {
+		http.Error(w, `body must be {"read_only": true} or {"read_only": false}`, http.StatusBadRequest)
+		return
+	}
+	a.SetReadOnly(*req.ReadOnly)
+	a.handleReadOnly(w, r)
+}
+
+// writeReadOnlyWebhook responds to a webhook received while read-only,
+// with 202 if webhooks are dropped and 503 otherwise, so the delivery
+// shows as failed and can be redelivered once writes resume.
+func (a *API) writeReadOnlyWebhook(w http.ResponseWriter, r *http.Request) {
+	if a.readOnlyAcceptWebhooks {
+		a.logger.WarnContext(r.Context(), "read-only, dropping webhook", "delivery_id", github.DeliveryID(r))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	http.Error(w, "Read-only maintenance mode, redeliver later", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,197 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnly(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	admin := func(api *starquery.API, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		return res
+	}
+	star := func(api *starquery.API, username string) int {
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, username, "created")))
+		return res.Code
+	}
+
+	t.Run("Toggle", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{KV: store, WebhookSecret: "secret", AdminToken: "token"})
+		defer api.Close()
+		_, err := api.Import(ctx, repo, strings.NewReader("kylecarbs"))
+		require.NoError(t, err)
+
+		res := admin(api, http.MethodGet, "/read-only", "")
+		require.Equal(t, http.StatusOK, res.Code)
+		require.JSONEq(t, `{"read_only": false}`, res.Body.String())
+
+		res = admin(api, http.MethodPut, "/read-only", `{"read_only": true}`)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.JSONEq(t, `{"read_only": true}`, res.Body.String())
+		require.True(t, api.ReadOnly())
+
+		// Queries are still answered, but nothing is written.
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs").Code)
+		require.Equal(t, http.StatusServiceUnavailable, star(api, "bpmct"))
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/bpmct").Code)
+		require.Equal(t, http.StatusServiceUnavailable, admin(api, http.MethodPost, "/coder/coder/import", "bpmct").Code)
+
+		res = httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Contains(t, res.Body.String(), `"read_only":true`)
+
+		res = admin(api, http.MethodPut, "/read-only", `{"read_only": false}`)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, http.StatusOK, star(api, "bpmct"))
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/bpmct").Code)
+	})
+
+	t.Run("InvalidRequest", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory(), AdminToken: "token"})
+		defer api.Close()
+
+		require.Equal(t, http.StatusBadRequest, admin(api, http.MethodPut, "/read-only", `{}`).Code)
+		require.Equal(t, http.StatusBadRequest, admin(api, http.MethodPut, "/read-only", `true`).Code)
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodPut, "/read-only", strings.NewReader(`{"read_only": true}`)))
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+		require.False(t, api.ReadOnly())
+	})
+
+	t.Run("AcceptWebhooks", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:                     kv.NewMemory(),
+			WebhookSecret:          "secret",
+			ReadOnly:               true,
+			ReadOnlyAcceptWebhooks: true,
+		})
+		defer api.Close()
+
+		require.Equal(t, http.StatusAccepted, star(api, "kylecarbs"))
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs").Code)
+	})
+
+	t.Run("PausesFetches", func(t *testing.T) {
+		t.Parallel()
+		var fetches atomic.Int64
+		clk := clock.NewFake(time.Now())
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					fetches.Add(1)
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(strings.NewReader(`{"data": {"repository": {"stargazers": {"edges": []}, "stargazerCount": 0}, "rateLimit": {"remaining": 5000}}}`)),
+					}, nil
+				}),
+			},
+			KV:       kv.NewMemory(),
+			Repos:    []starquery.Repo{repo},
+			Clock:    clk,
+			ReadOnly: true,
+		})
+		defer api.Close()
+
+		require.Never(t, func() bool {
+			return fetches.Load() > 0
+		}, 50*time.Millisecond, time.Millisecond)
+		api.SetReadOnly(false)
+		clk.Advance(starquery.DefaultFetchInterval)
+		require.Eventually(t, func() bool {
+			return fetches.Load() > 0
+		}, 5*time.Second, time.Millisecond)
+	})
+	// Turning read-only mid-sync stops it before the next page is
+	// stored, and the next sync resumes from that page.
+	t.Run("PausesSync", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		pages := map[string]string{
+			"":   `[{"node": {"login": "user1"}, "cursor": "c1"}]`,
+			"c1": `[{"node": {"login": "user2"}, "cursor": "c2"}]`,
+			"c2": `[]`,
+		}
+		requested := make(chan string, 10)
+		release := make(chan struct{})
+		store := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					var body struct {
+						Variables struct {
+							After string `json:"after"`
+						} `json:"variables"`
+					}
+					if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+						return nil, err
+					}
+					requested <- body.Variables.After
+					if body.Variables.After == "c1" {
+						<-release
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body: io.NopCloser(strings.NewReader(fmt.Sprintf(
+							`{"data": {"repository": {"stargazers": {"edges": %s}, "stargazerCount": 2}, "rateLimit": {"remaining": 5000}}}`, pages[body.Variables.After]))),
+					}, nil
+				}),
+			},
+			KV:               store,
+			Repos:            []starquery.Repo{repo},
+			DisableFetchLoop: true,
+		})
+		defer api.Close()
+
+		synced := make(chan error, 1)
+		go func() {
+			synced <- api.Sync(ctx)
+		}()
+		require.Equal(t, "", <-requested)
+		require.Equal(t, "c1", <-requested)
+		keys, err := store.(kv.Scanner).Keys(ctx, "")
+		require.NoError(t, err)
+		api.SetReadOnly(true)
+		close(release)
+		require.NoError(t, <-synced)
+
+		after, err := store.(kv.Scanner).Keys(ctx, "")
+		require.NoError(t, err)
+		require.ElementsMatch(t, keys, after)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/user2").Code)
+
+		// Syncs don't fetch while read-only.
+		require.NoError(t, api.Sync(ctx))
+		require.Empty(t, requested)
+
+		api.SetReadOnly(false)
+		require.NoError(t, api.Sync(ctx))
+		require.Equal(t, "c1", <-requested)
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/user2").Code)
+	})
+}
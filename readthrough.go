@@ -0,0 +1,90 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/starquery/kv"
+	"github.com/google/go-github/v52/github"
+)
+
+const (
+	// DefaultReadThroughLimit is the default number of GitHub lookups
+	// read-through may make per minute.
+	DefaultReadThroughLimit = 30
+	// readThroughTimeout bounds how long a query waits on GitHub.
+	readThroughTimeout = 3 * time.Second
+	// readThroughMissTTL is how long a lookup that found no star is
+	// remembered, so repeated queries for the user don't spend quota.
+	readThroughMissTTL = 10 * time.Minute
+)
+
+// readThroughLimiter allows a fixed number of lookups per minute.
+type readThroughLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Time
+	count  int
+}
+
+func (l *readThroughLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.window) >= time.Minute {
+		l.window = now
+		l.count = 0
+	}
+	if l.count >= l.limit {
+		return false
+	}
+	l.count++
+	return true
+}
+
+// readThroughMissKey returns the storage key remembering that the user
+// wasn't found to have starred the repo.
+func (a *API) readThroughMissKey(repo Repo, username string) string {
+	return a.userKey(a.keyPrefix+":readthrough-miss", repo, username)
+}
+
+// readThrough checks GitHub for a star on a tracked repo that's missing
+// from the store, storing it if found. Only the user's most recent
+// stars are checked, which covers stars made since the last fetch.
+func (a *API) readThrough(ctx context.Context, repo Repo, username string) (bool, error) {
+	if !a.tracks(repo) {
+		return false, nil
+	}
+	_, err := a.kv.Get(ctx, a.readThroughMissKey(repo, username))
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, kv.ErrNotFound) {
+		return false, err
+	}
+	if !a.readThroughLimiter.allow(a.clock.Now()) {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readThroughTimeout)
+	defer cancel()
+	fetchedAt := a.clock.Now()
+	starred, _, err := a.github.Activity.ListStarred(ctx, username, &github.ActivityListStarredOptions{
+		Sort:        "created",
+		Direction:   "desc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return false, fmt.Errorf("list starred: %w", err)
+	}
+	for _, s := range starred {
+		if strings.EqualFold(s.GetRepository().GetFullName(), repo.String()) {
+			return true, a.storeFetched(ctx, stars, repo, []Stargazer{{Login: username}}, fetchedAt)
+		}
+	}
+	miss := [2]string{a.readThroughMissKey(repo, username), stargazerValue}
+	return false, a.kv.Setex(ctx, uint(readThroughMissTTL.Seconds()), [][2]string{miss})
+}
@@ -0,0 +1,66 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadThrough(t *testing.T) {
+	t.Parallel()
+
+	newAPI := func(t *testing.T, store kv.Store, limit int) (*starquery.API, *atomic.Int64) {
+		gh := &fakeGitHub{starred: map[string][]string{
+			"kylecarbs": {"coder/coder"},
+			"ammar":     {"coder/other"},
+		}}
+		api := starquery.New(context.Background(), starquery.Options{
+			Client:           gh.client(),
+			KV:               store,
+			Repos:            []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			ReadThrough:      true,
+			ReadThroughLimit: limit,
+		})
+		t.Cleanup(api.Close)
+		return api, &gh.lookups
+	}
+
+	t.Run("Starred", func(t *testing.T) {
+		t.Parallel()
+		store := kv.NewMemory()
+		api, lookups := newAPI(t, store, 0)
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs").Code)
+		_, err := store.Get(context.Background(), starquery.Repo{Owner: "coder", Name: "coder"}.Key("kylecarbs"))
+		require.NoError(t, err, "found stars are stored")
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs").Code)
+		require.EqualValues(t, 1, lookups.Load())
+	})
+
+	t.Run("NotStarred", func(t *testing.T) {
+		t.Parallel()
+		api, lookups := newAPI(t, kv.NewMemory(), 0)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/ammar").Code)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/ammar").Code)
+		require.EqualValues(t, 1, lookups.Load(), "misses are remembered")
+	})
+
+	t.Run("UntrackedRepo", func(t *testing.T) {
+		t.Parallel()
+		api, lookups := newAPI(t, kv.NewMemory(), 0)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/other/user/ammar").Code)
+		require.Zero(t, lookups.Load())
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		t.Parallel()
+		api, lookups := newAPI(t, kv.NewMemory(), 1)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/ammar").Code)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs").Code)
+		require.EqualValues(t, 1, lookups.Load())
+	})
+}
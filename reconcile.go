@@ -0,0 +1,104 @@
+package starquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StarDelta counts the changes a full pass of a repo's stargazers found
+// with Reconcile: stargazers it fetched that weren't stored before it,
+// and stored ones it didn't fetch, which it deleted.
+type StarDelta struct {
+	Added   int
+	Removed int
+}
+
+// reconciler records the logins a full pass fetches, to compare with
+// the stargazers stored before it. It's nil unless Reconcile is set.
+type reconciler struct {
+	previous []string
+	fetched  map[string]struct{}
+	// started is set if the pass fetched the first page, rather than
+	// resuming from a cursor, and complete once it then fetched the
+	// last.
+	started  bool
+	complete bool
+}
+
+// newReconciler lists the repo's stored stargazers for a full pass to
+// be compared with, or returns nil without Reconcile.
+func (a *API) newReconciler(ctx context.Context, repo Repo) (*reconciler, error) {
+	if !a.reconcile {
+		return nil, nil
+	}
+	previous, err := a.storedLogins(ctx, repo)
+	if err != nil {
+		return nil, fmt.Errorf("list stored stargazers: %w", err)
+	}
+	return &reconciler{previous: previous, fetched: make(map[string]struct{}, len(previous))}, nil
+}
+
+// record wraps fetchPage to record the logins it fetches. It must wrap
+// the fetcher itself, inside capped and resumable, to see the cursors
+// they resume from.
+func (r *reconciler) record(fetchPage pageFetcher) pageFetcher {
+	if r == nil {
+		return fetchPage
+	}
+	return func(ctx context.Context, repo Repo, cursor string) ([]Stargazer, time.Time, int, error) {
+		stargazers, resetTime, remaining, err := fetchPage(ctx, repo, cursor)
+		if err != nil {
+			return nil, time.Time{}, 0, err
+		}
+		if cursor == "" {
+			r.started = true
+		}
+		for _, s := range stargazers {
+			if s.Login != "" {
+				r.fetched[strings.ToLower(s.Login)] = struct{}{}
+			}
+		}
+		if len(stargazers) == 0 && r.started {
+			r.complete = true
+		}
+		return stargazers, resetTime, remaining, nil
+	}
+}
+
+// reconcileStargazers deletes the stargazers stored before a complete
+// full pass that it didn't fetch, returning the pass's delta. Passes
+// resumed from a cursor didn't see every stargazer, so they're skipped
+// with a nil delta.
+func (a *API) reconcileStargazers(ctx context.Context, repo Repo, r *reconciler) (*StarDelta, error) {
+	if r == nil || !r.complete {
+		return nil, nil
+	}
+	var delta StarDelta
+	previous := make(map[string]struct{}, len(r.previous))
+	for _, login := range r.previous {
+		previous[login] = struct{}{}
+		if _, ok := r.fetched[login]; ok {
+			continue
+		}
+		if err := a.deleteStargazer(ctx, repo, login, 0); err != nil {
+			return nil, fmt.Errorf("delete unstarred %s: %w", login, err)
+		}
+		delta.Removed++
+	}
+	for login := range r.fetched {
+		if _, ok := previous[login]; !ok {
+			delta.Added++
+		}
+	}
+
+	a.deltaMu.Lock()
+	total := a.starDeltas[repo.lower()]
+	total.Added += delta.Added
+	total.Removed += delta.Removed
+	a.starDeltas[repo.lower()] = total
+	a.deltaMu.Unlock()
+	a.logger.Info("reconciled stargazers", "repo", repo, "added", delta.Added, "removed", delta.Removed)
+	return &delta, nil
+}
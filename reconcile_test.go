@@ -0,0 +1,141 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	// newAPI returns an API serving logins one per page, and the deltas
+	// passed to OnSyncComplete by each Sync.
+	newAPI := func(t *testing.T, store kv.Store, logins []string, opts starquery.Options) (*starquery.API, *[]*starquery.StarDelta) {
+		var mu sync.Mutex
+		var deltas []*starquery.StarDelta
+		opts.Client = &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				var body struct {
+					Variables struct {
+						After string `json:"after"`
+					} `json:"variables"`
+				}
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					return nil, err
+				}
+				page := 0
+				if body.Variables.After != "" {
+					_, err := fmt.Sscanf(body.Variables.After, "cursor%d", &page)
+					if err != nil {
+						return nil, err
+					}
+				}
+				edges := "[]"
+				if page < len(logins) {
+					edges = fmt.Sprintf(`[{"node": {"login": %q}, "cursor": "cursor%d"}]`, logins[page], page+1)
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(`{"data": {"repository": {"stargazers": {"edges": ` + edges + `}}, "rateLimit": {"remaining": 50}}}`)),
+				}, nil
+			}),
+		}
+		opts.KV = store
+		opts.Repos = []starquery.Repo{repo}
+		opts.DisableFetchLoop = true
+		opts.Reconcile = true
+		opts.OnSyncComplete = func(_ starquery.Repo, _ int, delta *starquery.StarDelta, _ error) {
+			mu.Lock()
+			defer mu.Unlock()
+			deltas = append(deltas, delta)
+		}
+		api := starquery.New(context.Background(), opts)
+		t.Cleanup(api.Close)
+		return api, &deltas
+	}
+	stored := func(t *testing.T, store kv.Store, login string) bool {
+		_, err := store.Get(context.Background(), repo.Key(login))
+		return err == nil
+	}
+
+	t.Run("Delta", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		require.NoError(t, store.Setex(ctx, 60, [][2]string{{repo.Key("kept"), "true"}, {repo.Key("gone"), "true"}}))
+		api, deltas := newAPI(t, store, []string{"Kept", "new1", "new2"}, starquery.Options{})
+
+		require.NoError(t, api.Sync(ctx))
+		require.Equal(t, []*starquery.StarDelta{{Added: 2, Removed: 1}}, *deltas)
+		require.False(t, stored(t, store, "gone"), "unstarred user is deleted")
+		for _, login := range []string{"kept", "new1", "new2"} {
+			require.True(t, stored(t, store, login), login)
+		}
+
+		// Nothing changed since the last pass.
+		require.NoError(t, api.Sync(ctx))
+		require.Equal(t, []*starquery.StarDelta{{Added: 2, Removed: 1}, {}}, *deltas)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Contains(t, res.Body.String(), "starquery_reconciled_stars_added_total{repo=\"coder/coder\"} 2\n")
+		require.Contains(t, res.Body.String(), "starquery_reconciled_stars_removed_total{repo=\"coder/coder\"} 1\n")
+	})
+
+	t.Run("ResumedPass", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		require.NoError(t, store.Setex(ctx, 60, [][2]string{{repo.Key("gone"), "true"}}))
+		api, deltas := newAPI(t, store, []string{"user1", "user2"}, starquery.Options{MaxStargazersPerSync: 1})
+
+		// Each capped sync only sees part of the stargazers, so none is
+		// compared, even the last, which resumes and reaches the end.
+		for range 3 {
+			require.NoError(t, api.Sync(ctx))
+		}
+		require.Equal(t, []*starquery.StarDelta{nil, nil, nil}, *deltas)
+		require.True(t, stored(t, store, "gone"))
+		require.True(t, stored(t, store, "user2"))
+	})
+
+	t.Run("UserIDs", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api, _ := newAPI(t, store, []string{"kept"}, starquery.Options{StoreUserIDs: true, WebhookSecret: "secret"})
+		event := generateEvent(repo, "gone", "created")
+		id := int64(42)
+		event.Sender.ID = &id
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", event))
+		require.Equal(t, http.StatusOK, res.Code)
+
+		require.NoError(t, api.Sync(ctx))
+		require.False(t, stored(t, store, "gone"))
+		res = httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/user-id/42", nil))
+		require.Equal(t, http.StatusNotFound, res.Code, "the removed stargazer's ID is deleted too")
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		res := httptest.NewRecorder()
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory(), DisableFetchLoop: true})
+		defer api.Close()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.NotContains(t, res.Body.String(), "starquery_reconciled")
+	})
+}
@@ -0,0 +1,115 @@
+package starquery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+)
+
+const (
+	// defaultRedeliverySince is how far back deliveries are checked
+	// for redelivery by default. GitHub keeps them for 3 days.
+	defaultRedeliverySince = 24 * time.Hour
+	// maxRedeliveryPages bounds the pages of deliveries listed in one
+	// redelivery run.
+	maxRedeliveryPages = 50
+)
+
+// RedeliveryResult summarizes a RedeliverFailed run.
+type RedeliveryResult struct {
+	// Checked is the number of deliveries listed.
+	Checked int `json:"checked"`
+	// Redelivered and Failed hold the IDs of the deliveries that were
+	// and couldn't be redelivered.
+	Redelivered []int64 `json:"redelivered"`
+	Failed      []int64 `json:"failed"`
+}
+
+// RedeliverFailed asks GitHub to redeliver the repo webhook's
+// deliveries since the given time that starquery didn't acknowledge
+// with a 2xx status, e.g. during an outage, unless a later attempt of
+// the same event succeeded. Webhooks acknowledged but dropped, e.g.
+// with DropWebhookOnStoreError, aren't redelivered. The GitHub token
+// must be able to manage the repo's webhooks.
+func (a *API) RedeliverFailed(ctx context.Context, repo Repo, hookID int64, since time.Time) (RedeliveryResult, error) {
+	result := RedeliveryResult{Redelivered: []int64{}, Failed: []int64{}}
+	// Deliveries are listed newest first, so an event's latest
+	// attempt is seen before its earlier ones.
+	seen := make(map[string]bool)
+	var failed []int64
+	opts := &github.ListCursorOptions{PerPage: 100}
+pages:
+	for page := 0; page < maxRedeliveryPages; page++ {
+		deliveries, resp, err := a.github.Repositories.ListHookDeliveries(ctx, repo.Owner, repo.Name, hookID, opts)
+		if err != nil {
+			return result, fmt.Errorf("list deliveries: %w", err)
+		}
+		for _, delivery := range deliveries {
+			if delivery.GetDeliveredAt().Time.Before(since) {
+				break pages
+			}
+			result.Checked++
+			guid := delivery.GetGUID()
+			if seen[guid] {
+				continue
+			}
+			seen[guid] = true
+			if code := delivery.GetStatusCode(); code < 200 || code > 299 {
+				failed = append(failed, delivery.GetID())
+			}
+		}
+		if resp.Cursor == "" {
+			break
+		}
+		opts.Cursor = resp.Cursor
+	}
+
+	for _, id := range failed {
+		// GitHub accepts redeliveries with a 202, which go-github
+		// reports as an error.
+		_, _, err := a.github.Repositories.RedeliverHookDelivery(ctx, repo.Owner, repo.Name, hookID, id)
+		var accepted *github.AcceptedError
+		if err != nil && !errors.As(err, &accepted) {
+			a.logger.WarnContext(ctx, "failed to redeliver webhook", "repo", repo, "hook_id", hookID, "delivery", id, "error", err)
+			result.Failed = append(result.Failed, id)
+			continue
+		}
+		result.Redelivered = append(result.Redelivered, id)
+	}
+	a.logger.InfoContext(ctx, "redelivered failed webhooks", "repo", repo, "hook_id", hookID, "checked", result.Checked, "redelivered", len(result.Redelivered), "failed", len(result.Failed))
+	return result, nil
+}
+
+// handleRedeliver redelivers the failed deliveries of the ?hook_id=
+// repo webhook within ?since=, a duration defaulting to a day.
+func (a *API) handleRedeliver(w http.ResponseWriter, r *http.Request) {
+	repo := Repo{Owner: r.PathValue("org"), Name: r.PathValue("repo")}
+	hookID, err := strconv.ParseInt(r.URL.Query().Get("hook_id"), 10, 64)
+	if err != nil || hookID <= 0 {
+		http.Error(w, "hook_id must be the repo webhook's ID", http.StatusBadRequest)
+		return
+	}
+	since := defaultRedeliverySince
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		since, err = time.ParseDuration(raw)
+		if err != nil || since <= 0 {
+			http.Error(w, "since must be a positive duration, e.g. 24h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := a.RedeliverFailed(r.Context(), repo, hookID, a.clock.Now().Add(-since))
+	if err != nil {
+		a.logger.ErrorContext(r.Context(), "failed to redeliver webhooks", "repo", repo, "hook_id", hookID, "error", err)
+		http.Error(w, "Failed to list webhook deliveries", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,81 @@
+package starquery_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedeliver(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	delivery := func(id int, guid string, status int, age time.Duration) string {
+		return fmt.Sprintf(`{"id": %d, "guid": %q, "status_code": %d, "delivered_at": %q}`,
+			id, guid, status, now.Add(-age).Format(time.RFC3339))
+	}
+	var mu sync.Mutex
+	var redelivered []string
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("{}"))}
+				switch {
+				case req.Method == http.MethodGet && req.URL.Path == "/repos/coder/coder/hooks/1/deliveries":
+					// The event g3 failed, then succeeded on redelivery.
+					body := "[" + delivery(5, "g3", 200, time.Minute) + "," + delivery(4, "g2", 500, time.Hour) + "]"
+					if req.URL.Query().Get("cursor") == "next" {
+						body = "[" + delivery(3, "g3", 500, 2*time.Hour) + "," + delivery(2, "g1", 0, 3*time.Hour) + "," + delivery(1, "g0", 500, 48*time.Hour) + "]"
+					} else {
+						res.Header.Set("Link", `<https://api.github.com/repos/coder/coder/hooks/1/deliveries?cursor=next>; rel="next"`)
+					}
+					res.Body = io.NopCloser(strings.NewReader(body))
+				case req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/repos/coder/coder/hooks/1/deliveries/"):
+					mu.Lock()
+					redelivered = append(redelivered, req.URL.Path)
+					mu.Unlock()
+					res.StatusCode = http.StatusAccepted
+					if strings.Contains(req.URL.Path, "/2/") {
+						res.StatusCode = http.StatusInternalServerError
+					}
+				default:
+					res.StatusCode = http.StatusNotFound
+				}
+				return res, nil
+			}),
+		},
+		KV:         kv.NewMemory(),
+		AdminToken: "token",
+	})
+	defer api.Close()
+
+	redeliver := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/coder/coder/redeliver?"+query, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		return res
+	}
+
+	res := redeliver("hook_id=1")
+	require.Equal(t, http.StatusOK, res.Code)
+	require.JSONEq(t, `{"checked": 4, "redelivered": [4], "failed": [2]}`, res.Body.String())
+	require.Equal(t, []string{
+		"/repos/coder/coder/hooks/1/deliveries/4/attempts",
+		"/repos/coder/coder/hooks/1/deliveries/2/attempts",
+	}, redelivered)
+
+	require.Equal(t, http.StatusBadRequest, redeliver("").Code)
+	require.Equal(t, http.StatusBadRequest, redeliver("hook_id=1&since=yesterday").Code)
+	require.Equal(t, http.StatusBadGateway, redeliver("hook_id=2").Code)
+}
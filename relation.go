@@ -0,0 +1,186 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/coder/starquery/kv"
+	"github.com/google/go-github/v52/github"
+)
+
+// relation is a way users are linked to a repo that starquery tracks,
+// e.g. by starring or forking it. Relations share the fetch, store, and
+// query plumbing, and differ in their GraphQL connection, keys, and
+// route, so adding one takes only a new value here.
+type relation struct {
+	// name namespaces the relation's keys and names it in logs.
+	name string
+	// route is the path segment of its query endpoint,
+	// GET /{org}/{repo}/<route>/{username}.
+	route string
+	// connection is the repository's GraphQL connection listing the
+	// relation, and order the input type it's ordered by.
+	connection string
+	order      string
+	// node selects each edge's node, which has either a login or an
+	// owner with one. edge selects extra fields of each edge.
+	node string
+	edge string
+}
+
+var (
+	stars = relation{
+		name:       "stargazers",
+		route:      "user",
+		connection: "stargazers",
+		order:      "StarOrder",
+		node:       "login",
+		edge:       "starredAt",
+	}
+	forks = relation{
+		name:       "forkers",
+		route:      "forker",
+		connection: "forks",
+		order:      "RepositoryOrder",
+		node:       "owner { login } createdAt",
+	}
+)
+
+// relationKey returns the storage key for the repo with the username in
+// the relation. Stars are stored under the configured prefix. Other
+// relations replace the default prefix with their name, or are
+// namespaced by it within a custom prefix.
+func (a *API) relationKey(rel relation, repo Repo, username string) string {
+	switch {
+	case rel == stars:
+		return a.key(repo, username)
+	case a.keyPrefix == DefaultKeyPrefix:
+		return a.userKey(rel.name, repo, username)
+	default:
+		return a.userKey(a.keyPrefix+":"+rel.name, repo, username)
+	}
+}
+
+// relationNode returns the node selection to fetch for the relation,
+// adding the stargazer's user ID if it's stored.
+func (a *API) relationNode(rel relation) string {
+	if rel == stars && a.storeUserIDs {
+		return rel.node + " databaseId"
+	}
+	return rel.node
+}
+
+// tracksRelation reports whether the relation is tracked.
+func (a *API) tracksRelation(rel relation) bool {
+	return slices.Contains(a.relations, rel)
+}
+
+// syncRelation fetches and stores the users in the relation with the
+// repo from GitHub's GraphQL API, returning the number stored. Stars
+// are synced by syncStargazers instead, which honors the fetch strategy.
+func (a *API) syncRelation(ctx context.Context, rel relation, repo Repo) (int, error) {
+	return a.paginateRelation(ctx, rel, repo, func(ctx context.Context, repo Repo, cursor string) ([]Stargazer, time.Time, int, error) {
+		return a.fetchRelationFromGitHub(ctx, rel, repo, cursor, nil)
+	}, time.Time{})
+}
+
+// handleForkEvent stores the owner of a new fork from a fork webhook.
+// Deleting a fork sends no event, so forkers only leave once their TTL
+// passes without a fetch finding them.
+func (a *API) handleForkEvent(w http.ResponseWriter, r *http.Request, event *github.ForkEvent, secretRepos map[string]struct{}) {
+	repo, ok := webhookRepo(w, event.Repo, secretRepos)
+	if !ok {
+		return
+	}
+	username := event.Forkee.GetOwner().GetLogin()
+	if username == "" {
+		http.Error(w, "missing forkee owner", http.StatusBadRequest)
+		return
+	}
+
+	a.logger.InfoContext(r.Context(), "fork created", "repo", repo, "user", username)
+	if err := a.storeRelation(r.Context(), forks, repo, []Stargazer{{Login: username}}); err != nil {
+		a.writeWebhookStoreError(w, r, "failed to update forker data", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ExpiresAtHeader is set with Options.ExpiresAtHeader to the RFC 3339
+// time a found stargazer's entry expires from the store.
+const ExpiresAtHeader = "X-Stargazer-Expires-At"
+
+// setExpiresAt sets ExpiresAtHeader from the key's TTL. The answer
+// doesn't depend on it, so failures are only logged.
+func (a *API) setExpiresAt(w http.ResponseWriter, r *http.Request, key string) {
+	ctx, cancel := context.WithTimeout(r.Context(), a.storeTimeout)
+	defer cancel()
+	ttl, err := a.kv.TTL(ctx, key)
+	if err != nil {
+		a.logger.DebugContext(r.Context(), "failed to get expiry", "key", key, "error", err)
+		return
+	}
+	w.Header().Set(ExpiresAtHeader, a.clock.Now().Add(ttl).UTC().Format(time.RFC3339))
+}
+
+// handleRelation returns a handler that responds 404 if the user isn't
+// in the relation with the repo, e.g. hasn't starred it, and 200 (or 204
+// with OmitQueryBody) if they are. GET routes also match HEAD, which
+// never gets a body. Missing stargazers are looked up on GitHub if
+// read-through is enabled.
+func (a *API) handleRelation(rel relation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo := Repo{Owner: r.PathValue("org"), Name: r.PathValue("repo")}
+		username := r.PathValue("username")
+		if raw := r.URL.Query().Get("fresh"); raw != "" {
+			fresh, err := strconv.ParseBool(raw)
+			if err != nil || (fresh && rel != stars) {
+				http.Error(w, "fresh must be a boolean, and is only supported for stars", http.StatusBadRequest)
+				return
+			}
+			if fresh && a.readOnly.Load() {
+				// Fresh checks write what they find to the store.
+				w.Header().Set(StaleHeader, "true")
+			} else if fresh && a.handleFreshStar(w, r, repo, username) {
+				return
+			}
+		}
+
+		key := a.relationKey(rel, repo, username)
+		if a.negativeCache != nil && a.negativeCache.contains(key, a.clock.Now()) {
+			a.writeNotFound(w, r, repo)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), a.storeTimeout)
+		value, err := a.kv.Get(ctx, key)
+		cancel()
+		if errors.Is(err, kv.ErrNotFound) && rel == stars && a.readThroughLimiter != nil && !a.readOnly.Load() {
+			starred, lookupErr := a.readThrough(r.Context(), repo, username)
+			if lookupErr != nil {
+				a.logger.WarnContext(r.Context(), "read-through lookup failed", "repo", repo, "user", username, "error", lookupErr)
+			}
+			if starred {
+				err = nil
+			}
+		}
+		if errors.Is(err, kv.ErrNotFound) {
+			if a.negativeCache != nil {
+				a.negativeCache.add(key, a.clock.Now())
+			}
+			a.writeNotFound(w, r, repo)
+			return
+		}
+		if err != nil {
+			a.writeStoreError(w, r, err)
+			return
+		}
+		if a.expiresAtHeader {
+			a.setExpiresAt(w, r, key)
+		}
+		a.writeFoundLogin(w, r, value, username)
+	}
+}
@@ -0,0 +1,284 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/google/go-github/v52/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForks(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	forkEvent := func(owner string) github.ForkEvent {
+		return github.ForkEvent{
+			Forkee: &github.Repository{Owner: &github.User{Login: &owner}},
+			Repo:   &github.Repository{Name: &repo.Name, Owner: &github.User{Login: &repo.Owner}},
+		}
+	}
+
+	t.Run("Enabled", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		gh := &fakeGitHub{edges: map[string]string{
+			"forks": `[{"node": {"owner": {"login": "Forker1"}, "createdAt": "2024-01-01T00:00:00Z"}, "cursor": "c1"}]`,
+		}}
+		api := starquery.New(ctx, starquery.Options{
+			Client:        gh.client(),
+			KV:            store,
+			Repos:         []starquery.Repo{repo},
+			WebhookSecret: "secret",
+			TrackForks:    true,
+		})
+		defer api.Close()
+
+		require.Eventually(t, func() bool {
+			return query(api, "/coder/coder/forker/forker1").Code == http.StatusOK
+		}, 5*time.Second, time.Millisecond)
+		_, err := store.Get(ctx, "forkers:coder/coder/forker1")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/forker/forker2").Code)
+
+		// Forkers aren't stargazers.
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/forker1").Code)
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateEventWebhook(t, "secret", "fork", forkEvent("forker2")))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/forker/forker2").Code)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:            kv.NewMemory(),
+			WebhookSecret: "secret",
+		})
+		defer api.Close()
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateEventWebhook(t, "secret", "fork", forkEvent("forker1")))
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		res = httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/forker/forker1", nil))
+		require.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
+
+// TestRelationKeys verifies that stars keep their keys and endpoint
+// alongside other relations.
+func TestRelationKeys(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	for _, tc := range []struct {
+		name        string
+		keyPrefix   string
+		wantStarKey string
+		wantForkKey string
+	}{
+		{name: "DefaultPrefix", wantStarKey: "stargazers:coder/coder/ammar", wantForkKey: "forkers:coder/coder/ammar"},
+		{name: "CustomPrefix", keyPrefix: "custom", wantStarKey: "custom:coder/coder/ammar", wantForkKey: "custom:forkers:coder/coder/ammar"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			store := kv.NewMemory()
+			api := starquery.New(ctx, starquery.Options{
+				KV:            store,
+				KeyPrefix:     tc.keyPrefix,
+				WebhookSecret: "secret",
+				TrackForks:    true,
+			})
+			defer api.Close()
+
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, "ammar", "created")))
+			require.Equal(t, http.StatusOK, res.Code)
+			_, err := store.Get(ctx, tc.wantStarKey)
+			require.NoError(t, err)
+
+			res = httptest.NewRecorder()
+			api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/user/ammar", nil))
+			require.Equal(t, http.StatusOK, res.Code)
+			require.Equal(t, "OK", res.Body.String())
+			res = httptest.NewRecorder()
+			api.ServeHTTP(res, httptest.NewRequest(http.MethodHead, "/coder/coder/user/ammar", nil))
+			require.Equal(t, http.StatusOK, res.Code)
+			require.Empty(t, res.Body.String())
+
+			// A star isn't a fork.
+			res = httptest.NewRecorder()
+			api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/forker/ammar", nil))
+			require.Equal(t, http.StatusNotFound, res.Code)
+
+			owner := "ammar"
+			res = httptest.NewRecorder()
+			api.ServeHTTP(res, generateEventWebhook(t, "secret", "fork", github.ForkEvent{
+				Forkee: &github.Repository{Owner: &github.User{Login: &owner}},
+				Repo:   &github.Repository{Name: &repo.Name, Owner: &github.User{Login: &repo.Owner}},
+			}))
+			require.Equal(t, http.StatusOK, res.Code)
+			_, err = store.Get(ctx, tc.wantForkKey)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNotFoundBody(t *testing.T) {
+	t.Parallel()
+
+	notFound := func(api *starquery.API, method, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/coder/coder/user/kylecarbs", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res := serveHTTP(api, req)
+		require.Equal(t, http.StatusNotFound, res.Code)
+		return res
+	}
+
+	api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory()})
+	defer api.Close()
+
+	res := notFound(api, http.MethodGet, "")
+	require.Equal(t, "Not found", res.Body.String())
+	require.Equal(t, "text/plain; charset=utf-8", res.Header().Get("Content-Type"))
+
+	for _, accept := range []string{"application/json", "text/html, Application/JSON;q=0.9"} {
+		res = notFound(api, http.MethodGet, accept)
+		require.JSONEq(t, `{"starred": false}`, res.Body.String(), accept)
+		require.Equal(t, "application/json", res.Header().Get("Content-Type"), accept)
+	}
+
+	require.Empty(t, notFound(api, http.MethodHead, "application/json").Body.String())
+
+	omit := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory(), OmitQueryBody: true})
+	defer omit.Close()
+	require.Empty(t, notFound(omit, http.MethodGet, "application/json").Body.String())
+}
+
+func TestStoreLoginCasing(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	lookup := func(api *starquery.API, method, path, accept string) (int, string) {
+		req := httptest.NewRequest(method, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res := serveHTTP(api, req)
+		return res.Code, res.Body.String()
+	}
+
+	t.Run("Webhook", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{
+			KV:               store,
+			WebhookSecret:    "secret",
+			StoreLoginCasing: true,
+		})
+		defer api.Close()
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, "KyleCarbs", "created")))
+		require.Equal(t, http.StatusOK, res.Code)
+
+		code, body := lookup(api, http.MethodGet, "/coder/coder/user/kylecarbs", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "KyleCarbs", body)
+		code, body = lookup(api, http.MethodGet, "/coder/coder/user/KYLECARBS", "application/json")
+		require.Equal(t, http.StatusOK, code)
+		require.JSONEq(t, `{"starred": true, "login": "KyleCarbs"}`, body)
+		code, body = lookup(api, http.MethodHead, "/coder/coder/user/kylecarbs", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Empty(t, body)
+
+		// Stargazers stored without their casing answer with the query's.
+		require.NoError(t, store.Setex(ctx, 3600, [][2]string{{repo.Key("ammar"), "true"}}))
+		code, body = lookup(api, http.MethodGet, "/coder/coder/user/Ammar", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "Ammar", body)
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		t.Parallel()
+		synced := make(chan struct{}, 1)
+		gh := &fakeGitHub{edges: map[string]string{
+			"stargazers": `[{"node": {"login": "Octo-Cat"}, "cursor": "c1"}]`,
+		}}
+		api := starquery.New(context.Background(), starquery.Options{
+			Client:           gh.client(),
+			KV:               kv.NewMemory(),
+			Repos:            []starquery.Repo{repo},
+			StoreLoginCasing: true,
+			OnSyncComplete: func(starquery.Repo, int, *starquery.StarDelta, error) {
+				select {
+				case synced <- struct{}{}:
+				default:
+				}
+			},
+		})
+		defer api.Close()
+
+		select {
+		case <-synced:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the sync didn't complete")
+		}
+		code, body := lookup(api, http.MethodGet, "/coder/coder/user/octo-cat", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "Octo-Cat", body)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory(), WebhookSecret: "secret"})
+		defer api.Close()
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, "KyleCarbs", "created")))
+		require.Equal(t, http.StatusOK, res.Code)
+		code, body := lookup(api, http.MethodGet, "/coder/coder/user/kylecarbs", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "OK", body)
+	})
+}
+
+func TestExpiresAtHeader(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+	store := kv.NewMemoryWithClock(clk)
+	require.NoError(t, store.Setex(ctx, 3600, [][2]string{{repo.Key("kylecarbs"), "true"}}))
+
+	api := starquery.New(ctx, starquery.Options{KV: store, Clock: clk, ExpiresAtHeader: true})
+	defer api.Close()
+	res := query(api, "/coder/coder/user/kylecarbs")
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "2024-01-01T01:00:00Z", res.Header().Get(starquery.ExpiresAtHeader))
+	clk.Advance(time.Minute)
+	require.Equal(t, "2024-01-01T01:00:00Z", query(api, "/coder/coder/user/kylecarbs").Header().Get(starquery.ExpiresAtHeader))
+	res = query(api, "/coder/coder/user/nobody")
+	require.Equal(t, http.StatusNotFound, res.Code)
+	require.Empty(t, res.Header().Get(starquery.ExpiresAtHeader))
+
+	disabled := starquery.New(ctx, starquery.Options{KV: store, Clock: clk})
+	defer disabled.Close()
+	res = query(disabled, "/coder/coder/user/kylecarbs")
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Empty(t, res.Header().Get(starquery.ExpiresAtHeader))
+}
@@ -0,0 +1,260 @@
+package starquery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// repoStatus describes a tracked repo in the repos endpoint.
+type repoStatus struct {
+	Repo string `json:"repo"`
+	// LastSync is when any instance sharing the store last fully synced
+	// the repo, or null if that isn't known.
+	LastSync *time.Time `json:"last_sync"`
+	// Stars is the star count recorded by the last sync today or
+	// yesterday (UTC), or null if there wasn't one.
+	Stars *int `json:"stars"`
+}
+
+// handleRepos responds with the tracked repos, including ones added
+// at runtime.
+func (a *API) handleRepos(w http.ResponseWriter, r *http.Request) {
+	repos := a.Repos()
+	now := a.clock.Now()
+	keys := make([]string, 0, 3*len(repos))
+	for _, repo := range repos {
+		keys = append(keys, a.syncedKey(repo), a.starCountKey(repo, now), a.starCountKey(repo, now.AddDate(0, 0, -1)))
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), a.storeTimeout)
+	defer cancel()
+	values, err := a.kv.MGet(ctx, keys)
+	if err != nil {
+		a.writeStoreError(w, r, err)
+		return
+	}
+
+	resp := make([]repoStatus, len(repos))
+	for i, repo := range repos {
+		synced, today, yesterday := values[3*i], values[3*i+1], values[3*i+2]
+		resp[i].Repo = repo.String()
+		// Repos synced before sync times were stored hold a placeholder.
+		if t, err := time.Parse(time.RFC3339, synced); err == nil {
+			resp[i].LastSync = &t
+		}
+		for _, value := range []string{today, yesterday} {
+			if count, err := strconv.Atoi(value); err == nil {
+				resp[i].Stars = &count
+				break
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// maxConcurrentBackfills bounds how many newly added repos are
+// backfilled at once, so adding many repos can't exhaust the rate limit.
+const maxConcurrentBackfills = 2
+
+// Repos returns the repos currently tracked.
+func (a *API) Repos() []Repo {
+	a.reposMu.RLock()
+	defer a.reposMu.RUnlock()
+	return slices.Clone(a.repos)
+}
+
+// tracks reports whether the repo is tracked, ignoring case.
+func (a *API) tracks(repo Repo) bool {
+	return slices.ContainsFunc(a.Repos(), func(tracked Repo) bool {
+		return tracked.lower() == repo.lower()
+	})
+}
+
+// AddRepo starts tracking repo and backfills its stargazers in the
+// background instead of waiting for the next fetch cycle. It's a no-op
+// if the repo is already tracked.
+func (a *API) AddRepo(repo Repo) {
+	a.reposMu.Lock()
+	if slices.ContainsFunc(a.repos, func(tracked Repo) bool { return tracked.lower() == repo.lower() }) {
+		a.reposMu.Unlock()
+		return
+	}
+	a.repos = append(a.repos, repo)
+	a.reposMu.Unlock()
+	a.backfill(repo)
+}
+
+// backfillUnsynced backfills the tracked repos that have never been
+// fully synced, e.g. on first startup with an empty store, rather than
+// syncing them one after another. It returns them, so the fetch loop's
+// first pass doesn't sync them again.
+func (a *API) backfillUnsynced(ctx context.Context) map[Repo]bool {
+	if !a.isLeader() || a.readOnly.Load() {
+		return nil
+	}
+	unsynced, err := a.unsyncedRepos(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			a.logger.Warn("failed to find repos to backfill", "error", err)
+		}
+		return nil
+	}
+	backfilled := make(map[Repo]bool, len(unsynced))
+	for _, repo := range unsynced {
+		a.backfill(repo)
+		backfilled[repo] = true
+	}
+	return backfilled
+}
+
+// backfill queues the repo's stargazers to be synced in the background
+// instead of waiting for the fetch loop. At most maxConcurrentBackfills
+// workers sync the queue, so queuing many repos doesn't start a
+// goroutine for each.
+func (a *API) backfill(repo Repo) {
+	a.reposMu.Lock()
+	defer a.reposMu.Unlock()
+	a.backfillQueue = append(a.backfillQueue, repo)
+	if a.backfillers >= maxConcurrentBackfills || !a.addTask() {
+		return
+	}
+	a.backfillers++
+	go a.backfillWorker()
+}
+
+// backfillWorker syncs queued repos until the queue is empty or the API
+// closes.
+func (a *API) backfillWorker() {
+	defer a.wg.Done()
+	for {
+		a.reposMu.Lock()
+		if len(a.backfillQueue) == 0 || a.ctx.Err() != nil {
+			a.backfillers--
+			a.reposMu.Unlock()
+			return
+		}
+		repo := a.backfillQueue[0]
+		a.backfillQueue = a.backfillQueue[1:]
+		a.reposMu.Unlock()
+
+		if !a.acquireBackground(a.ctx) {
+			continue
+		}
+		a.logger.Info("backfilling stargazers", "repo", repo)
+		start := a.clock.Now()
+		if stored, ok, _ := a.syncRepo(a.ctx, repo); ok {
+			a.logger.Info("backfill complete", "repo", repo, "stored", stored, "duration", a.clock.Now().Sub(start))
+		}
+		a.releaseBackground()
+	}
+}
+
+// syncRepo fetches and stores all stargazers for the repo, reporting
+// whether it succeeded and the error logged if it failed. It reports
+// false without fetching if this instance isn't the leader or is
+// read-only, or the repo is already being synced, e.g. by a backfill
+// and the fetch loop.
+func (a *API) syncRepo(ctx context.Context, repo Repo) (int, bool, error) {
+	if !a.isLeader() || a.readOnly.Load() {
+		return 0, false, nil
+	}
+	a.reposMu.Lock()
+	if _, ok := a.syncing[repo]; ok {
+		a.reposMu.Unlock()
+		return 0, false, nil
+	}
+	a.syncing[repo] = struct{}{}
+	a.reposMu.Unlock()
+	defer func() {
+		a.reposMu.Lock()
+		delete(a.syncing, repo)
+		a.reposMu.Unlock()
+	}()
+
+	stored, delta, err := a.syncStargazers(ctx, repo)
+	if ctx.Err() != nil {
+		return stored, false, ctx.Err()
+	}
+	a.setRepoError(repo, err)
+	var scopeErr *ScopeError
+	switch {
+	case errors.As(err, &scopeErr):
+		a.logger.Error("github token cannot read repo, grant it the public_repo scope (and read:org for organization repos)",
+			"repo", repo, "type", scopeErr.Type, "message", scopeErr.Message, "granted_scopes", scopeErr.Scopes)
+	case errors.Is(err, errRateBudgetExceeded):
+		a.logger.Info("repo used its share of the rate limit, resuming in the next window", "repo", repo, "stored", stored)
+		a.syncRepoExtras(ctx, repo)
+	case errors.Is(err, errSyncCapped):
+		a.logger.Info("repo sync capped, resuming next sync", "repo", repo, "stored", stored)
+		a.syncRepoExtras(ctx, repo)
+	case errors.Is(err, errReadOnly):
+		a.logger.Info("read-only, pausing repo sync until writes resume", "repo", repo, "stored", stored)
+	case errors.Is(err, ErrRepoNotFound):
+		a.logger.Error("repo not found, check its owner and name and that the github token can see it", "repo", repo)
+	case err != nil:
+		a.logger.Error("failed to fetch stargazers", "repo", repo, "error", err)
+	default:
+		if err := a.markSynced(ctx, repo); err != nil {
+			a.logger.Warn("failed to mark repo synced", "repo", repo, "error", err)
+		}
+		a.syncRepoExtras(ctx, repo)
+	}
+	if a.onSyncComplete != nil {
+		a.onSyncComplete(repo, stored, delta, err)
+	}
+	return stored, err == nil, err
+}
+
+// syncRepoExtras records the repo's star count and syncs its tracked
+// relations other than stars. A star sync that stopped early to resume
+// later still runs them, or a repo that's always capped would never
+// record its history or reach milestones, and its forks would expire.
+func (a *API) syncRepoExtras(ctx context.Context, repo Repo) {
+	if err := a.recordStarCount(ctx, repo); err != nil {
+		a.logger.Warn("failed to record star count", "repo", repo, "error", err)
+	}
+	for _, rel := range a.relations {
+		if rel == stars {
+			continue
+		}
+		_, err := a.syncRelation(ctx, rel, repo)
+		switch {
+		case err == nil || ctx.Err() != nil:
+		case errors.Is(err, errRateBudgetExceeded):
+			a.logger.Info("repo used its share of the rate limit, resuming "+rel.name+" in the next window", "repo", repo)
+		case errors.Is(err, errReadOnly):
+			a.logger.Info("read-only, pausing "+rel.name+" sync until writes resume", "repo", repo)
+			return
+		default:
+			a.logger.Error("failed to fetch "+rel.name, "repo", repo, "error", err)
+		}
+	}
+}
+
+// Sync syncs the stargazers of every tracked repo once, one after
+// another, e.g. from cron with DisableFetchLoop. It returns the errors
+// of the repos that failed to sync. Repos that stopped early to resume
+// in a later sync, e.g. with MaxStargazersPerSync or because the API
+// turned read-only, don't fail.
+func (a *API) Sync(ctx context.Context) error {
+	if a.locker != nil {
+		return errors.New("one-shot syncs don't support leader election")
+	}
+	var errs []error
+	for _, repo := range a.Repos() {
+		_, _, err := a.syncRepo(ctx, repo)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && !errors.Is(err, errSyncCapped) && !errors.Is(err, errRateBudgetExceeded) && !errors.Is(err, errReadOnly) {
+			errs = append(errs, fmt.Errorf("sync %s: %w", repo, err))
+		}
+	}
+	return errors.Join(errs...)
+}
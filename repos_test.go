@@ -0,0 +1,284 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddRepo(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := kv.NewMemory()
+	gh := &fakeGitHub{edges: map[string]string{
+		"stargazers": `[{"node": {"login": "user1"}, "cursor": "cursor1"}]`,
+	}}
+	api := starquery.New(ctx, starquery.Options{
+		Client: gh.client(),
+		KV:     store,
+	})
+	defer api.Close()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	api.AddRepo(repo)
+	api.AddRepo(repo)
+	require.Equal(t, []starquery.Repo{repo}, api.Repos())
+
+	// The backfill runs without waiting for the next fetch cycle.
+	require.Eventually(t, func() bool {
+		v, err := store.Get(ctx, repo.Key("user1"))
+		return err == nil && v != ""
+	}, time.Second, time.Millisecond)
+
+	// Repos added while or after closing aren't backfilled.
+	api.Close()
+	api.AddRepo(starquery.Repo{Owner: "coder", Name: "closed"})
+	_, err := store.Get(ctx, starquery.Repo{Owner: "coder", Name: "closed"}.Key("user1"))
+	require.ErrorIs(t, err, kv.ErrNotFound)
+}
+
+// TestAddRepoGoroutines isn't parallel, so other tests' goroutines
+// don't skew the count.
+func TestAddRepoGoroutines(t *testing.T) {
+	release := make(chan struct{})
+	gh := &fakeGitHub{release: release}
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: gh.client(),
+		KV:     kv.NewMemory(),
+	})
+	defer api.Close()
+	defer close(release)
+
+	before := runtime.NumGoroutine()
+	for i := range 100 {
+		api.AddRepo(starquery.Repo{Owner: "coder", Name: fmt.Sprint("repo", i)})
+	}
+	// Two backfills fetch while the rest wait in a queue, not in
+	// goroutines of their own.
+	require.Eventually(t, func() bool {
+		return gh.fetches.Load() == 2
+	}, 5*time.Second, time.Millisecond)
+	require.Less(t, runtime.NumGoroutine()-before, 20)
+}
+
+func TestBackfillUnsynced(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var fetched []string
+	client := &http.Client{
+		Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+			var body struct {
+				Query     string `json:"query"`
+				Variables struct {
+					Name string `json:"name"`
+				} `json:"variables"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			if strings.Contains(body.Query, "stargazers(") {
+				mu.Lock()
+				fetched = append(fetched, body.Variables.Name)
+				mu.Unlock()
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"data": {"repository": {"stargazers": {"edges": []}}, "rateLimit": {"remaining": 50}}}`)),
+			}, nil
+		}),
+	}
+	ctx := context.Background()
+	store := kv.NewMemory()
+	synced := starquery.Repo{Owner: "coder", Name: "synced"}
+	first := starquery.New(ctx, starquery.Options{
+		Client:           client,
+		KV:               store,
+		Repos:            []starquery.Repo{synced},
+		DisableFetchLoop: true,
+	})
+	require.NoError(t, first.Sync(ctx))
+	first.Close()
+	mu.Lock()
+	fetched = nil
+	mu.Unlock()
+
+	// Staggered passes sync never-synced repos in turn, so backfills
+	// are only used without staggering.
+	var completed atomic.Int32
+	api := starquery.New(ctx, starquery.Options{
+		Client:              client,
+		KV:                  store,
+		Clock:               clock.NewFake(time.Now()),
+		DisableFetchStagger: true,
+		Repos: []starquery.Repo{
+			{Owner: "coder", Name: "a"},
+			synced,
+			{Owner: "coder", Name: "b"},
+		},
+		OnSyncComplete: func(starquery.Repo, int, *starquery.StarDelta, error) {
+			completed.Add(1)
+		},
+	})
+	defer api.Close()
+
+	// The repos that were never synced are backfilled, and the first
+	// pass only syncs the one that was, so none is synced twice.
+	require.Eventually(t, func() bool {
+		return completed.Load() == 3
+	}, 5*time.Second, time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	slices.Sort(fetched)
+	require.Equal(t, []string{"a", "b", "synced"}, fetched)
+}
+
+func TestReposEndpoint(t *testing.T) {
+	t.Parallel()
+
+	gh := &fakeGitHub{starCount: 42}
+	api := starquery.New(context.Background(), starquery.Options{
+		Client:     gh.client(),
+		KV:         kv.NewMemory(),
+		Repos:      []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		AdminToken: "token",
+	})
+	defer api.Close()
+
+	type status struct {
+		Repo     string     `json:"repo"`
+		LastSync *time.Time `json:"last_sync"`
+		Stars    *int       `json:"stars"`
+	}
+	list := func(token string) (int, []status) {
+		req := httptest.NewRequest(http.MethodGet, "/repos", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		var repos []status
+		if res.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&repos))
+		}
+		return res.Code, repos
+	}
+
+	code, _ := list("")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	require.Eventually(t, func() bool {
+		_, repos := list("token")
+		return len(repos) == 1 && repos[0].LastSync != nil && repos[0].Stars != nil
+	}, 5*time.Second, time.Millisecond)
+	_, repos := list("token")
+	require.Equal(t, "coder/coder", repos[0].Repo)
+	require.Equal(t, 42, *repos[0].Stars)
+
+	// Repos added at runtime are listed.
+	api.AddRepo(starquery.Repo{Owner: "coder", Name: "other"})
+	_, repos = list("token")
+	require.Len(t, repos, 2)
+	require.Equal(t, "coder/other", repos[1].Repo)
+}
+
+func TestSync(t *testing.T) {
+	t.Parallel()
+
+	// newAPI returns an API that serves a page of stargazers for every
+	// repo but coder/failing, and never syncs in the background.
+	newAPI := func(t *testing.T, store kv.Store, repos ...starquery.Repo) (*starquery.API, *atomic.Int32) {
+		var requests atomic.Int32
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					requests.Add(1)
+					data, err := io.ReadAll(req.Body)
+					if err != nil {
+						return nil, err
+					}
+					if bytes.Contains(data, []byte(`"name":"failing"`)) {
+						return &http.Response{
+							StatusCode: http.StatusBadRequest,
+							Body:       io.NopCloser(bytes.NewBufferString("bad request")),
+						}, nil
+					}
+					body := `{"data": {"repository": {"stargazers": {"edges": []}}, "rateLimit": {"remaining": 50}}}`
+					if !bytes.Contains(data, []byte(`"after":"cursor1"`)) {
+						body = `{
+							"data": {
+								"repository": {
+									"stargazers": {
+										"edges": [{"node": {"login": "user1"}, "cursor": "cursor1"}]
+									}
+								},
+								"rateLimit": {"remaining": 50}
+							}
+						}`
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewBufferString(body)),
+					}, nil
+				}),
+			},
+			KV:               store,
+			Repos:            repos,
+			DisableFetchLoop: true,
+		})
+		t.Cleanup(api.Close)
+		return api, &requests
+	}
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api, requests := newAPI(t, store, repo)
+
+		// Nothing is fetched until Sync is called.
+		time.Sleep(10 * time.Millisecond)
+		require.Zero(t, requests.Load())
+
+		require.NoError(t, api.Sync(ctx))
+		value, err := store.Get(ctx, repo.Key("user1"))
+		require.NoError(t, err)
+		require.NotEmpty(t, value)
+	})
+
+	t.Run("Fails", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api, _ := newAPI(t, store, repo, starquery.Repo{Owner: "coder", Name: "failing"})
+
+		err := api.Sync(ctx)
+		require.ErrorContains(t, err, "sync coder/failing")
+		require.NotContains(t, err.Error(), "sync coder/coder")
+
+		// The other repos still sync.
+		value, err := store.Get(ctx, repo.Key("user1"))
+		require.NoError(t, err)
+		require.NotEmpty(t, value)
+	})
+}
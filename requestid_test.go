@@ -0,0 +1,61 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Echo", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory()})
+		defer api.Close()
+		req := httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil)
+		req.Header.Set(starquery.RequestIDHeader, "my-request-id")
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, "my-request-id", res.Header().Get(starquery.RequestIDHeader))
+	})
+
+	t.Run("Generate", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory()})
+		defer api.Close()
+		for _, id := range []string{"", "has spaces", string(make([]byte, 1024))} {
+			req := httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil)
+			req.Header.Set(starquery.RequestIDHeader, id)
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, req)
+			got := res.Header().Get(starquery.RequestIDHeader)
+			require.Len(t, got, 36)
+			require.NotEqual(t, id, got)
+		}
+	})
+
+	t.Run("Logged", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:     errorStore{Store: kv.NewMemory(), err: errors.New("boom")},
+			Logger: slog.New(slog.NewTextHandler(&buf, nil)),
+		})
+		defer api.Close()
+		req := httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil)
+		req.Header.Set(starquery.RequestIDHeader, "my-request-id")
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusInternalServerError, res.Code, "unexpected status code")
+		require.Contains(t, buf.String(), "request_id=my-request-id")
+	})
+}
@@ -0,0 +1,119 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+)
+
+// FetchStrategy selects the GitHub API used to fetch stargazers.
+type FetchStrategy string
+
+const (
+	// FetchGraphQL fetches stargazers using the GraphQL API.
+	FetchGraphQL FetchStrategy = "graphql"
+	// FetchREST fetches stargazers using the REST API. GitHub limits
+	// REST pagination, so very large repos may not be fully fetched.
+	FetchREST FetchStrategy = "rest"
+	// FetchAuto uses the GraphQL API and falls back to the REST API if
+	// GraphQL is unavailable or the token can't use it.
+	FetchAuto FetchStrategy = "auto"
+)
+
+// statusError is returned when GitHub responds with an unexpected status.
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected status: %d", int(e))
+}
+
+// graphQLUnavailable reports whether err means the GraphQL API can't
+// currently be used, so the REST API might succeed instead.
+func graphQLUnavailable(err error) bool {
+	var scopeErr *ScopeError
+	if errors.As(err, &scopeErr) {
+		return true
+	}
+	var status statusError
+	return errors.As(err, &status) && (status >= http.StatusInternalServerError || status == http.StatusForbidden)
+}
+
+// fetchStargazersFromREST fetches a page of stargazers for the given repo
+// from GitHub's REST API. The cursor is the page number.
+func (a *API) fetchStargazersFromREST(ctx context.Context, repo Repo, cursor string) ([]Stargazer, time.Time, int, error) {
+	page := 1
+	if cursor != "" {
+		var err error
+		page, err = strconv.Atoi(cursor)
+		if err != nil {
+			return nil, time.Time{}, 0, fmt.Errorf("parse cursor: %w", err)
+		}
+	}
+
+	// go-github requests starred_at timestamps with the star media type.
+	var stargazers []*github.Stargazer
+	var resp *github.Response
+	var err error
+	for {
+		stargazers, resp, err = a.github.Activity.ListStargazers(ctx, repo.Owner, repo.Name, &github.ListOptions{
+			Page:    page,
+			PerPage: 100,
+		})
+		resetAt, limited := restRateLimitReset(err, a.clock.Now())
+		if !limited {
+			break
+		}
+		// Wait and retry the page, like GraphQL pages that exhaust
+		// the rate limit, rather than failing the whole pass.
+		a.logger.Info("rate limit reached", "repo", repo, "reset", resetAt)
+		if err := a.rateLimitWaiter.Wait(ctx, resetAt, 0); err != nil {
+			return nil, time.Time{}, 0, err
+		}
+	}
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, time.Time{}, 0, ErrRepoNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, 0, fmt.Errorf("list stargazers: %w", err)
+	}
+
+	var resetTime time.Time
+	if resp.Rate.Remaining == 0 {
+		resetTime = resp.Rate.Reset.Time
+	}
+
+	result := make([]Stargazer, 0, len(stargazers))
+	for _, stargazer := range stargazers {
+		result = append(result, Stargazer{
+			Login:     stargazer.GetUser().GetLogin(),
+			ID:        stargazer.GetUser().GetID(),
+			Cursor:    strconv.Itoa(page + 1),
+			StarredAt: stargazer.GetStarredAt().Time,
+		})
+	}
+	return result, resetTime, resp.Rate.Remaining, nil
+}
+
+// restRateLimitReset reports whether err is a REST rate limit error, and
+// when requests may resume. Secondary rate limits without a Retry-After
+// wait a minute, as GitHub suggests.
+func restRateLimitReset(err error, now time.Time) (time.Time, bool) {
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		return rateErr.Rate.Reset.Time, true
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		retryAfter := time.Minute
+		if abuseErr.RetryAfter != nil {
+			retryAfter = *abuseErr.RetryAfter
+		}
+		return now.Add(retryAfter), true
+	}
+	return time.Time{}, false
+}
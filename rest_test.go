@@ -0,0 +1,211 @@
+package starquery_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchStrategy(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		strategy         starquery.FetchStrategy
+		graphQLStatus    int
+		graphQLBody      string
+		wantGraphQL      bool
+		wantREST         bool
+		wantStoredLogins []string
+	}{
+		{strategy: starquery.FetchGraphQL, graphQLStatus: http.StatusOK, wantGraphQL: true, wantStoredLogins: []string{"graphql-user"}},
+		{strategy: starquery.FetchREST, wantREST: true, wantStoredLogins: []string{"rest-user"}},
+		{strategy: starquery.FetchAuto, graphQLStatus: http.StatusOK, wantGraphQL: true, wantStoredLogins: []string{"graphql-user"}},
+		{strategy: starquery.FetchAuto, graphQLStatus: http.StatusBadGateway, wantGraphQL: true, wantREST: true, wantStoredLogins: []string{"rest-user"}},
+		// A query that failed outright has null data, leaving only the
+		// errors.
+		{
+			strategy:         starquery.FetchAuto,
+			graphQLStatus:    http.StatusOK,
+			graphQLBody:      `{"data": null, "errors": [{"type": "FORBIDDEN", "message": "Resource not accessible by integration"}]}`,
+			wantGraphQL:      true,
+			wantREST:         true,
+			wantStoredLogins: []string{"rest-user"},
+		},
+	} {
+		t.Run(string(tc.strategy), func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			store := kv.NewMemory()
+			var graphQLCalls, restCalls atomic.Int64
+			api := starquery.New(ctx, starquery.Options{
+				Client: &http.Client{
+					Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+						res := &http.Response{
+							StatusCode: http.StatusOK,
+							Header:     http.Header{"Content-Type": []string{"application/json"}},
+							Request:    req,
+						}
+						switch req.URL.Path {
+						case "/graphql":
+							graphQLCalls.Add(1)
+							res.StatusCode = tc.graphQLStatus
+							body := `{"data": {"repository": {"stargazers": {"edges": []}}, "rateLimit": {"remaining": 50}}}`
+							data, _ := io.ReadAll(req.Body)
+							if !bytes.Contains(data, []byte(`"after":"cursor1"`)) {
+								body = `{
+									"data": {
+										"repository": {
+											"stargazers": {
+												"edges": [{"node": {"login": "graphql-user"}, "cursor": "cursor1"}]
+											}
+										},
+										"rateLimit": {"remaining": 50}
+									}
+								}`
+							}
+							if tc.graphQLBody != "" {
+								body = tc.graphQLBody
+							}
+							res.Body = io.NopCloser(bytes.NewBufferString(body))
+						case "/repos/coder/coder/stargazers":
+							restCalls.Add(1)
+							body := `[]`
+							if req.URL.Query().Get("page") == "1" {
+								body = `[{"starred_at": "2023-04-01T00:00:00Z", "user": {"login": "rest-user"}}]`
+							}
+							res.Body = io.NopCloser(bytes.NewBufferString(body))
+						default:
+							res.StatusCode = http.StatusNotFound
+							res.Body = io.NopCloser(bytes.NewBufferString(`{}`))
+						}
+						return res, nil
+					}),
+				},
+				FetchStrategy: tc.strategy,
+				KV:            store,
+				Repos:         []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			})
+			defer api.Close()
+
+			repo := starquery.Repo{Owner: "coder", Name: "coder"}
+			require.Eventually(t, func() bool {
+				for _, login := range tc.wantStoredLogins {
+					if _, err := store.Get(ctx, repo.Key(login)); err != nil {
+						return false
+					}
+				}
+				return true
+			}, time.Second, time.Millisecond)
+			require.Equal(t, tc.wantGraphQL, graphQLCalls.Load() > 0, "graphql calls")
+			require.Equal(t, tc.wantREST, restCalls.Load() > 0, "rest calls")
+		})
+	}
+}
+
+func TestRESTRateLimit(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// The reset has passed in real time, so go-github doesn't refuse the
+	// retry itself.
+	reset := time.Now().Add(-time.Minute).Truncate(time.Second)
+	secondary := `{"message": "You have exceeded a secondary rate limit", "documentation_url": "https://docs.github.com/rest/overview/resources-in-the-rest-api#secondary-rate-limits"}`
+	for _, tc := range []struct {
+		name      string
+		header    http.Header
+		body      string
+		wantReset time.Time
+	}{
+		{
+			name: "Primary",
+			header: http.Header{
+				"X-Ratelimit-Limit":     []string{"60"},
+				"X-Ratelimit-Remaining": []string{"0"},
+				"X-Ratelimit-Reset":     []string{strconv.FormatInt(reset.Unix(), 10)},
+			},
+			body:      `{"message": "API rate limit exceeded"}`,
+			wantReset: reset,
+		},
+		{
+			name:      "Secondary",
+			header:    http.Header{"Retry-After": []string{"0"}},
+			body:      secondary,
+			wantReset: start,
+		},
+		{
+			name:      "SecondaryWithoutRetryAfter",
+			header:    http.Header{},
+			body:      secondary,
+			wantReset: start.Add(time.Minute),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			store := kv.NewMemory()
+			var requests atomic.Int64
+			var mu sync.Mutex
+			var waits []time.Time
+			api := starquery.New(ctx, starquery.Options{
+				Client: &http.Client{
+					Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+						res := &http.Response{
+							StatusCode: http.StatusOK,
+							Header:     http.Header{"Content-Type": []string{"application/json"}},
+							Request:    req,
+						}
+						body := `[]`
+						switch {
+						case req.URL.Path != "/repos/coder/coder/stargazers":
+							res.StatusCode = http.StatusNotFound
+							body = `{}`
+						case requests.Add(1) == 1:
+							res.StatusCode = http.StatusForbidden
+							for key, values := range tc.header {
+								res.Header[key] = values
+							}
+							body = tc.body
+						case req.URL.Query().Get("page") == "1":
+							body = `[{"starred_at": "2023-04-01T00:00:00Z", "user": {"login": "rest-user"}}]`
+						}
+						res.Body = io.NopCloser(bytes.NewBufferString(body))
+						return res, nil
+					}),
+				},
+				FetchStrategy: starquery.FetchREST,
+				KV:            store,
+				Repos:         []starquery.Repo{{Owner: "coder", Name: "coder"}},
+				Clock:         clock.NewFake(start),
+				RateLimitWaiter: waiterFunc(func(_ context.Context, resetAt time.Time, _ int) error {
+					mu.Lock()
+					defer mu.Unlock()
+					waits = append(waits, resetAt)
+					return nil
+				}),
+			})
+			defer api.Close()
+
+			// The page is retried after the wait rather than failing the sync.
+			repo := starquery.Repo{Owner: "coder", Name: "coder"}
+			require.Eventually(t, func() bool {
+				_, err := store.Get(ctx, repo.Key("rest-user"))
+				return err == nil
+			}, 5*time.Second, time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			require.Len(t, waits, 1)
+			require.True(t, tc.wantReset.Equal(waits[0]), "waited until %s, want %s", waits[0], tc.wantReset)
+		})
+	}
+}
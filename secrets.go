@@ -0,0 +1,154 @@
+package starquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+)
+
+// WebhookSecretsPollInterval is how often WebhookSecretsFile is checked
+// for changes.
+const WebhookSecretsPollInterval = 10 * time.Second
+
+// validateWebhook validates the request's signature against the webhook
+// secret and then each of the per-repo webhook secrets, returning the
+// payload. If only per-repo secrets match, it also returns the repos
+// ("owner/repo" in lower case) the payload may be for. Otherwise the
+// returned set is nil and the payload may be for any repo.
+func (a *API) validateWebhook(r *http.Request) ([]byte, map[string]struct{}, error) {
+	if a.webhookEchoMode {
+		payload, err := io.ReadAll(r.Body)
+		return payload, nil, err
+	}
+	secrets := *a.webhookSecrets.Load()
+	if len(secrets) == 0 {
+		payload, err := github.ValidatePayload(r, []byte(a.webhookSecret))
+		return payload, nil, err
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	validate := func(secret string) ([]byte, error) {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		return github.ValidatePayload(r, []byte(secret))
+	}
+	if a.webhookSecret != "" {
+		payload, err := validate(a.webhookSecret)
+		if err == nil {
+			return payload, nil, nil
+		}
+	}
+	var payload []byte
+	repos := make(map[string]struct{})
+	for repo, secret := range secrets {
+		p, verr := validate(secret)
+		if verr != nil {
+			err = verr
+			continue
+		}
+		payload = p
+		repos[repo] = struct{}{}
+	}
+	if len(repos) == 0 {
+		return nil, nil, err
+	}
+	return payload, repos, nil
+}
+
+// webhookSecretsByRepo returns the per-repo webhook secrets keyed by
+// lower case "owner/repo", since GitHub names are case-insensitive.
+func webhookSecretsByRepo(secrets map[string]string) map[string]string {
+	byRepo := make(map[string]string, len(secrets))
+	for repo, secret := range secrets {
+		byRepo[strings.ToLower(repo)] = secret
+	}
+	return byRepo
+}
+
+// webhookSecretErrors returns what's wrong with the per-repo webhook
+// secrets, if anything.
+func webhookSecretErrors(secrets map[string]string) []error {
+	var errs []error
+	for repo, secret := range secrets {
+		owner, name, ok := strings.Cut(repo, "/")
+		if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			errs = append(errs, fmt.Errorf("invalid webhook secret repo %q, must be \"owner/repo\"", repo))
+		}
+		if secret == "" {
+			errs = append(errs, fmt.Errorf("empty webhook secret for %q", repo))
+		}
+	}
+	return errs
+}
+
+// LoadWebhookSecrets reads per-repo webhook secrets from a JSON file
+// mapping "owner/repo" to its secret, e.g. {"coder/coder": "secret"}.
+func LoadWebhookSecrets(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read webhook secrets: %w", err)
+	}
+	return parseWebhookSecrets(path, data)
+}
+
+func parseWebhookSecrets(path string, data []byte) (map[string]string, error) {
+	var secrets map[string]string
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("decode webhook secrets %s: must be a JSON object of \"owner/repo\" to secret: %w", path, err)
+	}
+	if errs := webhookSecretErrors(secrets); len(errs) > 0 {
+		return nil, fmt.Errorf("webhook secrets %s: %w", path, errors.Join(errs...))
+	}
+	return secrets, nil
+}
+
+// reloadWebhookSecrets replaces the per-repo webhook secrets with the
+// file's if it changed from last, returning its contents. If it can't
+// be loaded, the current secrets are kept.
+func (a *API) reloadWebhookSecrets(path string, last []byte) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		a.logger.Error("failed to read webhook secrets, keeping the current ones", "path", path, "error", err)
+		return last
+	}
+	if last != nil && bytes.Equal(data, last) {
+		return last
+	}
+	secrets, err := parseWebhookSecrets(path, data)
+	if err != nil {
+		// It's only logged once per change.
+		a.logger.Error("invalid webhook secrets, keeping the current ones", "path", path, "error", err)
+		return data
+	}
+	byRepo := webhookSecretsByRepo(secrets)
+	a.webhookSecrets.Store(&byRepo)
+	a.logger.Info("loaded webhook secrets", "path", path, "repos", len(byRepo))
+	return data
+}
+
+// watchWebhookSecrets reloads the webhook secrets file whenever it
+// changes until ctx is done.
+func (a *API) watchWebhookSecrets(ctx context.Context, path string, last []byte) {
+	defer a.wg.Done()
+	ticker := a.clock.NewTicker(WebhookSecretsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			last = a.reloadWebhookSecrets(path, last)
+		}
+	}
+}
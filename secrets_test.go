@@ -0,0 +1,96 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookSecretsFile(t *testing.T) {
+	t.Parallel()
+
+	writeFile := func(t *testing.T, path, content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	t.Run("Load", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		for _, tc := range []struct {
+			name    string
+			content string
+			want    map[string]string
+			wantErr string
+		}{
+			{name: "Valid", content: `{"coder/coder": "secret"}`, want: map[string]string{"coder/coder": "secret"}},
+			{name: "NotJSON", content: "coder/coder: secret", wantErr: "must be a JSON object"},
+			{name: "InvalidRepo", content: `{"coder": "secret"}`, wantErr: `invalid webhook secret repo "coder"`},
+			{name: "EmptySecret", content: `{"coder/coder": ""}`, wantErr: `empty webhook secret for "coder/coder"`},
+		} {
+			path := filepath.Join(dir, tc.name+".json")
+			writeFile(t, path, tc.content)
+			secrets, err := starquery.LoadWebhookSecrets(path)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr, tc.name)
+				continue
+			}
+			require.NoError(t, err, tc.name)
+			require.Equal(t, tc.want, secrets, tc.name)
+		}
+
+		_, err := starquery.LoadWebhookSecrets(filepath.Join(dir, "missing.json"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		_, err = starquery.NewWithError(context.Background(), starquery.Options{
+			KV:                 kv.NewMemory(),
+			WebhookSecretsFile: filepath.Join(dir, "InvalidRepo.json"),
+		})
+		require.ErrorContains(t, err, `invalid webhook secret repo "coder"`)
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "secrets.json")
+		writeFile(t, path, `{"coder/coder": "old"}`)
+		clk := clock.NewFake(time.Now())
+		api, err := starquery.NewWithError(context.Background(), starquery.Options{
+			KV:                 kv.NewMemory(),
+			Clock:              clk,
+			WebhookSecretsFile: path,
+		})
+		require.NoError(t, err)
+		defer api.Close()
+
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		webhook := func(secret string) int {
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, generateWebhook(t, secret, generateEvent(repo, "kylecarbs", "created")))
+			return res.Code
+		}
+		require.Equal(t, http.StatusOK, webhook("old"))
+
+		writeFile(t, path, `{"coder/coder": "new"}`)
+		require.Eventually(t, func() bool {
+			clk.Advance(starquery.WebhookSecretsPollInterval)
+			return webhook("new") == http.StatusOK
+		}, 5*time.Second, time.Millisecond)
+		require.Equal(t, http.StatusBadRequest, webhook("old"))
+
+		// Invalid changes keep the current secrets.
+		writeFile(t, path, `{"coder/coder": ""}`)
+		for range 3 {
+			clk.Advance(starquery.WebhookSecretsPollInterval)
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.Equal(t, http.StatusOK, webhook("new"))
+	})
+}
@@ -0,0 +1,50 @@
+package starquery
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// restoreSnapshot loads the store from the snapshot file. A missing
+// file isn't an error, e.g. on first start.
+func (a *API) restoreSnapshot() error {
+	f, err := os.Open(a.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open snapshot: %w", err)
+	}
+	defer f.Close()
+	return a.snapshotter.Restore(f)
+}
+
+// saveSnapshot writes the store to the snapshot file. It writes to a
+// temporary file first, so a failed write never replaces the last
+// good snapshot.
+func (a *API) saveSnapshot() error {
+	f, err := os.CreateTemp(filepath.Dir(a.snapshotPath), filepath.Base(a.snapshotPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if err := a.snapshotter.Snapshot(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	// Syncing before the rename keeps a crash from leaving an empty
+	// or partial file at the snapshot path.
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := os.Rename(f.Name(), a.snapshotPath); err != nil {
+		return fmt.Errorf("replace snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+
+	// The first start has no snapshot to restore.
+	api := starquery.New(ctx, starquery.Options{KV: kv.NewMemory(), SnapshotPath: path})
+	_, err := api.Import(ctx, repo, strings.NewReader("kylecarbs"))
+	require.NoError(t, err)
+	api.Close()
+
+	api = starquery.New(ctx, starquery.Options{KV: kv.NewMemory(), SnapshotPath: path})
+	defer api.Close()
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	matches, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	require.Empty(t, matches, "temporary files are cleaned up")
+}
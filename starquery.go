@@ -5,121 +5,1086 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/coder/starquery/clock"
 	"github.com/coder/starquery/kv"
 	"github.com/google/go-github/v52/github"
 )
 
 // API handles GitHub stargazer queries.
 type API struct {
-	client        *http.Client
-	kv            kv.Store
-	logger        *slog.Logger
-	repos         []Repo
-	mux           *http.ServeMux
-	webhookSecret string
-	wg            sync.WaitGroup
-	closeFunc     context.CancelFunc
+	client         *http.Client
+	github         *github.Client
+	fetchStrategy  FetchStrategy
+	fetchInterval  time.Duration
+	ttl            time.Duration
+	kv             kv.Store
+	logger         *slog.Logger
+	ctx            context.Context
+	reposMu        sync.RWMutex
+	repos          []Repo
+	syncing        map[Repo]struct{}
+	backfillQueue  []Repo
+	backfillers    int
+	mux            *http.ServeMux
+	handler        http.Handler
+	queryMux       *http.ServeMux
+	webhookMux     *http.ServeMux
+	webhookSecret  string
+	requireSHA256  bool
+	maxWebhookBody int64
+	eventSink      EventSink
+	eventSinkAsync bool
+	auditLogger    AuditLogger
+	onStarChange   func(ctx context.Context, change StarChange) error
+	omitQueryBody  bool
+	keyPrefix      string
+	wg             sync.WaitGroup
+	closeMu        sync.Mutex
+	closeFunc      context.CancelFunc
+
+	locker         kv.Locker
+	leaderLockTTL  time.Duration
+	instanceID     string
+	leader         atomic.Bool
+	leaderAcquired chan struct{}
+
+	healthMu   sync.Mutex
+	repoErrors map[string]string
+
+	historyRetention   time.Duration
+	ignoreSenders      map[string]struct{}
+	adminToken         string
+	userAgent          string
+	webhookSecrets     atomic.Pointer[map[string]string]
+	orgMembers         bool
+	memberTTL          time.Duration
+	background         chan struct{}
+	warmingUnavailable bool
+	// requireFirstSync fails health checks until every tracked repo
+	// has been fully synced, after which warm latches.
+	requireFirstSync   bool
+	warm               atomic.Bool
+	readThroughLimiter *readThroughLimiter
+	freshLimiter       *readThroughLimiter
+	negativeCache      *negativeCache
+	clock              clock.Clock
+	ignoreUnstars      bool
+	build              BuildInfo
+	snapshotter        kv.Snapshotter
+	snapshotPath       string
+	incrementalSync    bool
+	fullSyncInterval   time.Duration
+	budget             *rateBudget
+	syncCap            int
+	rateLimitWaiter    RateLimitWaiter
+	graphQLTimeout     time.Duration
+	storeTimeout       time.Duration
+	// relations lists the tracked relations, starting with stars.
+	relations   []relation
+	milestones  []int
+	onMilestone func(ctx context.Context, repo Repo, milestone int) error
+	// signedAdminRequests accepts admin requests signed with the
+	// webhook secret.
+	signedAdminRequests bool
+	webhookEchoMode     bool
+	// dropWebhookOnStoreError acknowledges webhooks whose store update
+	// failed, counting them in webhooksDropped.
+	dropWebhookOnStoreError bool
+	webhooksDropped         atomic.Uint64
+	// maxSyncAge forces a full sync of repos without a full pass
+	// within it.
+	maxSyncAge   time.Duration
+	storeUserIDs bool
+	// staggerFetches spreads each pass's repo fetches across the fetch
+	// interval.
+	staggerFetches bool
+	// readOnly pauses all writes to the store, see SetReadOnly.
+	readOnly               atomic.Bool
+	readOnlyAcceptWebhooks bool
+	// pausedCursors holds the cursor each full sync stopped at when the
+	// API turned read-only, see errReadOnly.
+	pausedMu      sync.Mutex
+	pausedCursors map[pausedSync]string
+	// Webhooks for other targets or installations are rejected.
+	webhookTargetType     string
+	webhookTargetID       int64
+	webhookInstallationID int64
+	ttlJitter             float64
+	onSyncComplete        func(repo Repo, stored int, delta *StarDelta, err error)
+	storeLoginCasing      bool
+	hashKeys              bool
+	expiresAtHeader       bool
+	reconcile             bool
+	// starDeltas totals the deltas found by Reconcile for each repo.
+	deltaMu    sync.Mutex
+	starDeltas map[Repo]StarDelta
+	// querySlots holds a value for each query in flight, up to
+	// MaxConcurrentQueries. Queries are unlimited if it's nil.
+	querySlots      chan struct{}
+	queriesRejected atomic.Uint64
+	// webhookMaxAge rejects older webhooks, see WebhookMaxAge.
+	webhookMaxAge    time.Duration
+	webhookClockSkew time.Duration
 }
 
 // Options holds configuration for the API.
 type Options struct {
-	Client        *http.Client
+	Client *http.Client
+	// FetchStrategy selects the GitHub API used to fetch stargazers.
+	// Defaults to FetchGraphQL.
+	FetchStrategy FetchStrategy
+	// FetchInterval is how often all stargazers are refetched.
+	// Defaults to DefaultFetchInterval.
+	FetchInterval time.Duration
+	// WebhookTargetType and WebhookTargetID, if set, reject webhooks
+	// with 403 unless their X-GitHub-Hook-Installation-Target-Type and
+	// -ID headers match, e.g. "integration" and a GitHub App's ID.
+	WebhookTargetType string
+	WebhookTargetID   int64
+	// WebhookInstallationID, if set, rejects webhooks with 403 unless
+	// their payload is for this GitHub App installation, so one endpoint
+	// serving several installations can't apply another's events.
+	WebhookInstallationID int64
+	// WebhookMaxAge, if set, rejects webhooks older than it with a 400,
+	// e.g. replays. Stars are as old as their starred_at, and other
+	// events as the first time their delivery ID was seen. Disabled
+	// by default.
+	WebhookMaxAge time.Duration
+	// WebhookClockSkew is added to WebhookMaxAge, allowing for GitHub's
+	// clock, which sets starred_at, being ahead of ours.
+	WebhookClockSkew time.Duration
+	// ReadOnly starts the API in read-only maintenance mode, e.g.
+	// while the store is being maintained. See SetReadOnly. It can be
+	// toggled with PUT /read-only when the admin endpoints are enabled.
+	ReadOnly bool
+	// ReadOnlyAcceptWebhooks acknowledges webhooks received while
+	// read-only with 202, dropping them, instead of responding 503 so
+	// they can be redelivered afterwards.
+	ReadOnlyAcceptWebhooks bool
+	// DisableFetchStagger fetches every repo back to back each
+	// FetchInterval. By default, the fetches of N repos are spread
+	// evenly across the interval, the i-th starting interval*i/N after
+	// the first, so GitHub requests aren't bunched together, even on a
+	// cold start. With it set, repos that were never synced are instead
+	// backfilled concurrently on start.
+	DisableFetchStagger bool
+	// DisableFetchLoop doesn't sync in the background, e.g. to sync
+	// once with Sync and exit.
+	DisableFetchLoop bool
+	// IncrementalSync fetches stargazers newest first and stops at the
+	// stars stored by the previous sync, instead of walking every page.
+	// A full sync still runs every FullSyncInterval to refresh the TTL
+	// of older stargazers. It requires GraphQL, so it can't be combined
+	// with FetchREST.
+	IncrementalSync bool
+	// FullSyncInterval must be shorter than TTL.
+	// Defaults to DefaultFullSyncInterval.
+	FullSyncInterval time.Duration
+	// MaxSyncAge forces a full reconciliation sync, instead of an
+	// incremental one, of a repo that hasn't completed a full pass over
+	// its stargazers within it. It bounds how far the store can drift
+	// from missed webhooks regardless of FullSyncInterval. Without
+	// IncrementalSync every sync is a full one. It must be longer than
+	// FetchInterval. Zero disables it.
+	MaxSyncAge time.Duration
+	// FairRateLimit gives each repo an equal share of the GraphQL rate
+	// limit remaining when each hourly window starts. A repo that uses
+	// its share stops fetching until the next window, then resumes
+	// where it stopped, so one large repo can't starve the rest.
+	FairRateLimit bool
+	// MaxStargazersPerSync stops a repo's sync once it has stored this
+	// many stargazers. The next sync resumes where it stopped, which
+	// spreads the backfill of a huge repo over several fetch intervals.
+	// Zero means unlimited.
+	MaxStargazersPerSync int
+	// TrackForks also fetches the owners of each repo's forks and
+	// stores new ones from fork webhooks, so they can be queried with
+	// GET /{org}/{repo}/forker/{username}. Fetching them costs one more
+	// GraphQL request per 100 forks each sync. It requires GraphQL.
+	TrackForks bool
+	// StoreUserIDs also fetches each stargazer's numeric GitHub user ID
+	// and stores their star under it, so it can be queried with
+	// GET /{org}/{repo}/user-id/{id} even after they rename. IDs are
+	// stored only when known, i.e. by syncs and star webhooks.
+	StoreUserIDs bool
+	// Milestones are star counts, e.g. 1000 and 5000, at which
+	// OnMilestone is called. The star count is checked after each sync,
+	// and the highest milestone reached is kept in the store so it's
+	// notified once, even across restarts. When a repo is first
+	// checked, milestones it already passed aren't notified.
+	Milestones []int
+	// OnMilestone is called with the highest milestone a repo reached
+	// since it was last checked. If it returns an error, the milestone
+	// is notified again after the next sync.
+	OnMilestone func(ctx context.Context, repo Repo, milestone int) error
+	// TTLJitter varies the TTL of each stored stargazer by up to this
+	// fraction either way, e.g. 0.05 for ±5%, so stargazers stored
+	// together don't all expire at once. Defaults to DefaultTTLJitter,
+	// and a negative value disables it.
+	TTLJitter float64
+	// TTL is how long stored stargazers live without being refetched.
+	// It must be longer than FetchInterval. Defaults to DefaultTTL.
+	TTL           time.Duration
 	KV            kv.Store
 	Logger        *slog.Logger
 	Repos         []Repo
 	WebhookSecret string
+	// WebhookSecrets holds per-repo webhook secrets keyed by
+	// "owner/repo", for deliveries proxied from repos configured with
+	// different secrets. A delivery signed with a per-repo secret is
+	// only accepted for that repo, while WebhookSecret is accepted for
+	// every repo.
+	WebhookSecrets map[string]string
+	// WebhookSecretsFile is a JSON file of per-repo webhook secrets
+	// keyed by "owner/repo", used instead of WebhookSecrets. It's
+	// checked for changes every WebhookSecretsPollInterval, so secrets
+	// can be rotated without a restart.
+	WebhookSecretsFile string
+	// RequireSHA256 rejects webhooks that are only signed with the
+	// legacy SHA1 X-Hub-Signature header.
+	RequireSHA256 bool
+	// MaxWebhookBodySize limits the size of webhook request bodies.
+	// Defaults to DefaultMaxWebhookBodySize.
+	MaxWebhookBodySize int64
+	// EventSink, if set, receives the JSON payload of every webhook
+	// with a valid signature, e.g. to record deliveries for replay.
+	// Form-encoded deliveries are unwrapped from their payload field.
+	EventSink EventSink
+	// EventSinkAsync calls EventSink in the background instead of
+	// before the webhook is processed. Webhooks wait for a free slot if
+	// MaxBackgroundTasks are already running.
+	EventSinkAsync bool
+	// MaxBackgroundTasks limits the background tasks, e.g. async event
+	// sinks and repo backfills, running at once.
+	// Defaults to DefaultMaxBackgroundTasks.
+	MaxBackgroundTasks int
+	// AuditLogger, if set, records every star and unstar received
+	// via webhook.
+	AuditLogger AuditLogger
+	// OnStarChange, if set, is called for every star and unstar
+	// received via webhook, after the store has been updated. It's
+	// called synchronously before responding to GitHub, and may be
+	// called concurrently for concurrent deliveries. Errors are logged
+	// and don't fail the webhook.
+	OnStarChange func(ctx context.Context, change StarChange) error
+	// OnSyncComplete, if set, is called after each sync of a repo's
+	// stargazers with the number stored, the delta found by Reconcile,
+	// which is nil for other syncs, and the error that stopped it, if
+	// any, but not for syncs cut short by Close. It's called on the
+	// goroutine that synced the repo, i.e. the fetch loop or an added
+	// repo's backfill, which waits for it to return.
+	OnSyncComplete func(repo Repo, stored int, delta *StarDelta, err error)
+	// WarmingUnavailable responds to starred queries for tracked repos
+	// that haven't been fully fetched yet, e.g. right after starting
+	// with an empty store, with a 503 instead of a misleading 404.
+	WarmingUnavailable bool
+	// RequireFirstSync responds to health checks with a 503 until
+	// every tracked repo has been fully synced, by this instance or
+	// another sharing the store, e.g. so a readiness probe keeps
+	// queries from a cold instance. It stays ready afterwards.
+	RequireFirstSync bool
+	// AllowUnavailableStore lets NewWithError succeed when the store
+	// can't be pinged, e.g. to start while Redis is down. Queries and
+	// webhooks fail with a 503 until the store is reachable.
+	AllowUnavailableStore bool
+	// ReadThrough checks GitHub when a query for a tracked repo misses
+	// the store, e.g. for a star made since the last fetch, and stores
+	// the result. Misses wait up to a few seconds on GitHub, and only
+	// the user's 100 most recent stars are checked.
+	ReadThrough bool
+	// ReadThroughLimit limits read-through GitHub lookups per minute,
+	// so queries can't exhaust the rate limit. Queries past the limit
+	// aren't looked up. Defaults to DefaultReadThroughLimit.
+	ReadThroughLimit int
+	// FreshCheckLimit limits the GitHub checks made for star queries
+	// with ?fresh=true per minute. Fresh queries past the limit get a
+	// 429. Defaults to DefaultFreshCheckLimit.
+	FreshCheckLimit int
+	// NegativeCacheTTL, if set, remembers stargazer queries that missed
+	// the store for that long, so repeated queries for users who haven't
+	// starred don't reach it. Stars stored by this instance, e.g. from
+	// webhooks, are forgotten right away, but other instances sharing
+	// the store keep answering 404 for them until the TTL passes.
+	NegativeCacheTTL time.Duration
+	// NegativeCacheSize bounds the misses remembered, evicting the
+	// oldest. Defaults to DefaultNegativeCacheSize.
+	NegativeCacheSize int
+	// OmitQueryBody responds to starred queries with an empty 204
+	// instead of a 200 with an "OK" body, and to others with an empty
+	// 404.
+	OmitQueryBody bool
+	// StoreLoginCasing stores each stargazer's login as GitHub cases
+	// it, e.g. "KyleCarbs", so queries in any case answer with it: as
+	// the body instead of "OK", or as {"starred": true, "login": ...}
+	// to clients that accept JSON. Stargazers stored before it was set,
+	// or found by read-through and fresh checks, which only learn the
+	// queried casing, are answered with that until they're refetched.
+	StoreLoginCasing bool
+	// HashKeys stores stargazers under fixed-length keys, the key prefix
+	// and the SHA-256 of "owner/repo/username" (see Repo.HashedKey),
+	// rather than the names themselves. Logins can't be recovered from
+	// hashed keys, so stargazers can't be exported or listed.
+	HashKeys bool
+	// ExpiresAtHeader sets ExpiresAtHeader on found answers from the
+	// store to when the entry expires, e.g. to debug a user suddenly
+	// not being found. It costs a TTL lookup per found query.
+	ExpiresAtHeader bool
+	// KeyPrefix namespaces every key written to the store. Set it to
+	// share one store across several starquery deployments.
+	// Defaults to DefaultKeyPrefix.
+	KeyPrefix string
+	// LeaderElection runs the fetch loop on only one of the instances
+	// sharing the store, using a lock renewed every third of
+	// LeaderLockTTL. The store must implement kv.Locker.
+	LeaderElection bool
+	// LeaderLockTTL defaults to DefaultLeaderLockTTL.
+	LeaderLockTTL time.Duration
+	// IgnoreUnstars acknowledges unstar webhooks without applying
+	// them, so users stay stored after unstarring. They're still
+	// removed once their TTL passes, as fetches no longer refresh them.
+	IgnoreUnstars bool
+	// Reconcile compares every complete full pass of a repo's
+	// stargazers with those stored before it, deleting the ones it
+	// didn't fetch, e.g. unstars whose webhook was missed, instead of
+	// leaving them until their TTL passes. The delta is reported to
+	// OnSyncComplete and /metrics. The store must implement kv.Scanner,
+	// and keys can't be hashed.
+	Reconcile bool
+	// IgnoreSenders lists logins, e.g. bots, whose star webhooks are
+	// acknowledged but otherwise ignored. Matching is case-insensitive.
+	IgnoreSenders []string
+	// AdminToken enables the admin endpoints, e.g. import and export,
+	// for requests with an "Authorization: Bearer <AdminToken>" header.
+	AdminToken string
+	// SignedAdminRequests enables the admin endpoints for requests
+	// signed with WebhookSecret, with or without an AdminToken. See
+	// SignAdminRequest for the scheme.
+	SignedAdminRequests bool
+	// WebhookEchoMode is for local development only: webhooks are
+	// accepted without checking their signature, so synthetic events
+	// can be sent with curl, and every event is logged. It's ignored
+	// unless the DevEnvVar environment variable is "development".
+	WebhookEchoMode bool
+	// DropWebhookOnStoreError acknowledges webhooks whose store update
+	// fails with a 200, dropping the change until the next sync picks
+	// it up, instead of a 500 that marks the delivery failed so it can
+	// be redelivered. Either way the failure is logged.
+	DropWebhookOnStoreError bool
+	// OrgMembers tracks org members from organization and membership
+	// webhooks, served by GET /orgs/{org}/members/{username}. Members
+	// are only learned from webhooks, never fetched.
+	OrgMembers bool
+	// MemberTTL is how long members live without another webhook.
+	// Defaults to DefaultMemberTTL.
+	MemberTTL time.Duration
+	// UserAgent is sent with every request to GitHub.
+	// Defaults to "starquery/<Build.Version>".
+	UserAgent string
+	// HistoryRetention is how long the daily star counts served by the
+	// history endpoint are kept. Defaults to DefaultHistoryRetention.
+	HistoryRetention time.Duration
+	// Build is served by GET /version. Unset fields are read from the
+	// build info embedded by the Go toolchain.
+	Build BuildInfo
+	// SnapshotPath, if set, is where the store is saved on Close and
+	// restored from on start, so a memory store survives restarts. The
+	// store must implement kv.Snapshotter. Stars received while stopped
+	// are picked up by the first fetch.
+	SnapshotPath string
+	// Clock times the fetch loop, leader renewals, and the default
+	// RateLimitWaiter. Tests set a clock.Fake to skip ahead instead of sleeping.
+	// Defaults to clock.Real, and is also used by the default KV.
+	Clock clock.Clock
+	// RateLimitWaiter pauses syncs that reach GitHub's rate limit, e.g.
+	// to add a safety margin or cap the wait. Defaults to ResetWaiter.
+	RateLimitWaiter RateLimitWaiter
+	// GraphQLTimeout bounds each GraphQL request, including reading
+	// the response. Defaults to DefaultGraphQLTimeout.
+	GraphQLTimeout time.Duration
+	// StoreTimeout bounds the store lookups of queries, which then
+	// get a 503. Syncs and webhooks aren't bounded by it.
+	// Defaults to DefaultStoreTimeout.
+	StoreTimeout time.Duration
+	// MaxConcurrentQueries bounds the queries served at once. Queries
+	// beyond it get a 503 with Retry-After instead of waiting on the
+	// store. Unlimited if zero.
+	MaxConcurrentQueries int
 }
 
-// New creates a new API handler that fetches stargazers for the given repos.
-func New(ctx context.Context, opts Options) *API {
+// EventSink receives the raw payload of a webhook delivery.
+type EventSink func(ctx context.Context, deliveryID, eventType string, payload []byte)
+
+// DefaultMaxWebhookBodySize is the default limit for webhook request
+// bodies. Star events are only a few kilobytes.
+const DefaultMaxWebhookBodySize = 5 << 20
+
+// webhookPayloadLogPrefix is how much of a webhook payload that fails
+// to parse is logged.
+const webhookPayloadLogPrefix = 1024
+
+// retryAfterSeconds is sent in the Retry-After header when the store
+// is temporarily unavailable.
+const retryAfterSeconds = "5"
+
+const (
+	// DefaultFetchInterval is how often stargazers are refetched by default.
+	DefaultFetchInterval = 15 * time.Minute
+	// DefaultTTL is how long stargazers are stored by default.
+	DefaultTTL = 24 * time.Hour
+	// DefaultTTLJitter is the fraction stored stargazers' TTLs vary by
+	// default.
+	DefaultTTLJitter = 0.05
+	// DefaultGraphQLTimeout is how long a GraphQL request may take by
+	// default. Pages of the largest repos can take several seconds.
+	DefaultGraphQLTimeout = 30 * time.Second
+	// DefaultStoreTimeout is how long the store lookups of a query may
+	// take by default.
+	DefaultStoreTimeout = 2 * time.Second
+)
+
+// DefaultKeyPrefix is the store key namespace used when none is configured.
+const DefaultKeyPrefix = "stargazers"
+
+// setDefaults fills in unset options.
+func (opts *Options) setDefaults() {
 	if opts.Client == nil {
 		opts.Client = http.DefaultClient
 	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.RateLimitWaiter == nil {
+		opts.RateLimitWaiter = ResetWaiter(opts.Clock)
+	}
+	if opts.GraphQLTimeout == 0 {
+		opts.GraphQLTimeout = DefaultGraphQLTimeout
+	}
+	if opts.StoreTimeout == 0 {
+		opts.StoreTimeout = DefaultStoreTimeout
+	}
+	opts.Build = opts.Build.withDefaults()
 	if opts.KV == nil {
-		opts.KV = kv.NewMemory()
+		opts.KV = kv.NewMemoryWithClock(opts.Clock)
 	}
 	if opts.Logger == nil {
 		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
 	}
+	if opts.MaxWebhookBodySize == 0 {
+		opts.MaxWebhookBodySize = DefaultMaxWebhookBodySize
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = DefaultKeyPrefix
+	}
+	if opts.LeaderLockTTL == 0 {
+		opts.LeaderLockTTL = DefaultLeaderLockTTL
+	}
+	if opts.FetchInterval == 0 {
+		opts.FetchInterval = DefaultFetchInterval
+	}
+	if opts.TTL == 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.FullSyncInterval == 0 {
+		opts.FullSyncInterval = DefaultFullSyncInterval
+	}
+	if opts.MaxBackgroundTasks <= 0 {
+		opts.MaxBackgroundTasks = DefaultMaxBackgroundTasks
+	}
+	if opts.ReadThroughLimit == 0 {
+		opts.ReadThroughLimit = DefaultReadThroughLimit
+	}
+	if opts.NegativeCacheSize == 0 {
+		opts.NegativeCacheSize = DefaultNegativeCacheSize
+	}
+	if opts.TTLJitter == 0 {
+		opts.TTLJitter = DefaultTTLJitter
+	}
+	if opts.FreshCheckLimit == 0 {
+		opts.FreshCheckLimit = DefaultFreshCheckLimit
+	}
+	if opts.MemberTTL == 0 {
+		opts.MemberTTL = DefaultMemberTTL
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = "starquery/" + opts.Build.Version
+	}
+	if opts.HistoryRetention == 0 {
+		opts.HistoryRetention = DefaultHistoryRetention
+	}
+}
+
+// validate reports every problem with the options.
+func (opts *Options) validate(ctx context.Context) error {
+	var errs []error
+	seen := make(map[Repo]bool)
+	for _, repo := range opts.Repos {
+		if repo.Owner == "" || repo.Name == "" || strings.Contains(repo.Owner, "/") || strings.Contains(repo.Name, "/") {
+			errs = append(errs, fmt.Errorf("invalid repo %q", repo))
+			continue
+		}
+		if seen[repo.lower()] {
+			errs = append(errs, fmt.Errorf("duplicate repo %q", repo))
+		}
+		seen[repo.lower()] = true
+	}
+	errs = append(errs, webhookSecretErrors(opts.WebhookSecrets)...)
+	if opts.WebhookSecretsFile != "" {
+		if len(opts.WebhookSecrets) > 0 {
+			errs = append(errs, errors.New("webhook secrets can't be set both directly and from a file"))
+		}
+		if _, err := LoadWebhookSecrets(opts.WebhookSecretsFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if opts.TTL <= opts.FetchInterval {
+		errs = append(errs, fmt.Errorf("ttl %s must be longer than the fetch interval %s, or stargazers expire before they're refreshed", opts.TTL, opts.FetchInterval))
+	}
+	if opts.TTLJitter >= 1 {
+		errs = append(errs, fmt.Errorf("ttl jitter %g must be less than 1", opts.TTLJitter))
+	} else if jittered := time.Duration(float64(opts.TTL) * (1 - max(opts.TTLJitter, 0))); jittered <= opts.FetchInterval {
+		errs = append(errs, fmt.Errorf("ttl %s less the jitter must be longer than the fetch interval %s", opts.TTL, opts.FetchInterval))
+	}
+	if opts.HistoryRetention < 24*time.Hour {
+		errs = append(errs, fmt.Errorf("history retention %s must be at least a day", opts.HistoryRetention))
+	}
+	switch opts.FetchStrategy {
+	case "", FetchGraphQL, FetchREST, FetchAuto:
+	default:
+		errs = append(errs, fmt.Errorf("unknown fetch strategy %q", opts.FetchStrategy))
+	}
+	if opts.LeaderElection {
+		if _, ok := opts.KV.(kv.Locker); !ok {
+			errs = append(errs, errors.New("leader election requires a store that supports locks"))
+		}
+	}
+	if opts.IncrementalSync {
+		if opts.FetchStrategy == FetchREST {
+			errs = append(errs, errors.New("incremental sync requires graphql, it can't be used with the rest fetch strategy"))
+		}
+		if opts.FullSyncInterval >= opts.TTL {
+			errs = append(errs, fmt.Errorf("full sync interval %s must be shorter than the ttl %s, or older stargazers expire before they're refreshed", opts.FullSyncInterval, opts.TTL))
+		}
+	}
+	if opts.WebhookMaxAge < 0 || opts.WebhookClockSkew < 0 {
+		errs = append(errs, fmt.Errorf("webhook max age %s and clock skew %s can't be negative", opts.WebhookMaxAge, opts.WebhookClockSkew))
+	}
+	if opts.MaxSyncAge < 0 || (opts.MaxSyncAge > 0 && opts.MaxSyncAge <= opts.FetchInterval) {
+		errs = append(errs, fmt.Errorf("max sync age %s must be longer than the fetch interval %s", opts.MaxSyncAge, opts.FetchInterval))
+	}
+	if opts.WebhookEchoMode && !devEnv() {
+		errs = append(errs, fmt.Errorf("webhook echo mode requires %s=development", DevEnvVar))
+	}
+	if opts.SignedAdminRequests && opts.WebhookSecret == "" {
+		errs = append(errs, errors.New("signed admin requests require a webhook secret"))
+	}
+	if opts.Reconcile {
+		if _, ok := opts.KV.(kv.Scanner); !ok {
+			errs = append(errs, errors.New("reconcile requires a store that supports listing keys"))
+		}
+		if opts.HashKeys {
+			errs = append(errs, errors.New("reconcile can't list stargazers stored under hashed keys"))
+		}
+		if opts.IgnoreUnstars {
+			errs = append(errs, errors.New("reconcile would delete the unstarred users ignore unstars keeps"))
+		}
+	}
+	if opts.TrackForks && opts.FetchStrategy == FetchREST {
+		errs = append(errs, errors.New("tracking forks requires graphql, it can't be used with the rest fetch strategy"))
+	}
+	for _, milestone := range opts.Milestones {
+		if milestone <= 0 {
+			errs = append(errs, fmt.Errorf("milestone %d must be positive", milestone))
+		}
+	}
+	if len(opts.Milestones) > 0 && opts.OnMilestone == nil {
+		errs = append(errs, errors.New("milestones require an OnMilestone callback"))
+	}
+	if opts.SnapshotPath != "" {
+		if _, ok := opts.KV.(kv.Snapshotter); !ok {
+			errs = append(errs, errors.New("snapshots require a store that supports them"))
+		}
+	}
+	if !opts.AllowUnavailableStore {
+		if err := opts.KV.Ping(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("ping store: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewWithError validates the options, including that the store is
+// reachable unless AllowUnavailableStore is set, and creates a new API
+// handler that fetches stargazers for the given repos.
+func NewWithError(ctx context.Context, opts Options) (*API, error) {
+	opts.setDefaults()
+	if err := opts.validate(ctx); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	return New(ctx, opts), nil
+}
+
+// New creates a new API handler that fetches stargazers for the given repos.
+// Use NewWithError to detect misconfiguration.
+func New(ctx context.Context, opts Options) *API {
+	opts.setDefaults()
 
 	ctx, cancel := context.WithCancel(ctx)
 
 	api := &API{
-		client:        opts.Client,
-		kv:            opts.KV,
-		logger:        opts.Logger,
-		repos:         opts.Repos,
-		mux:           http.NewServeMux(),
-		webhookSecret: opts.WebhookSecret,
-		closeFunc:     cancel,
+		client:         opts.Client,
+		github:         github.NewClient(opts.Client),
+		fetchStrategy:  opts.FetchStrategy,
+		fetchInterval:  opts.FetchInterval,
+		ttl:            opts.TTL,
+		kv:             opts.KV,
+		logger:         slog.New(requestIDHandler{opts.Logger.Handler()}),
+		ctx:            ctx,
+		repos:          slices.Clone(opts.Repos),
+		syncing:        make(map[Repo]struct{}),
+		pausedCursors:  make(map[pausedSync]string),
+		mux:            http.NewServeMux(),
+		queryMux:       http.NewServeMux(),
+		webhookMux:     http.NewServeMux(),
+		webhookSecret:  opts.WebhookSecret,
+		requireSHA256:  opts.RequireSHA256,
+		maxWebhookBody: opts.MaxWebhookBodySize,
+		eventSink:      opts.EventSink,
+		eventSinkAsync: opts.EventSinkAsync,
+		auditLogger:    opts.AuditLogger,
+		onStarChange:   opts.OnStarChange,
+		omitQueryBody:  opts.OmitQueryBody,
+		keyPrefix:      opts.KeyPrefix,
+		closeFunc:      cancel,
+		leaderLockTTL:  opts.LeaderLockTTL,
+		instanceID:     newRequestID(),
+		leaderAcquired: make(chan struct{}, 1),
+		repoErrors:     make(map[string]string),
+
+		historyRetention:   opts.HistoryRetention,
+		ignoreSenders:      make(map[string]struct{}, len(opts.IgnoreSenders)),
+		adminToken:         opts.AdminToken,
+		userAgent:          opts.UserAgent,
+		orgMembers:         opts.OrgMembers,
+		memberTTL:          opts.MemberTTL,
+		background:         make(chan struct{}, opts.MaxBackgroundTasks),
+		warmingUnavailable: opts.WarmingUnavailable,
+		requireFirstSync:   opts.RequireFirstSync,
+		clock:              opts.Clock,
+		ignoreUnstars:      opts.IgnoreUnstars,
+		build:              opts.Build,
+		incrementalSync:    opts.IncrementalSync,
+		fullSyncInterval:   opts.FullSyncInterval,
+		syncCap:            opts.MaxStargazersPerSync,
+		rateLimitWaiter:    opts.RateLimitWaiter,
+		graphQLTimeout:     opts.GraphQLTimeout,
+		storeTimeout:       opts.StoreTimeout,
+		relations:          []relation{stars},
+		freshLimiter:       &readThroughLimiter{limit: opts.FreshCheckLimit},
+	}
+	if opts.FairRateLimit {
+		api.budget = newRateBudget()
+	}
+	if opts.TrackForks {
+		api.relations = append(api.relations, forks)
+	}
+	if len(opts.Milestones) > 0 {
+		api.milestones = slices.Clone(opts.Milestones)
+		api.onMilestone = opts.OnMilestone
+	}
+	api.github.UserAgent = opts.UserAgent
+	api.signedAdminRequests = opts.SignedAdminRequests
+	api.dropWebhookOnStoreError = opts.DropWebhookOnStoreError
+	api.maxSyncAge = opts.MaxSyncAge
+	api.storeUserIDs = opts.StoreUserIDs
+	api.staggerFetches = !opts.DisableFetchStagger
+	api.readOnly.Store(opts.ReadOnly)
+	api.readOnlyAcceptWebhooks = opts.ReadOnlyAcceptWebhooks
+	api.webhookTargetType = opts.WebhookTargetType
+	api.webhookTargetID = opts.WebhookTargetID
+	api.webhookInstallationID = opts.WebhookInstallationID
+	api.webhookMaxAge = opts.WebhookMaxAge
+	api.webhookClockSkew = opts.WebhookClockSkew
+	api.ttlJitter = max(opts.TTLJitter, 0)
+	api.onSyncComplete = opts.OnSyncComplete
+	api.storeLoginCasing = opts.StoreLoginCasing
+	api.hashKeys = opts.HashKeys
+	api.expiresAtHeader = opts.ExpiresAtHeader
+	api.reconcile = opts.Reconcile
+	api.starDeltas = make(map[Repo]StarDelta)
+	if opts.MaxConcurrentQueries > 0 {
+		api.querySlots = make(chan struct{}, opts.MaxConcurrentQueries)
+	}
+	secrets := webhookSecretsByRepo(opts.WebhookSecrets)
+	api.webhookSecrets.Store(&secrets)
+	if opts.WebhookEchoMode {
+		if devEnv() {
+			api.webhookEchoMode = true
+			api.logger.Warn("WEBHOOK ECHO MODE IS ON: webhook signatures are not checked, never use this in production")
+		} else {
+			api.logger.Error("ignoring webhook echo mode outside development", "env_var", DevEnvVar)
+		}
+	}
+	if opts.NegativeCacheTTL > 0 {
+		api.negativeCache = newNegativeCache(opts.NegativeCacheTTL, opts.NegativeCacheSize)
+	}
+	if opts.ReadThrough {
+		api.readThroughLimiter = &readThroughLimiter{limit: opts.ReadThroughLimit}
+	}
+	for _, login := range opts.IgnoreSenders {
+		api.ignoreSenders[strings.ToLower(login)] = struct{}{}
+	}
+	if opts.LeaderElection {
+		locker, ok := opts.KV.(kv.Locker)
+		if ok {
+			api.locker = locker
+		} else {
+			api.logger.Error("store does not support locks, running the fetch loop without leader election")
+		}
+	}
+	if opts.SnapshotPath != "" {
+		snapshotter, ok := opts.KV.(kv.Snapshotter)
+		if ok {
+			api.snapshotter = snapshotter
+			api.snapshotPath = opts.SnapshotPath
+			if err := api.restoreSnapshot(); err != nil {
+				api.logger.Error("failed to restore snapshot", "path", opts.SnapshotPath, "error", err)
+			}
+		} else {
+			api.logger.Error("store does not support snapshots, not saving one")
+		}
 	}
 
-	api.mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+	// Every route is served by ServeHTTP, and also by either the public
+	// QueryHandler or the internal WebhookHandler, or both.
+	query := func(pattern string, handler http.HandlerFunc) {
+		handler = api.limitQueries(handler)
+		api.mux.HandleFunc(pattern, handler)
+		api.queryMux.HandleFunc(pattern, handler)
+	}
+	internal := func(pattern string, handler http.HandlerFunc) {
+		api.mux.HandleFunc(pattern, handler)
+		api.webhookMux.HandleFunc(pattern, handler)
+	}
+	// Only the root redirects, so other paths requested with the wrong
+	// method get a 405 listing the allowed methods rather than a redirect.
+	query("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "https://github.com/coder/starquery", http.StatusTemporaryRedirect)
 	})
-	api.mux.HandleFunc("GET /{org}/{repo}/user/{username}", api.handleStarredByUser)
-	api.mux.HandleFunc("POST /webhook", api.handleWebhook)
+	for _, rel := range api.relations {
+		query("GET /{org}/{repo}/"+rel.route+"/{username}", api.handleRelation(rel))
+	}
+	if api.storeUserIDs {
+		query("GET /{org}/{repo}/user-id/{id}", api.handleUserID)
+	}
+	query("GET /{org}/{repo}/history", api.handleHistory)
+	query("GET /user/{username}", api.handleUserStars)
+	query("GET /version", api.handleVersion)
+	internal("POST /webhook", api.handleWebhook)
+	internal("GET /metrics", api.handleMetrics)
+	api.mux.HandleFunc("GET /healthz", api.handleHealth)
+	api.queryMux.HandleFunc("GET /healthz", api.handleHealth)
+	api.webhookMux.HandleFunc("GET /healthz", api.handleHealth)
+	if api.orgMembers {
+		query("GET /orgs/{org}/members/{username}", api.handleOrgMember)
+	}
+	// Monitoring discovers the tracked repos here, so it's only gated
+	// when the admin endpoints are enabled.
+	admin := api.adminToken != "" || api.signedAdminRequests
+	handleRepos := api.handleRepos
+	if admin {
+		handleRepos = api.requireAdmin(handleRepos)
+	}
+	internal("GET /repos", handleRepos)
+	if admin {
+		internal("POST /{org}/{repo}/import", api.requireAdmin(api.handleImport))
+		if !api.hashKeys {
+			internal("GET /{org}/{repo}/export", api.requireAdmin(api.handleExport))
+			internal("GET /{org}/{repo}/stargazers", api.requireAdmin(api.handleStargazers))
+		}
+		internal("POST /{org}/{repo}/redeliver", api.requireAdmin(api.handleRedeliver))
+		internal("GET /debug/key", api.requireAdmin(api.handleDebugKey))
+		internal("GET /read-only", api.requireAdmin(api.handleReadOnly))
+		internal("PUT /read-only", api.requireAdmin(api.handleSetReadOnly))
+	}
+	api.handler = withRequestID(api.mux)
 
-	api.wg.Add(1)
-	go api.fetchLoop(ctx)
+	if api.locker != nil {
+		api.wg.Add(1)
+		go api.leaderLoop(ctx)
+	}
+	if !opts.DisableFetchLoop {
+		api.wg.Add(1)
+		go api.fetchLoop(ctx)
+	}
+	if opts.WebhookSecretsFile != "" {
+		loaded := api.reloadWebhookSecrets(opts.WebhookSecretsFile, nil)
+		api.wg.Add(1)
+		go api.watchWebhookSecrets(ctx, opts.WebhookSecretsFile, loaded)
+	}
 
 	return api
 }
 
 func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	a.mux.ServeHTTP(w, r)
+	a.handler.ServeHTTP(w, r)
 }
 
-// Close shuts down the API and waits for all goroutines to finish.
+// QueryHandler serves the public routes, e.g. star queries, so they
+// can be exposed on a different address than WebhookHandler.
+func (a *API) QueryHandler() http.Handler {
+	return withRequestID(a.queryMux)
+}
+
+// WebhookHandler serves the internal routes: webhooks, metrics, and
+// the admin endpoints.
+func (a *API) WebhookHandler() http.Handler {
+	return withRequestID(a.webhookMux)
+}
+
+// Close shuts down the API and waits for all goroutines to finish,
+// then saves the snapshot if one is configured.
 func (a *API) Close() {
+	// Cancel under closeMu, so no task is added once Wait may be running.
+	a.closeMu.Lock()
 	a.closeFunc()
+	a.closeMu.Unlock()
 	a.wg.Wait()
+	if a.snapshotter != nil {
+		if err := a.saveSnapshot(); err != nil {
+			a.logger.Error("failed to save snapshot", "path", a.snapshotPath, "error", err)
+		}
+	}
 }
 
-// handleStarredByUser returns 404 if the user has not starred, and
-// 204 if the user has starred the repo.
-func (a *API) handleStarredByUser(w http.ResponseWriter, r *http.Request) {
-	org := r.PathValue("org")
-	repoName := r.PathValue("repo")
+// writeFound responds to a query that found the user with 200, or 204
+// with OmitQueryBody.
+func (a *API) writeFound(w http.ResponseWriter, r *http.Request) {
+	if a.omitQueryBody {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write([]byte("OK"))
+	}
+}
+
+// writeFoundLogin is writeFound for a stargazer whose stored value is
+// value. With StoreLoginCasing, the body is their login as stored, or
+// as queried if it was stored without it.
+func (a *API) writeFoundLogin(w http.ResponseWriter, r *http.Request, value, queried string) {
+	login, _ := splitUserID(value)
+	if login == "" || login == stargazerValue {
+		login = queried
+	}
+	if !a.storeLoginCasing || login == "" || a.omitQueryBody || r.Method == http.MethodHead {
+		a.writeFound(w, r)
+		return
+	}
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(foundResponse{Starred: true, Login: login})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(login))
+}
+
+// foundResponse is the body of a query answer with StoreLoginCasing
+// for clients that accept JSON.
+type foundResponse struct {
+	Starred bool   `json:"starred"`
+	Login   string `json:"login"`
+}
+
+// notFoundResponse is the body of a 404 query answer for clients that
+// accept JSON.
+type notFoundResponse struct {
+	Starred bool `json:"starred"`
+}
+
+// writeQueryNotFound responds to a query that didn't find the user with
+// 404, and a body that reads as a normal answer rather than an error
+// page: {"starred": false} if the client accepts JSON, "Not found"
+// otherwise, or nothing with OmitQueryBody.
+func (a *API) writeQueryNotFound(w http.ResponseWriter, r *http.Request) {
+	if a.omitQueryBody || r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(notFoundResponse{Starred: false})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("Not found"))
+}
+
+// acceptsJSON reports whether the request's Accept header lists
+// application/json.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			mediaType, _, _ = strings.Cut(mediaType, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), "application/json") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// userStarsResponse is returned by the user stars endpoint.
+type userStarsResponse struct {
+	Starred      []string `json:"starred"`
+	ReposChecked []string `json:"repos_checked"`
+}
+
+// handleUserStars returns the configured repos the user has starred.
+func (a *API) handleUserStars(w http.ResponseWriter, r *http.Request) {
 	username := r.PathValue("username")
 
-	repo := Repo{Owner: org, Name: repoName}
-	value, err := a.kv.Get(r.Context(), repo.Key(username))
+	repos := a.Repos()
+	resp := userStarsResponse{
+		Starred:      []string{},
+		ReposChecked: make([]string, len(repos)),
+	}
+	keys := make([]string, len(repos))
+	for i, repo := range repos {
+		keys[i] = a.key(repo, username)
+		resp.ReposChecked[i] = repo.String()
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), a.storeTimeout)
+	defer cancel()
+	values, err := a.kv.MGet(ctx, keys)
 	if err != nil {
-		a.logger.Error("failed to get stargazer data", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		a.writeStoreError(w, r, err)
 		return
 	}
+	for i, value := range values {
+		if value != "" {
+			resp.Starred = append(resp.Starred, resp.ReposChecked[i])
+		}
+	}
 
-	if value == "" {
-		http.NotFound(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// writeStoreError responds to a failed store read, asking the client to
+// back off if the store is only temporarily unavailable.
+func (a *API) writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, kv.ErrUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+		a.logger.WarnContext(r.Context(), "store unavailable", "error", err)
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
 		return
 	}
+	a.logger.ErrorContext(r.Context(), "failed to get stargazer data", "error", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+// writeWebhookStoreError responds to a webhook whose store update
+// failed with a 500, so GitHub marks the delivery failed and it can be
+// redelivered, or with DropWebhookOnStoreError, with a 200 dropping it.
+func (a *API) writeWebhookStoreError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	deliveryID := github.DeliveryID(r)
+	if a.dropWebhookOnStoreError {
+		a.webhooksDropped.Add(1)
+		a.logger.ErrorContext(r.Context(), msg+", dropping the event", "delivery_id", deliveryID, "error", err)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	a.logger.ErrorContext(r.Context(), msg, "delivery_id", deliveryID, "error", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
 }
 
 // handleWebhook handles a GitHub webhook event.
 func (a *API) handleWebhook(w http.ResponseWriter, r *http.Request) {
-	payload, err := github.ValidatePayload(r, []byte(a.webhookSecret))
+	if a.requireSHA256 && !a.webhookEchoMode && r.Header.Get(github.SHA256SignatureHeader) == "" {
+		http.Error(w, "invalid signature: missing "+github.SHA256SignatureHeader, http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxWebhookBody)
+	payload, secretRepos, err := a.validateWebhook(r)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("invalid signature: %s", err), http.StatusBadRequest)
 		return
 	}
 
+	if err := a.checkWebhookTarget(r, payload); err != nil {
+		a.logger.WarnContext(r.Context(), "rejecting webhook for another target", "delivery_id", github.DeliveryID(r), "error", err)
+		http.Error(w, fmt.Sprintf("unexpected webhook target: %s", err), http.StatusForbidden)
+		return
+	}
+
+	if err := a.checkWebhookAge(r, payload); err != nil {
+		var staleErr *staleWebhookError
+		if errors.As(err, &staleErr) {
+			a.logger.WarnContext(r.Context(), "rejecting stale webhook", "delivery_id", github.DeliveryID(r), "error", err)
+			http.Error(w, fmt.Sprintf("stale webhook: %s", err), http.StatusBadRequest)
+			return
+		}
+		a.writeWebhookStoreError(w, r, "failed to check webhook age", err)
+		return
+	}
+
+	if a.eventSink != nil {
+		deliveryID := github.DeliveryID(r)
+		eventType := github.WebHookType(r)
+		if a.eventSinkAsync {
+			ctx := context.WithoutCancel(r.Context())
+			started := a.goBackground(r.Context(), func() {
+				a.eventSink(ctx, deliveryID, eventType, payload)
+			})
+			if !started {
+				a.logger.WarnContext(r.Context(), "request canceled before the event sink could run", "delivery_id", deliveryID)
+			}
+		} else {
+			a.eventSink(r.Context(), deliveryID, eventType, payload)
+		}
+	}
+
 	event, err := github.ParseWebHook(github.WebHookType(r), payload)
 	if err != nil {
+		// Deliveries only carry public star data, so the payload is
+		// logged as is.
+		a.logger.WarnContext(r.Context(), "failed to parse webhook",
+			"type", github.WebHookType(r), "delivery_id", github.DeliveryID(r),
+			"content_type", r.Header.Get("Content-Type"), "payload_bytes", len(payload),
+			"payload", string(payload[:min(len(payload), webhookPayloadLogPrefix)]), "error", err)
 		http.Error(w, "failed to parse request body", http.StatusBadRequest)
 		return
 	}
+	if a.webhookEchoMode {
+		a.echoWebhook(r, event)
+	}
+	if _, ping := event.(*github.PingEvent); a.readOnly.Load() && !ping {
+		a.writeReadOnlyWebhook(w, r)
+		return
+	}
 
 	var starEvent *github.StarEvent
 	switch event := event.(type) {
@@ -129,112 +1094,304 @@ func (a *API) handleWebhook(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 		return
-	}
-
-	owner := starEvent.Repo.Owner.GetLogin()
-	if owner == "" {
-		http.Error(w, "missing owner", http.StatusBadRequest)
+	case *github.OrganizationEvent, *github.MembershipEvent:
+		if !a.orgMembers {
+			http.Error(w, "unsupported event", http.StatusBadRequest)
+			return
+		}
+		// Per-repo secrets can't vouch for org events.
+		if secretRepos != nil {
+			http.Error(w, "invalid signature: secret is for a repo", http.StatusBadRequest)
+			return
+		}
+		a.handleMemberEvent(w, r, event)
+		return
+	case *github.ForkEvent:
+		if !a.tracksRelation(forks) {
+			http.Error(w, "unsupported event", http.StatusBadRequest)
+			return
+		}
+		a.handleForkEvent(w, r, event, secretRepos)
+		return
+	default:
+		http.Error(w, "unsupported event", http.StatusBadRequest)
 		return
 	}
 
-	name := starEvent.Repo.GetName()
-	if name == "" {
-		http.Error(w, "missing name", http.StatusBadRequest)
+	repo, ok := webhookRepo(w, starEvent.Repo, secretRepos)
+	if !ok {
 		return
 	}
-
-	repo := Repo{Owner: owner, Name: name}
 	username := starEvent.Sender.GetLogin()
+	userID := starEvent.Sender.GetID()
+	if _, ok := a.ignoreSenders[strings.ToLower(username)]; ok {
+		a.logger.DebugContext(r.Context(), "ignoring star from sender", "repo", repo, "user", username)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
 	switch starEvent.GetAction() {
 	case "created":
-		a.logger.Info("star added", "repo", starEvent.Repo.GetFullName(), "user", username)
-		err = a.storeStargazers(r.Context(), repo, []Stargazer{{Login: username}})
+		a.logger.InfoContext(r.Context(), "star added", "repo", starEvent.Repo.GetFullName(), "user", username)
+		err = a.storeStargazers(r.Context(), repo, []Stargazer{{Login: username, ID: userID}})
 	case "deleted":
-		a.logger.Info("star removed", "repo", starEvent.Repo.GetFullName(), "user", username)
-		err = a.kv.Delete(r.Context(), repo.Key(username))
+		if a.ignoreUnstars {
+			a.logger.DebugContext(r.Context(), "ignoring unstar", "repo", repo, "user", username)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		a.logger.InfoContext(r.Context(), "star removed", "repo", starEvent.Repo.GetFullName(), "user", username)
+		// Unstarring a user who isn't stored, e.g. one who starred
+		// before the repo was tracked, isn't an error.
+		err = a.unstar(r.Context(), repo, username, userID)
 	default:
 		http.Error(w, "unsupported action", http.StatusBadRequest)
 		return
 	}
 	if err != nil {
-		a.logger.Error("failed to update stargazer data", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		a.writeWebhookStoreError(w, r, "failed to update stargazer data", err)
 		return
 	}
 
+	change := StarChange{
+		Repo:   repo,
+		Login:  username,
+		Action: starEvent.GetAction(),
+		Time:   starEvent.GetStarredAt().Time,
+	}
+	if change.Time.IsZero() {
+		change.Time = a.clock.Now()
+	}
+	if a.auditLogger != nil {
+		a.auditLogger.Record(change)
+	}
+	if a.onStarChange != nil {
+		if err := a.onStarChange(r.Context(), change); err != nil {
+			a.logger.ErrorContext(r.Context(), "star change callback failed", "repo", repo, "user", username, "error", err)
+		}
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// webhookRepo returns the repo a webhook event is for. If the event is
+// missing it, or was signed with another repo's secret, it writes an
+// error and returns false.
+func webhookRepo(w http.ResponseWriter, ghRepo *github.Repository, secretRepos map[string]struct{}) (Repo, bool) {
+	owner := ghRepo.GetOwner().GetLogin()
+	if owner == "" {
+		http.Error(w, "missing owner", http.StatusBadRequest)
+		return Repo{}, false
+	}
+
+	name := ghRepo.GetName()
+	if name == "" {
+		http.Error(w, "missing name", http.StatusBadRequest)
+		return Repo{}, false
+	}
+
+	repo := Repo{Owner: owner, Name: name}
+	if _, ok := secretRepos[strings.ToLower(repo.String())]; secretRepos != nil && !ok {
+		http.Error(w, "invalid signature: secret is for another repo", http.StatusBadRequest)
+		return Repo{}, false
+	}
+	return repo, true
+}
+
 func (a *API) fetchLoop(ctx context.Context) {
 	defer a.wg.Done()
 
-	ticker := time.NewTicker(15 * time.Minute)
+	ticker := a.clock.NewTicker(a.fetchInterval)
 	defer ticker.Stop()
 
+	// Without staggering, the first pass leaves repos that were never
+	// synced to backfills. Staggered passes sync them in turn instead,
+	// so a cold start doesn't fetch every repo at once.
+	var backfilled map[Repo]bool
+	if !a.staggerFetches {
+		backfilled = a.backfillUnsynced(ctx)
+	}
 	for {
-		for _, repo := range a.repos {
-			if err := a.fetchByRepo(ctx, repo); err != nil && ctx.Err() == nil {
-				a.logger.Error("failed to fetch stargazers", "repo", repo, "error", err)
+		start := a.clock.Now()
+		repos := a.Repos()
+		for i, repo := range repos {
+			if backfilled[repo] {
+				continue
 			}
+			if i > 0 && a.staggerFetches {
+				a.waitUntil(ctx, start.Add(a.fetchInterval*time.Duration(i)/time.Duration(len(repos))))
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			if a.readOnly.Load() {
+				a.logger.Info("read-only, pausing fetches")
+				break
+			}
+			a.syncRepo(ctx, repo)
 		}
+		backfilled = nil
 
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
+		case <-a.leaderAcquired:
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-// fetchByRepo fetches stargazers for the given repo.
-func (a *API) fetchByRepo(ctx context.Context, repo Repo) error {
+// waitUntil waits until t or ctx is done. It returns right away if t
+// has passed, e.g. because an earlier repo's sync ran long.
+func (a *API) waitUntil(ctx context.Context, t time.Time) {
+	d := t.Sub(a.clock.Now())
+	if d <= 0 {
+		return
+	}
+	select {
+	case <-a.clock.After(d):
+	case <-ctx.Done():
+	}
+}
+
+// fetchByRepo fetches stargazers for the given repo using the configured
+// strategy, returning the number stored and, with Reconcile, the delta
+// from the stargazers stored before.
+func (a *API) fetchByRepo(ctx context.Context, repo Repo) (int, *StarDelta, error) {
+	rec, err := a.newReconciler(ctx, repo)
+	if err != nil {
+		return 0, nil, err
+	}
+	var stored int
+	switch a.fetchStrategy {
+	case FetchREST:
+		stored, err = a.paginate(ctx, repo, a.capped("rest", rec.record(a.fetchStargazersFromREST)), time.Time{})
+	case FetchAuto:
+		stored, err = a.paginate(ctx, repo, a.capped("graphql", a.resumable(rec.record(a.fetchStargazersFromGitHub))), time.Time{})
+		if err != nil && graphQLUnavailable(err) {
+			a.logger.Warn("graphql unavailable, falling back to rest", "repo", repo, "error", err)
+			stored, err = a.paginate(ctx, repo, a.capped("rest", rec.record(a.fetchStargazersFromREST)), time.Time{})
+		}
+	default:
+		stored, err = a.paginate(ctx, repo, a.capped("graphql", a.resumable(rec.record(a.fetchStargazersFromGitHub))), time.Time{})
+	}
+	if err != nil {
+		return stored, nil, err
+	}
+	delta, err := a.reconcileStargazers(ctx, repo, rec)
+	return stored, delta, err
+}
+
+// pageFetcher fetches a page of stargazers after the cursor, returning
+// the time the rate limit resets if it has been reached and the
+// remaining rate limit.
+type pageFetcher func(ctx context.Context, repo Repo, cursor string) ([]Stargazer, time.Time, int, error)
+
+// paginate fetches and stores all pages of stargazers for the repo,
+// returning the number stored. If since is set, pages are assumed to be
+// newest first and paging stops after the first star older than since.
+func (a *API) paginate(ctx context.Context, repo Repo, fetchPage pageFetcher, since time.Time) (int, error) {
+	return a.paginateRelation(ctx, stars, repo, fetchPage, since)
+}
+
+// paginateRelation is paginate for any relation.
+func (a *API) paginateRelation(ctx context.Context, rel relation, repo Repo, fetchPage pageFetcher, since time.Time) (int, error) {
 	var cursor string
+	if since.IsZero() {
+		cursor = a.takePausedCursor(rel, repo)
+	}
+	var stored int
 	for {
-		a.logger.Info("fetching stargazers", "repo", repo)
-		stargazers, resetTime, remaining, err := a.fetchStargazersFromGitHub(ctx, repo, cursor)
+		if a.readOnly.Load() {
+			a.pauseSync(rel, repo, cursor, since)
+			return stored, errReadOnly
+		}
+		a.logger.Info("fetching "+rel.name, "repo", repo)
+		fetchedAt := a.clock.Now()
+		stargazers, resetTime, remaining, err := fetchPage(ctx, repo, cursor)
 		if err != nil {
-			return fmt.Errorf("fetch stargazers: %w", err)
+			return stored, fmt.Errorf("fetch %s: %w", rel.name, err)
 		}
 
-		if err := a.storeStargazers(ctx, repo, stargazers); err != nil {
-			return fmt.Errorf("store stargazers: %w", err)
+		// The page is fetched again once writes resume.
+		if a.readOnly.Load() {
+			a.pauseSync(rel, repo, cursor, since)
+			return stored, errReadOnly
+		}
+		if err := a.storeFetched(ctx, rel, repo, stargazers, fetchedAt); err != nil {
+			return stored, fmt.Errorf("store %s: %w", rel.name, err)
 		}
+		stored += len(stargazers)
 
-		a.logger.Info("stored stargazers", "repo", repo, "count", len(stargazers), "rate_limit_remaining", remaining)
+		a.logger.Info("stored "+rel.name, "repo", repo, "count", len(stargazers), "rate_limit_remaining", remaining)
 
 		if !resetTime.IsZero() {
-			waitDuration := time.Until(resetTime) + time.Second
-			a.logger.Info("rate limit reached", "repo", repo, "wait", waitDuration)
-			select {
-			case <-time.After(waitDuration):
-			case <-ctx.Done():
-				return ctx.Err()
+			a.logger.Info("rate limit reached", "repo", repo, "reset", resetTime)
+			if err := a.rateLimitWaiter.Wait(ctx, resetTime, remaining); err != nil {
+				return stored, err
 			}
 		}
 
 		if len(stargazers) == 0 {
 			break
 		}
+		if !since.IsZero() && stargazers[len(stargazers)-1].StarredAt.Before(since) {
+			break
+		}
 		cursor = stargazers[len(stargazers)-1].Cursor
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return stored, ctx.Err()
 		}
 	}
-	return nil
+	return stored, nil
 }
 
-// storeStargazers stores the stargazers for the given repo.
+// stargazerValue is the value stored for every stargazer, whether it
+// was fetched or received via webhook. Only the key's presence matters,
+// unless StoreLoginCasing stores the login instead.
+const stargazerValue = "true"
+
+// storeStargazers stores the stargazers for the given repo. Only their
+// logins are persisted, as the same stargazerValue or, with
+// StoreLoginCasing, as themselves. StoreUserIDs appends known IDs.
 func (a *API) storeStargazers(ctx context.Context, repo Repo, stargazers []Stargazer) error {
-	if len(stargazers) == 0 {
+	return a.storeRelation(ctx, stars, repo, stargazers)
+}
+
+// storeRelation is storeStargazers for any relation.
+func (a *API) storeRelation(ctx context.Context, rel relation, repo Repo, users []Stargazer) error {
+	if len(users) == 0 {
+		return nil
+	}
+	pairs := make([][2]string, 0, len(users))
+	for _, s := range users {
+		if s.Login == "" {
+			// GitHub couldn't resolve the user, e.g. a suspended one.
+			continue
+		}
+		value := stargazerValue
+		if a.storeLoginCasing {
+			value = s.Login
+		}
+		if rel == stars && a.storeUserIDs && s.ID != 0 {
+			pairs = append(pairs, [2]string{a.relationKey(rel, repo, s.Login), withUserID(value, s.ID)})
+			pairs = append(pairs, [2]string{a.userIDKey(repo, s.ID), value})
+			continue
+		}
+		pairs = append(pairs, [2]string{a.relationKey(rel, repo, s.Login), value})
+	}
+	if len(pairs) == 0 {
 		return nil
 	}
-	pairs := make([][2]string, len(stargazers))
-	for i, s := range stargazers {
-		pairs[i] = [2]string{repo.Key(s.Login), "true"}
+	if err := a.setexJittered(ctx, a.ttl, pairs); err != nil {
+		return err
 	}
-	// Store for 24hrs!
-	return a.kv.Setex(ctx, 24*60*60, pairs)
+	if a.negativeCache != nil {
+		for _, pair := range pairs {
+			a.negativeCache.remove(pair[0])
+		}
+	}
+	return nil
 }
 
 // Repo represents a GitHub repository.
@@ -243,37 +1400,230 @@ type Repo struct {
 	Name  string
 }
 
+// ParseRepos parses a comma-separated list of "owner/repo" names, e.g.
+// "coder/coder,coder/code-server". Blank entries are skipped.
+func ParseRepos(s string) ([]Repo, error) {
+	var repos []Repo
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		owner, name, ok := strings.Cut(field, "/")
+		if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid repo %q, must be \"owner/repo\"", field)
+		}
+		repos = append(repos, Repo{Owner: owner, Name: name})
+	}
+	return repos, nil
+}
+
 func (r Repo) String() string {
 	return fmt.Sprintf("%s/%s", r.Owner, r.Name)
 }
 
-// Key returns the storage key for the repo with the username.
+// lower returns the repo with its owner and name lowercased. GitHub
+// treats both case-insensitively.
+func (r Repo) lower() Repo {
+	return Repo{Owner: strings.ToLower(r.Owner), Name: strings.ToLower(r.Name)}
+}
+
+// Key returns the storage key for the repo with the username
+// under DefaultKeyPrefix.
 func (r Repo) Key(username string) string {
-	return fmt.Sprintf("stargazers:%s/%s/%s", r.Owner, r.Name, username)
+	return r.PrefixedKey(DefaultKeyPrefix, username)
+}
+
+// PrefixedKey returns the storage key for the repo with the username
+// under the given namespace prefix. GitHub logins are case-insensitive,
+// so the owner, name, and username are lowercased.
+func (r Repo) PrefixedKey(prefix, username string) string {
+	r = r.lower()
+	return fmt.Sprintf("%s:%s/%s/%s", prefix, r.Owner, r.Name, strings.ToLower(username))
+}
+
+// key returns the storage key for the repo with the username under
+// the configured prefix.
+func (a *API) key(repo Repo, username string) string {
+	return a.userKey(a.keyPrefix, repo, username)
+}
+
+// IsStargazer reports whether the store has username as a stargazer of
+// repo. Unlike queries, it never looks users up on GitHub, even with
+// ReadThrough.
+func (a *API) IsStargazer(ctx context.Context, repo Repo, username string) (bool, error) {
+	_, err := a.kv.Get(ctx, a.key(repo, username))
+	if errors.Is(err, kv.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get stargazer: %w", err)
+	}
+	return true, nil
+}
+
+// AreStargazers is IsStargazer for many users at once, reporting in
+// order whether the store has each of usernames as a stargazer of repo.
+func (a *API) AreStargazers(ctx context.Context, repo Repo, usernames []string) ([]bool, error) {
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(usernames))
+	for i, username := range usernames {
+		keys[i] = a.key(repo, username)
+	}
+	values, err := a.kv.MGet(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("get stargazers: %w", err)
+	}
+	starred := make([]bool, len(values))
+	for i, value := range values {
+		starred[i] = value != ""
+	}
+	return starred, nil
+}
+
+// CountStargazers returns the number of the repo's stored stargazers.
+// Stores implementing kv.Counter count them without listing them, which
+// may include expired stargazers that haven't been removed yet. Other
+// stores must implement kv.Scanner, and keys must not be hashed.
+func (a *API) CountStargazers(ctx context.Context, repo Repo) (int, error) {
+	if a.hashKeys {
+		return 0, errHashedKeys
+	}
+	if counter, ok := a.kv.(kv.Counter); ok {
+		count, err := counter.Count(ctx, a.key(repo, ""))
+		if err != nil {
+			return 0, fmt.Errorf("count stargazers: %w", err)
+		}
+		return count, nil
+	}
+	logins, err := a.storedLogins(ctx, repo)
+	return len(logins), err
+}
+
+// StarChange describes a single star or unstar of a repo.
+type StarChange struct {
+	Repo  Repo
+	Login string
+	// Action is "created" for a star and "deleted" for an unstar.
+	Action string
+	Time   time.Time
+}
+
+// ScopeError is returned when the GitHub token lacks the permissions
+// required to read a repository's stargazers.
+type ScopeError struct {
+	// Type is the GraphQL error type, e.g. FORBIDDEN or INSUFFICIENT_SCOPES.
+	Type    string
+	Message string
+	// Scopes are the scopes granted to the token as reported by GitHub.
+	Scopes string
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("github token lacks permissions (%s): %s", e.Type, e.Message)
+}
+
+// ErrRepoNotFound is returned when a repository doesn't exist or the
+// GitHub token can't see it, e.g. because of a typo in its name.
+var ErrRepoNotFound = errors.New("repository not found or not visible to the github token")
+
+// graphQLError is an error returned in the body of a GitHub GraphQL response.
+type graphQLError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	// Path locates the field that failed, e.g. ["repository",
+	// "stargazers", "edges", 3, "node"] for an edge's user.
+	Path []any `json:"path"`
+}
+
+// nodeErrors splits out the errors for fields within the repository's
+// connection, e.g. a suspended user GitHub couldn't resolve. Those
+// fields are null, but the rest of the page is still valid.
+func nodeErrors(errs []graphQLError, connection string) (repoErrs, nodeErrs []graphQLError) {
+	for _, gqlErr := range errs {
+		if len(gqlErr.Path) > 2 && gqlErr.Path[0] == "repository" && gqlErr.Path[1] == connection {
+			nodeErrs = append(nodeErrs, gqlErr)
+		} else {
+			repoErrs = append(repoErrs, gqlErr)
+		}
+	}
+	return repoErrs, nodeErrs
+}
+
+// repositoryError converts the errors of a GraphQL repository query
+// into a ScopeError, ErrRepoNotFound, or a generic error, in that order
+// of precedence. scopes is the X-OAuth-Scopes response header.
+func repositoryError(errs []graphQLError, scopes string) error {
+	for _, gqlErr := range errs {
+		if gqlErr.Type == "FORBIDDEN" || gqlErr.Type == "INSUFFICIENT_SCOPES" {
+			return &ScopeError{
+				Type:    gqlErr.Type,
+				Message: gqlErr.Message,
+				Scopes:  scopes,
+			}
+		}
+	}
+	for _, gqlErr := range errs {
+		if gqlErr.Type == "NOT_FOUND" {
+			return ErrRepoNotFound
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("graphql error (%s): %s", errs[0].Type, errs[0].Message)
+	}
+	return nil
 }
 
 // Stargazer stores the username and cursor of the user starring.
 type Stargazer struct {
-	Login  string
-	Cursor string
+	Login string
+	// ID is the user's numeric GitHub ID, or zero if it's unknown.
+	ID int64
+	// Cursor positions the next page while paginating. It's empty for
+	// stargazers received via webhook, and is never persisted.
+	Cursor    string
+	StarredAt time.Time
+}
+
+// closeOnDone closes body once ctx is done, so reading a stalled
+// response can't outlive ctx even if the client's transport ignores the
+// request's context. Call the returned func once done reading.
+func closeOnDone(ctx context.Context, body io.Closer) func() {
+	stop := context.AfterFunc(ctx, func() { _ = body.Close() })
+	return func() { stop() }
 }
 
-// fetchStargazersFromGitHub fetches stargazers for the given repo from GitHub.
+// fetchStargazersFromGitHub fetches stargazers for the given repo from
+// GitHub, oldest first.
 func (a *API) fetchStargazersFromGitHub(ctx context.Context, repo Repo, cursor string) ([]Stargazer, time.Time, int, error) {
-	variables := map[string]string{
-		"owner": repo.Owner,
-		"name":  repo.Name,
-		"after": cursor,
+	return a.fetchRelationFromGitHub(ctx, stars, repo, cursor, nil)
+}
+
+// fetchRelationFromGitHub fetches a page of the users related to the
+// given repo from GitHub, in the given order for the relation's
+// connection, or GitHub's default if it's nil.
+func (a *API) fetchRelationFromGitHub(ctx context.Context, rel relation, repo Repo, cursor string, orderBy map[string]string) ([]Stargazer, time.Time, int, error) {
+	if a.budget != nil && !a.budget.take(repo, len(a.Repos()), a.clock.Now()) {
+		return nil, time.Time{}, 0, errRateBudgetExceeded
+	}
+	variables := map[string]any{
+		"owner":   repo.Owner,
+		"name":    repo.Name,
+		"after":   cursor,
+		"orderBy": orderBy,
 	}
-	query := `
-	query($owner: String!, $name: String!, $after: String) {
+	query := fmt.Sprintf(`
+	query($owner: String!, $name: String!, $after: String, $orderBy: %s) {
 		repository(owner: $owner, name: $name) {
-			stargazers(first: 100, after: $after) {
+			%s(first: 100, after: $after, orderBy: $orderBy) {
 				edges {
 					node {
-						login
+						%s
 					}
 					cursor
+					%s
 				}
 			}
 		}
@@ -281,7 +1631,7 @@ func (a *API) fetchStargazersFromGitHub(ctx context.Context, repo Repo, cursor s
 			remaining
 			resetAt
 		}
-	}`
+	}`, rel.order, rel.connection, a.relationNode(rel), rel.edge)
 
 	reqBody, err := json.Marshal(map[string]any{
 		"query":     query,
@@ -291,65 +1641,61 @@ func (a *API) fetchStargazersFromGitHub(ctx context.Context, repo Repo, cursor s
 		return nil, time.Time{}, 0, fmt.Errorf("marshal query: %w", err)
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, a.graphQLTimeout)
+	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.github.com/graphql", bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, time.Time{}, 0, fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", a.userAgent)
 
 	resp, err := a.client.Do(req)
 	if err != nil {
 		return nil, time.Time{}, 0, fmt.Errorf("do request: %w", err)
 	}
 	defer resp.Body.Close()
+	defer closeOnDone(ctx, resp.Body)()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, time.Time{}, 0, fmt.Errorf("unexpected status: %d", resp.StatusCode)
-	}
-
-	var response struct {
-		Data struct {
-			Repository struct {
-				Stargazers struct {
-					Edges []struct {
-						Node struct {
-							Login string `json:"login"`
-						} `json:"node"`
-						Cursor string `json:"cursor"`
-					} `json:"edges"`
-				} `json:"stargazers"`
-			} `json:"repository"`
-			RateLimit struct {
-				Remaining int    `json:"remaining"`
-				ResetAt   string `json:"resetAt"`
-			} `json:"rateLimit"`
-		} `json:"data"`
-	}
-
-	body, err := io.ReadAll(resp.Body)
+		return nil, time.Time{}, 0, statusError(resp.StatusCode)
+	}
+
+	// The response is decoded as it's read, so large pages aren't
+	// buffered whole. Its start is kept for errors.
+	prefix := &prefixWriter{limit: graphQLBodyPrefix}
+	page, err := decodeRelationPage(io.TeeReader(resp.Body, prefix), rel.connection)
 	if err != nil {
-		return nil, time.Time{}, 0, fmt.Errorf("read response: %w", err)
+		return nil, time.Time{}, 0, fmt.Errorf("decode response: %w: %s", err, prefix.buf)
 	}
 
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, time.Time{}, 0, fmt.Errorf("decode response: %w", err)
+	// GraphQL reports most errors with a 200 status in the body.
+	repoErrs, nodeErrs := nodeErrors(page.Errors, rel.connection)
+	if err := repositoryError(repoErrs, resp.Header.Get("X-OAuth-Scopes")); err != nil {
+		return nil, time.Time{}, 0, err
+	}
+	if !page.RepositoryFound {
+		return nil, time.Time{}, 0, ErrRepoNotFound
+	}
+	if len(nodeErrs) > 0 {
+		// Their edges have no login, so they're skipped when stored,
+		// but still carry the cursor to continue from.
+		a.logger.Warn("skipping "+rel.name+" github couldn't resolve", "repo", repo, "count", len(nodeErrs),
+			"type", nodeErrs[0].Type, "message", nodeErrs[0].Message)
 	}
 
 	var resetTime time.Time
-	if response.Data.RateLimit.Remaining == 0 {
-		resetTime, err = time.Parse(time.RFC3339, response.Data.RateLimit.ResetAt)
+	if page.RateLimit.Remaining == 0 {
+		resetTime, err = time.Parse(time.RFC3339, page.RateLimit.ResetAt)
 		if err != nil {
-			return nil, time.Time{}, 0, fmt.Errorf("parse reset time: %w: %s", err, body)
+			return nil, time.Time{}, 0, fmt.Errorf("parse reset time: %w: %s", err, prefix.buf)
 		}
 	}
-
-	var stargazers []Stargazer
-	for _, edge := range response.Data.Repository.Stargazers.Edges {
-		stargazers = append(stargazers, Stargazer{
-			Login:  edge.Node.Login,
-			Cursor: edge.Cursor,
-		})
+	if a.budget != nil {
+		if reset, err := time.Parse(time.RFC3339, page.RateLimit.ResetAt); err == nil {
+			a.budget.observe(repo, page.RateLimit.Remaining, reset)
+		}
 	}
 
-	return stargazers, resetTime, response.Data.RateLimit.Remaining, nil
+	return page.Stargazers, resetTime, page.RateLimit.Remaining, nil
 }
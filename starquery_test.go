@@ -4,22 +4,166 @@ import (
 	"bytes"
 	"context"
 	"crypto/hmac"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
 	"github.com/coder/starquery/kv"
 	"github.com/google/go-github/v52/github"
-	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+func TestNewWithError(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		opts    starquery.Options
+		wantErr string
+	}{
+		{
+			name: "Valid",
+			opts: starquery.Options{Repos: []starquery.Repo{{Owner: "coder", Name: "coder"}}},
+		},
+		{
+			name:    "InvalidRepo",
+			opts:    starquery.Options{Repos: []starquery.Repo{{Owner: "coder/coder"}}},
+			wantErr: "invalid repo",
+		},
+		{
+			name:    "DuplicateRepo",
+			opts:    starquery.Options{Repos: []starquery.Repo{{Owner: "coder", Name: "coder"}, {Owner: "coder", Name: "coder"}}},
+			wantErr: "duplicate repo",
+		},
+		{
+			name:    "TTLJitterTooLarge",
+			opts:    starquery.Options{TTLJitter: 1},
+			wantErr: "ttl jitter 1 must be less than 1",
+		},
+		{
+			name:    "JitteredTTLTooShort",
+			opts:    starquery.Options{TTL: time.Hour, FetchInterval: 50 * time.Minute, TTLJitter: 0.2},
+			wantErr: "less the jitter must be longer than the fetch interval",
+		},
+		{
+			name:    "IncrementalSyncREST",
+			opts:    starquery.Options{IncrementalSync: true, FetchStrategy: starquery.FetchREST},
+			wantErr: "incremental sync requires graphql",
+		},
+		{
+			name:    "FullSyncIntervalTooLong",
+			opts:    starquery.Options{IncrementalSync: true, FullSyncInterval: starquery.DefaultTTL},
+			wantErr: "full sync interval",
+		},
+		{
+			name:    "TrackForksREST",
+			opts:    starquery.Options{TrackForks: true, FetchStrategy: starquery.FetchREST},
+			wantErr: "tracking forks requires graphql",
+		},
+		{
+			name:    "SnapshotUnsupported",
+			opts:    starquery.Options{KV: errorStore{Store: kv.NewMemory()}, SnapshotPath: "snapshot.json"},
+			wantErr: "snapshots require",
+		},
+		{
+			name:    "DuplicateRepoCase",
+			opts:    starquery.Options{Repos: []starquery.Repo{{Owner: "coder", Name: "coder"}, {Owner: "Coder", Name: "Coder"}}},
+			wantErr: "duplicate repo",
+		},
+		{
+			name:    "TTLShorterThanInterval",
+			opts:    starquery.Options{FetchInterval: time.Hour, TTL: time.Minute},
+			wantErr: "must be longer than the fetch interval",
+		},
+		{
+			name:    "UnknownFetchStrategy",
+			opts:    starquery.Options{FetchStrategy: "soap"},
+			wantErr: "unknown fetch strategy",
+		},
+		{
+			name:    "InvalidWebhookSecretRepo",
+			opts:    starquery.Options{WebhookSecrets: map[string]string{"coder": "secret"}},
+			wantErr: "invalid webhook secret repo",
+		},
+		{
+			name:    "LeaderElectionWithoutLocks",
+			opts:    starquery.Options{KV: errorStore{}, LeaderElection: true},
+			wantErr: "requires a store that supports locks",
+		},
+		{
+			name:    "ReconcileWithoutScanner",
+			opts:    starquery.Options{KV: errorStore{}, Reconcile: true},
+			wantErr: "reconcile requires a store that supports listing keys",
+		},
+		{
+			name:    "ReconcileHashKeys",
+			opts:    starquery.Options{KV: kv.NewMemory(), Reconcile: true, HashKeys: true},
+			wantErr: "reconcile can't list stargazers stored under hashed keys",
+		},
+		{
+			name:    "UnreachableStore",
+			opts:    starquery.Options{KV: errorStore{err: kv.ErrUnavailable}},
+			wantErr: "ping store",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			api, err := starquery.NewWithError(context.Background(), tc.opts)
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				api.Close()
+				return
+			}
+			require.ErrorContains(t, err, tc.wantErr)
+			require.Nil(t, api)
+		})
+	}
+}
+
+func TestAllowUnavailableStore(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	store := kv.NewRedisWithOptions(addr, kv.RedisOptions{
+		Retry: kv.RetryPolicy{Attempts: 1},
+	})
+
+	_, err = starquery.NewWithError(context.Background(), starquery.Options{KV: store})
+	require.ErrorContains(t, err, "ping store")
+
+	api, err := starquery.NewWithError(context.Background(), starquery.Options{
+		KV:                    store,
+		AllowUnavailableStore: true,
+	})
+	require.NoError(t, err)
+	defer api.Close()
+	req := httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil)
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, req)
+	require.Equal(t, http.StatusServiceUnavailable, res.Code, "unexpected status code")
+	require.NotEmpty(t, res.Header().Get("Retry-After"))
+}
+
 func TestWebhook(t *testing.T) {
 	t.Parallel()
 
@@ -45,22 +189,21 @@ func TestWebhook(t *testing.T) {
 	t.Run("Delete", func(t *testing.T) {
 		t.Parallel()
 		ctx := context.Background()
-		kv := kv.NewMemory()
+		store := kv.NewMemory()
 		api := starquery.New(ctx, starquery.Options{
-			KV:            kv,
+			KV:            store,
 			WebhookSecret: "secret",
 		})
 		defer api.Close()
 		repo := starquery.Repo{Owner: "coder", Name: "coder"}
-		err := kv.Setex(ctx, 60, [][2]string{{repo.Key("kylecarbs"), "true"}})
+		err := store.Setex(ctx, 60, [][2]string{{repo.Key("kylecarbs"), "true"}})
 		require.NoError(t, err)
 		req := generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", "deleted"))
 		res := httptest.NewRecorder()
 		api.ServeHTTP(res, req)
 		require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
-		v, err := kv.Get(ctx, repo.Key("kylecarbs"))
-		require.NoError(t, err)
-		require.Empty(t, v)
+		_, err = store.Get(ctx, repo.Key("kylecarbs"))
+		require.ErrorIs(t, err, kv.ErrNotFound)
 	})
 
 	t.Run("InvalidSignature", func(t *testing.T) {
@@ -78,6 +221,263 @@ func TestWebhook(t *testing.T) {
 		require.Equal(t, http.StatusBadRequest, res.Code, "expected bad request for invalid signature")
 	})
 
+	t.Run("SignatureHeaders", func(t *testing.T) {
+		t.Parallel()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		for _, tc := range []struct {
+			name          string
+			sha1, sha256  bool
+			requireSHA256 bool
+			want          int
+		}{
+			{name: "SHA1Only", sha1: true, want: http.StatusOK},
+			{name: "SHA256Only", sha256: true, want: http.StatusOK},
+			{name: "Both", sha1: true, sha256: true, want: http.StatusOK},
+			{name: "RequireSHA256/SHA1Only", sha1: true, requireSHA256: true, want: http.StatusBadRequest},
+			{name: "RequireSHA256/SHA256Only", sha256: true, requireSHA256: true, want: http.StatusOK},
+			{name: "RequireSHA256/Both", sha1: true, sha256: true, requireSHA256: true, want: http.StatusOK},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+				api := starquery.New(context.Background(), starquery.Options{
+					KV:            kv.NewMemory(),
+					WebhookSecret: "secret",
+					RequireSHA256: tc.requireSHA256,
+				})
+				defer api.Close()
+				req := generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", "created"))
+				if !tc.sha256 {
+					req.Header.Del("X-Hub-Signature-256")
+				}
+				if tc.sha1 {
+					data, err := io.ReadAll(req.Body)
+					require.NoError(t, err)
+					req.Body = io.NopCloser(bytes.NewReader(data))
+					hash := hmac.New(sha1.New, []byte("secret"))
+					hash.Write(data)
+					req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(hash.Sum(nil)))
+				}
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+				require.Equal(t, tc.want, res.Code, "unexpected status code")
+			})
+		}
+	})
+
+	t.Run("EventSink", func(t *testing.T) {
+		t.Parallel()
+		type delivery struct {
+			id, eventType string
+			payload       []byte
+		}
+		for _, async := range []bool{false, true} {
+			t.Run(fmt.Sprintf("Async=%t", async), func(t *testing.T) {
+				t.Parallel()
+				deliveries := make(chan delivery, 1)
+				api := starquery.New(context.Background(), starquery.Options{
+					KV:            kv.NewMemory(),
+					WebhookSecret: "secret",
+					EventSink: func(_ context.Context, deliveryID, eventType string, payload []byte) {
+						deliveries <- delivery{id: deliveryID, eventType: eventType, payload: payload}
+					},
+					EventSinkAsync: async,
+				})
+				defer api.Close()
+				repo := starquery.Repo{Owner: "coder", Name: "coder"}
+				event := generateEvent(repo, "kylecarbs", "created")
+				req := generateWebhook(t, "secret", event)
+				req.Header.Set("X-GitHub-Delivery", "delivery-id")
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, req)
+				require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+
+				want, err := json.Marshal(event)
+				require.NoError(t, err)
+				got := <-deliveries
+				require.Equal(t, "delivery-id", got.id)
+				require.Equal(t, "star", got.eventType)
+				require.Equal(t, want, got.payload)
+			})
+		}
+	})
+
+	t.Run("EventSinkInvalidSignature", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:            kv.NewMemory(),
+			WebhookSecret: "secret",
+			EventSink: func(context.Context, string, string, []byte) {
+				t.Error("event sink called for invalid signature")
+			},
+		})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		req := generateWebhook(t, "wrong_secret", generateEvent(repo, "kylecarbs", "created"))
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusBadRequest, res.Code, "unexpected status code")
+	})
+
+	t.Run("FormEncoded", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{
+			KV:            store,
+			WebhookSecret: "secret",
+		})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		data, err := json.Marshal(generateEvent(repo, "kylecarbs", "created"))
+		require.NoError(t, err)
+		body := url.Values{"payload": {string(data)}}.Encode()
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "star")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		// GitHub signs the raw form body, not the JSON payload.
+		hash := hmac.New(sha256.New, []byte("secret"))
+		hash.Write([]byte(body))
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(hash.Sum(nil)))
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+		v, err := store.Get(ctx, repo.Key("kylecarbs"))
+		require.NoError(t, err)
+		require.NotEmpty(t, v)
+	})
+
+	t.Run("BodyTooLarge", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:                 kv.NewMemory(),
+			WebhookSecret:      "secret",
+			MaxWebhookBodySize: 64,
+		})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		req := generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", "created"))
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusRequestEntityTooLarge, res.Code, "unexpected status code")
+	})
+
+	t.Run("OnStarChange", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		changes := make(chan starquery.StarChange, 1)
+		api := starquery.New(ctx, starquery.Options{
+			KV:            store,
+			WebhookSecret: "secret",
+			OnStarChange: func(ctx context.Context, change starquery.StarChange) error {
+				// The store is updated before the callback runs.
+				_, err := store.Get(ctx, change.Repo.Key(change.Login))
+				require.NoError(t, err)
+				changes <- change
+				return errors.New("callback errors don't fail the webhook")
+			},
+		})
+		defer api.Close()
+		req := generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", "created"))
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+		change := <-changes
+		require.Equal(t, repo, change.Repo)
+		require.Equal(t, "kylecarbs", change.Login)
+		require.Equal(t, "created", change.Action)
+		require.False(t, change.Time.IsZero())
+	})
+
+	t.Run("WebhookSecrets", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{
+			KV:            store,
+			WebhookSecret: "global",
+			WebhookSecrets: map[string]string{
+				"coder/coder":     "coder-secret",
+				"Coder/Starquery": "starquery-secret",
+			},
+		})
+		defer api.Close()
+		coder := starquery.Repo{Owner: "coder", Name: "coder"}
+		starqueryRepo := starquery.Repo{Owner: "coder", Name: "starquery"}
+
+		for _, tc := range []struct {
+			secret     string
+			repo       starquery.Repo
+			wantStatus int
+		}{
+			{secret: "coder-secret", repo: coder, wantStatus: http.StatusOK},
+			{secret: "starquery-secret", repo: starqueryRepo, wantStatus: http.StatusOK},
+			{secret: "global", repo: starqueryRepo, wantStatus: http.StatusOK},
+			// A repo's secret can't be used to write another repo.
+			{secret: "coder-secret", repo: starqueryRepo, wantStatus: http.StatusBadRequest},
+			{secret: "unknown", repo: coder, wantStatus: http.StatusBadRequest},
+		} {
+			username := tc.secret + "-user"
+			req := generateWebhook(t, tc.secret, generateEvent(tc.repo, username, "created"))
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, req)
+			require.Equal(t, tc.wantStatus, res.Code, "%s for %s", tc.secret, tc.repo)
+			_, err := store.Get(ctx, tc.repo.Key(username))
+			if tc.wantStatus == http.StatusOK {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, kv.ErrNotFound)
+			}
+		}
+	})
+
+	t.Run("IgnoreSenders", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{
+			KV:            store,
+			WebhookSecret: "secret",
+			IgnoreSenders: []string{"Dependabot"},
+		})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		req := generateWebhook(t, "secret", generateEvent(repo, "dependabot", "created"))
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+		_, err := store.Get(ctx, repo.Key("dependabot"))
+		require.ErrorIs(t, err, kv.ErrNotFound)
+	})
+
+	t.Run("IgnoreUnstars", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		var changes atomic.Int64
+		api := starquery.New(ctx, starquery.Options{
+			KV:            store,
+			WebhookSecret: "secret",
+			IgnoreUnstars: true,
+			OnStarChange: func(context.Context, starquery.StarChange) error {
+				changes.Add(1)
+				return nil
+			},
+		})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		for _, action := range []string{"created", "deleted"} {
+			req := generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", action))
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, req)
+			require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+		}
+		_, err := store.Get(ctx, repo.Key("kylecarbs"))
+		require.NoError(t, err, "unstar was applied")
+		require.EqualValues(t, 1, changes.Load(), "only the star is a change")
+	})
+
 	t.Run("UnsupportedEvent", func(t *testing.T) {
 		t.Parallel()
 		ctx := context.Background()
@@ -92,6 +492,30 @@ func TestWebhook(t *testing.T) {
 		api.ServeHTTP(res, req)
 		require.Equal(t, http.StatusBadRequest, res.Code, "expected bad request for unsupported event")
 	})
+
+	t.Run("ParseFailure", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		var logs bytes.Buffer
+		api := starquery.New(ctx, starquery.Options{
+			KV:            kv.NewMemory(),
+			WebhookSecret: "secret",
+			Logger:        slog.New(slog.NewTextHandler(&logs, nil)),
+		})
+		defer api.Close()
+		long := strings.Repeat("a", 4096)
+		req := generateEventWebhook(t, "secret", "unsupported_event", map[string]string{"padding": long})
+		req.Header.Set("X-GitHub-Delivery", "delivery-1")
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		require.Equal(t, "failed to parse request body\n", res.Body.String())
+
+		require.Contains(t, logs.String(), "failed to parse webhook")
+		require.Contains(t, logs.String(), "type=unsupported_event delivery_id=delivery-1 content_type=application/json payload_bytes=4110")
+		require.Contains(t, logs.String(), `payload="{\"padding\":\"aaaa`)
+		require.NotContains(t, logs.String(), long)
+	})
 }
 
 func TestStarredByUser(t *testing.T) {
@@ -122,6 +546,266 @@ func TestStarredByUser(t *testing.T) {
 		api.ServeHTTP(res, req)
 		require.Equal(t, http.StatusNotFound, res.Code, "unexpected status code")
 	})
+
+	t.Run("Head", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		kv := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{KV: kv})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		err := kv.Setex(ctx, 60, [][2]string{{repo.Key("kylecarbs"), "true"}})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodHead, "/coder/coder/user/kylecarbs", nil)
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+		require.Empty(t, res.Body.Bytes())
+
+		req = httptest.NewRequest(http.MethodHead, "/coder/coder/user/other", nil)
+		res = httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusNotFound, res.Code, "unexpected status code")
+	})
+
+	t.Run("OmitBody", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		kv := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{KV: kv, OmitQueryBody: true})
+		defer api.Close()
+		repo := starquery.Repo{Owner: "coder", Name: "coder"}
+		err := kv.Setex(ctx, 60, [][2]string{{repo.Key("kylecarbs"), "true"}})
+		require.NoError(t, err)
+
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			req := httptest.NewRequest(method, "/coder/coder/user/kylecarbs", nil)
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, req)
+			require.Equal(t, http.StatusNoContent, res.Code, "unexpected status code")
+			require.Empty(t, res.Body.Bytes())
+		}
+	})
+
+	t.Run("StoreUnavailable", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV: errorStore{Store: kv.NewMemory(), err: fmt.Errorf("%w: LOADING", kv.ErrUnavailable)},
+		})
+		defer api.Close()
+		req := httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil)
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusServiceUnavailable, res.Code, "unexpected status code")
+		require.NotEmpty(t, res.Header().Get("Retry-After"))
+	})
+
+	t.Run("StoreError", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV: errorStore{Store: kv.NewMemory(), err: errors.New("WRONGTYPE")},
+		})
+		defer api.Close()
+		req := httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil)
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusInternalServerError, res.Code, "unexpected status code")
+	})
+}
+
+func TestSplitHandlers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := kv.NewMemory()
+	api := starquery.New(ctx, starquery.Options{
+		KV:            store,
+		WebhookSecret: "secret",
+		AdminToken:    "token",
+	})
+	defer api.Close()
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	require.NoError(t, store.Setex(ctx, 60, [][2]string{{repo.Key("kylecarbs"), "true"}}))
+
+	serve := func(handler http.Handler, req *http.Request) int {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		require.NotEmpty(t, res.Header().Get(starquery.RequestIDHeader))
+		return res.Code
+	}
+	stargazer := func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil)
+	}
+	webhook := func() *http.Request {
+		return generateWebhook(t, "secret", generateEvent(repo, "ammar", "created"))
+	}
+	export := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/coder/coder/export", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		return req
+	}
+	healthz := func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	}
+
+	require.Equal(t, http.StatusOK, serve(api.QueryHandler(), stargazer()))
+	require.Equal(t, http.StatusOK, serve(api.QueryHandler(), healthz()))
+	require.NotEqual(t, http.StatusOK, serve(api.QueryHandler(), webhook()))
+	require.NotEqual(t, http.StatusOK, serve(api.QueryHandler(), export()))
+
+	require.Equal(t, http.StatusOK, serve(api.WebhookHandler(), webhook()))
+	require.Equal(t, http.StatusOK, serve(api.WebhookHandler(), export()))
+	require.Equal(t, http.StatusOK, serve(api.WebhookHandler(), healthz()))
+	require.Equal(t, http.StatusNotFound, serve(api.WebhookHandler(), stargazer()))
+
+	// The combined handler serves everything.
+	for _, req := range []*http.Request{stargazer(), webhook(), export(), healthz()} {
+		require.Equal(t, http.StatusOK, serve(api, req), req.URL.Path)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	api := starquery.New(context.Background(), starquery.Options{
+		KV:         kv.NewMemory(),
+		AdminToken: "token",
+		OrgMembers: true,
+	})
+	defer api.Close()
+
+	for _, tc := range []struct {
+		method    string
+		path      string
+		wantAllow string
+	}{
+		{http.MethodPost, "/", "GET, HEAD"},
+		{http.MethodGet, "/webhook", "POST"},
+		{http.MethodPost, "/coder/coder/user/kylecarbs", "GET, HEAD"},
+		{http.MethodDelete, "/coder/coder/history", "GET, HEAD"},
+		{http.MethodPost, "/user/kylecarbs", "GET, HEAD"},
+		{http.MethodPost, "/version", "GET, HEAD"},
+		{http.MethodPost, "/metrics", "GET, HEAD"},
+		{http.MethodPost, "/healthz", "GET, HEAD"},
+		{http.MethodPost, "/orgs/coder/members/kylecarbs", "GET, HEAD"},
+		{http.MethodGet, "/coder/coder/import", "POST"},
+		{http.MethodPost, "/coder/coder/export", "GET, HEAD"},
+		{http.MethodPut, "/coder/coder/stargazers", "GET, HEAD"},
+		{http.MethodPost, "/debug/key", "GET, HEAD"},
+	} {
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(tc.method, tc.path, nil))
+		require.Equal(t, http.StatusMethodNotAllowed, res.Code, "%s %s", tc.method, tc.path)
+		require.Equal(t, tc.wantAllow, res.Header().Get("Allow"), "%s %s", tc.method, tc.path)
+	}
+
+	// Unknown paths are still not found.
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	require.Equal(t, http.StatusNotFound, res.Code)
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := kv.NewMemory()
+	api := starquery.New(ctx, starquery.Options{
+		KV:            store,
+		WebhookSecret: "secret",
+	})
+	defer api.Close()
+
+	require.Equal(t, starquery.Repo{Owner: "coder", Name: "coder"}.Key("kylecarbs"),
+		starquery.Repo{Owner: "Coder", Name: "CODER"}.Key("KyleCarbs"))
+
+	req := generateWebhook(t, "secret", generateEvent(starquery.Repo{Owner: "Coder", Name: "Coder"}, "KyleCarbs", "created"))
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+
+	for _, path := range []string{
+		"/coder/coder/user/kylecarbs",
+		"/Coder/Coder/user/KyleCarbs",
+		"/CODER/coder/user/KYLECARBS",
+	} {
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, res.Code, path)
+	}
+
+	req = generateWebhook(t, "secret", generateEvent(starquery.Repo{Owner: "coder", Name: "coder"}, "kylecarbs", "deleted"))
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/Coder/Coder/user/KyleCarbs", nil))
+	require.Equal(t, http.StatusNotFound, res.Code)
+}
+
+func TestIsStargazer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	store := kv.NewMemory()
+	require.NoError(t, store.Setex(ctx, 60, [][2]string{{repo.Key("kylecarbs"), "true"}}))
+	api := starquery.New(ctx, starquery.Options{
+		KV:               store,
+		DisableFetchLoop: true,
+	})
+	defer api.Close()
+
+	starred, err := api.IsStargazer(ctx, starquery.Repo{Owner: "Coder", Name: "Coder"}, "KyleCarbs")
+	require.NoError(t, err)
+	require.True(t, starred)
+	starred, err = api.IsStargazer(ctx, repo, "someone")
+	require.NoError(t, err)
+	require.False(t, starred)
+
+	failing := starquery.New(ctx, starquery.Options{
+		KV:               errorStore{Store: store, err: errors.New("unavailable")},
+		DisableFetchLoop: true,
+	})
+	defer failing.Close()
+	_, err = failing.IsStargazer(ctx, repo, "kylecarbs")
+	require.ErrorContains(t, err, "unavailable")
+}
+
+func TestUserStars(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := kv.NewMemory()
+	coder := starquery.Repo{Owner: "coder", Name: "coder"}
+	api := starquery.New(ctx, starquery.Options{
+		KV:    store,
+		Repos: []starquery.Repo{coder, {Owner: "coder", Name: "starquery"}},
+	})
+	defer api.Close()
+	err := store.Setex(ctx, 60, [][2]string{{coder.Key("kylecarbs"), "true"}})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		username string
+		starred  []string
+	}{
+		{username: "kylecarbs", starred: []string{"coder/coder"}},
+		{username: "nobody", starred: []string{}},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/user/"+tc.username, nil)
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+		var body struct {
+			Starred      []string `json:"starred"`
+			ReposChecked []string `json:"repos_checked"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		require.Equal(t, tc.starred, body.Starred)
+		require.Equal(t, []string{"coder/coder", "coder/starquery"}, body.ReposChecked)
+	}
 }
 
 func TestFetchStargazers(t *testing.T) {
@@ -131,53 +815,281 @@ func TestFetchStargazers(t *testing.T) {
 		t.Parallel()
 		ctx := context.Background()
 		kv := kv.NewMemory()
+		type result struct {
+			repo   starquery.Repo
+			stored int
+			err    error
+		}
+		synced := make(chan result, 1)
+		gh := &fakeGitHub{edges: map[string]string{"stargazers": `[
+			{"node": {"login": "user1"}, "cursor": "cursor1"},
+			{"node": {"login": "user2"}, "cursor": "cursor2"}
+		]`}}
+		api := starquery.New(ctx, starquery.Options{
+			Client: gh.client(),
+			KV:     kv,
+			Repos:  []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			OnSyncComplete: func(repo starquery.Repo, stored int, _ *starquery.StarDelta, err error) {
+				select {
+				case synced <- result{repo: repo, stored: stored, err: err}:
+				default:
+				}
+			},
+		})
+		defer api.Close()
+
+		select {
+		case got := <-synced:
+			require.Equal(t, result{repo: starquery.Repo{Owner: "coder", Name: "coder"}, stored: 2}, got)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the sync didn't complete")
+		}
+		for _, user := range []string{"user1", "user2"} {
+			v, err := kv.Get(ctx, "stargazers:coder/coder/"+user)
+			require.NoError(t, err)
+			require.NotEmpty(t, v)
+		}
+	})
+
+	t.Run("PartialPage", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		errs := make(chan error, 1)
 		api := starquery.New(ctx, starquery.Options{
 			Client: &http.Client{
 				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
-					return &http.Response{
-						StatusCode: http.StatusOK,
-						Body: io.NopCloser(bytes.NewBufferString(`{
-								"data": {
-									"repository": {
-										"stargazers": {
-											"edges": [
-												{"node": {"login": "user1"}, "cursor": "cursor1"},
-												{"node": {"login": "user2"}, "cursor": "cursor2"}
-											]
-										}
-									},
-										"rateLimit": {
-										"remaining": 50,
-										"resetAt": "2023-04-01T00:00:00Z"
-									}
-								}
-							}`)),
-					}, nil
+					var body struct {
+						Variables struct {
+							After string `json:"after"`
+						} `json:"variables"`
+					}
+					if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+						return nil, err
+					}
+					// The first page's second user is suspended, so GitHub
+					// nulls it and reports why.
+					response := `{"data": {"repository": {"stargazers": {"edges": []}}, "rateLimit": {"remaining": 50}}}`
+					switch body.Variables.After {
+					case "":
+						response = `{
+							"data": {
+								"repository": {"stargazers": {"edges": [
+									{"node": {"login": "user1"}, "cursor": "c1"},
+									{"node": null, "cursor": "c2"}
+								]}},
+								"rateLimit": {"remaining": 50}
+							},
+							"errors": [{"type": "NOT_FOUND", "path": ["repository", "stargazers", "edges", 1, "node"], "message": "Could not resolve to a User."}]
+						}`
+					case "c2":
+						response = `{"data": {"repository": {"stargazers": {"edges": [{"node": {"login": "user3"}, "cursor": "c3"}]}}, "rateLimit": {"remaining": 50}}}`
+					}
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(response))}, nil
 				}),
 			},
-			KV:    kv,
-			Repos: []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			KV:            store,
+			Repos:         []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			FetchStrategy: starquery.FetchGraphQL,
+			OnSyncComplete: func(_ starquery.Repo, _ int, _ *starquery.StarDelta, err error) {
+				select {
+				case errs <- err:
+				default:
+				}
+			},
 		})
 		defer api.Close()
 
-		require.Eventually(t, func() bool {
-			v, err := kv.Get(ctx, "stargazers:coder/coder/user1")
-			if !assert.NoError(t, err) {
-				return false
-			}
-			if !assert.NotEmpty(t, v) {
-				return false
-			}
-			v, err = kv.Get(ctx, "stargazers:coder/coder/user2")
-			if !assert.NoError(t, err) {
-				return false
-			}
-			if !assert.NotEmpty(t, v) {
-				return false
+		select {
+		case err := <-errs:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the sync didn't complete")
+		}
+		for _, user := range []string{"user1", "user3"} {
+			_, err := store.Get(ctx, "stargazers:coder/coder/"+user)
+			require.NoError(t, err, user)
+		}
+		keys, err := store.(kv.Scanner).Keys(ctx, "stargazers:coder/coder/")
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: http.StatusBadGateway, Body: http.NoBody}, nil
+				}),
+			},
+			KV:            kv.NewMemory(),
+			Repos:         []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			FetchStrategy: starquery.FetchGraphQL,
+			OnSyncComplete: func(_ starquery.Repo, _ int, _ *starquery.StarDelta, err error) {
+				select {
+				case errs <- err:
+				default:
+				}
+			},
+		})
+		defer api.Close()
+
+		select {
+		case err := <-errs:
+			require.Error(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the sync didn't complete")
+		}
+	})
+}
+
+// TestStargazerValue verifies that stargazers received via webhook and
+// fetched from GitHub are stored identically.
+func TestStargazerValue(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := kv.NewMemory()
+	gh := &fakeGitHub{edges: map[string]string{
+		"stargazers": `[{"node": {"login": "fetched"}, "cursor": "cursor1"}]`,
+	}}
+	api := starquery.New(ctx, starquery.Options{
+		Client:        gh.client(),
+		KV:            store,
+		Repos:         []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		WebhookSecret: "secret",
+	})
+	defer api.Close()
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+
+	req := generateWebhook(t, "secret", generateEvent(repo, "hooked", "created"))
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+
+	var fetched string
+	require.Eventually(t, func() bool {
+		var err error
+		fetched, err = store.Get(ctx, repo.Key("fetched"))
+		return err == nil
+	}, time.Second, time.Millisecond)
+	hooked, err := store.Get(ctx, repo.Key("hooked"))
+	require.NoError(t, err)
+	require.Equal(t, fetched, hooked, "stored values must match")
+	require.NotContains(t, fetched, "cursor1", "cursors must not be persisted")
+
+	var responses []*httptest.ResponseRecorder
+	for _, username := range []string{"fetched", "hooked"} {
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/user/"+username, nil))
+		responses = append(responses, res)
+	}
+	require.Equal(t, responses[0].Code, responses[1].Code)
+	require.Equal(t, responses[0].Body.String(), responses[1].Body.String())
+}
+
+func TestUserAgent(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		userAgent string
+		version   string
+		want      string
+	}{
+		{name: "Default", want: "starquery/"},
+		{name: "BuildVersion", version: "v1.2.3", want: "starquery/v1.2.3"},
+		{name: "Custom", userAgent: "custom/1.0", want: "custom/1.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			userAgents := make(map[string]string)
+			api := starquery.New(context.Background(), starquery.Options{
+				Client: &http.Client{
+					Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+						mu.Lock()
+						userAgents[req.URL.Path] = req.Header.Get("User-Agent")
+						mu.Unlock()
+						// Fail GraphQL so the REST API is used too.
+						return &http.Response{
+							StatusCode: http.StatusBadGateway,
+							Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
+							Request:    req,
+						}, nil
+					}),
+				},
+				FetchStrategy: starquery.FetchAuto,
+				KV:            kv.NewMemory(),
+				Repos:         []starquery.Repo{{Owner: "coder", Name: "coder"}},
+				UserAgent:     tc.userAgent,
+				Build:         starquery.BuildInfo{Version: tc.version},
+			})
+			defer api.Close()
+
+			require.Eventually(t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(userAgents) >= 2
+			}, time.Second, time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			for path, userAgent := range userAgents {
+				require.True(t, strings.HasPrefix(userAgent, tc.want), "%s: unexpected user agent %q", path, userAgent)
 			}
-			return true
-		}, time.Second, time.Millisecond)
+		})
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := kv.NewMemory()
+	gh := &fakeGitHub{edges: map[string]string{
+		"stargazers": `[{"node": {"login": "user1"}, "cursor": "cursor1"}]`,
+	}}
+	api := starquery.New(ctx, starquery.Options{
+		Client:        gh.client(),
+		KV:            store,
+		KeyPrefix:     "custom",
+		Repos:         []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		WebhookSecret: "secret",
 	})
+	defer api.Close()
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+
+	// Fetched stargazers are stored under the custom prefix.
+	require.Eventually(t, func() bool {
+		v, err := store.Get(ctx, repo.PrefixedKey("custom", "user1"))
+		return err == nil && v != ""
+	}, time.Second, time.Millisecond)
+	_, err := store.Get(ctx, repo.Key("user1"))
+	require.ErrorIs(t, err, kv.ErrNotFound, "default prefix must not be written")
+
+	// Webhook stargazers are stored under the custom prefix.
+	req := generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", "created"))
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+	v, err := store.Get(ctx, repo.PrefixedKey("custom", "kylecarbs"))
+	require.NoError(t, err)
+	require.NotEmpty(t, v)
+
+	// Queries read from the custom prefix.
+	req = httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code, "unexpected status code")
+
+	err = store.Setex(ctx, 60, [][2]string{{repo.Key("other"), "true"}})
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodGet, "/coder/coder/user/other", nil)
+	res = httptest.NewRecorder()
+	api.ServeHTTP(res, req)
+	require.Equal(t, http.StatusNotFound, res.Code, "default prefix must not be read")
 }
 
 func generateEvent(repo starquery.Repo, username string, action string) github.StarEvent {
@@ -196,10 +1108,14 @@ func generateEvent(repo starquery.Repo, username string, action string) github.S
 }
 
 func generateWebhook(t *testing.T, secret string, payload github.StarEvent) *http.Request {
+	return generateEventWebhook(t, secret, "star", payload)
+}
+
+func generateEventWebhook(t *testing.T, secret, eventType string, payload any) *http.Request {
 	data, err := json.Marshal(payload)
 	require.NoError(t, err)
 	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(data))
-	req.Header.Set("X-GitHub-Event", "star")
+	req.Header.Set("X-GitHub-Event", eventType)
 
 	// generate sha256
 	hash := hmac.New(sha256.New, []byte(secret))
@@ -209,8 +1125,295 @@ func generateWebhook(t *testing.T, secret string, payload github.StarEvent) *htt
 	return req
 }
 
+// errorStore is a kv.Store that fails every Get and Ping with err.
+type errorStore struct {
+	kv.Store
+	err error
+}
+
+func (s errorStore) Get(context.Context, string) (string, error) {
+	return "", s.err
+}
+
+func (s errorStore) Ping(context.Context) error {
+	return s.err
+}
+
+func TestFetchInterval(t *testing.T) {
+	t.Parallel()
+
+	var gh fakeGitHub
+	clk := clock.NewFake(time.Now())
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: gh.client(),
+		Repos:  []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		Clock:  clk,
+	})
+	defer api.Close()
+
+	require.Eventually(t, func() bool {
+		return gh.fetches.Load() == 1
+	}, 5*time.Second, time.Millisecond)
+	clk.Advance(starquery.DefaultFetchInterval)
+	require.Eventually(t, func() bool {
+		return gh.fetches.Load() == 2
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestFetchStagger(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var fetched []string
+	clk := clock.NewFake(time.Now())
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				var body struct {
+					Query     string `json:"query"`
+					Variables struct {
+						Name string `json:"name"`
+					} `json:"variables"`
+				}
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					return nil, err
+				}
+				if strings.Contains(body.Query, "stargazers(") {
+					mu.Lock()
+					fetched = append(fetched, body.Variables.Name)
+					mu.Unlock()
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"data": {"repository": {"stargazers": {"edges": []}, "stargazerCount": 0}, "rateLimit": {"remaining": 5000}}}`)),
+				}, nil
+			}),
+		},
+		Repos: []starquery.Repo{{Owner: "coder", Name: "a"}, {Owner: "coder", Name: "b"}, {Owner: "coder", Name: "c"}},
+		Clock: clk,
+	})
+	defer api.Close()
+
+	waitFetched := func(want ...string) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return slices.Equal(fetched, want)
+		}, 5*time.Second, time.Millisecond)
+	}
+
+	// Each repo starts a third of the interval after the previous one,
+	// even on a cold start when none of them were synced before.
+	waitFetched("a")
+	clk.Advance(starquery.DefaultFetchInterval / 3)
+	waitFetched("a", "b")
+	clk.Advance(starquery.DefaultFetchInterval / 3)
+	waitFetched("a", "b", "c")
+	clk.Advance(starquery.DefaultFetchInterval / 3)
+	waitFetched("a", "b", "c", "a")
+}
+
+func TestCloseDuringFetch(t *testing.T) {
+	t.Parallel()
+
+	// The transport ignores the request's context and stalls mid-body,
+	// like a proxy that stopped sending.
+	started := make(chan struct{})
+	var once sync.Once
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				body, w := io.Pipe()
+				go func() {
+					_, _ = io.WriteString(w, `{"data": {"repository": {"stargazers": {"edges": [`)
+					once.Do(func() { close(started) })
+				}()
+				return &http.Response{StatusCode: http.StatusOK, Body: body}, nil
+			}),
+		},
+		KV:    kv.NewMemory(),
+		Repos: []starquery.Repo{{Owner: "coder", Name: "coder"}},
+	})
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		api.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() didn't return while a fetch was mid-response")
+	}
+}
+
 type roundTripper func(req *http.Request) (*http.Response, error)
 
 func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return rt(req)
 }
+
+// fakeGitHub fakes the GitHub APIs that syncs and star lookups call.
+// GraphQL requests for a relation are answered with its edges, keyed by
+// the relation's connection, e.g. "stargazers" or "forks", on the first
+// page and none after it, and with starCount as the repo's star count.
+// Lookups of a user's starred repos list starred[login], and fail for
+// users missing from it. If release is set, requests are counted, then
+// wait for it to be closed before they're answered.
+type fakeGitHub struct {
+	edges     map[string]string
+	starCount int
+	starred   map[string][]string
+	release   <-chan struct{}
+
+	// fetches counts the relation pages fetched, and lookups the users
+	// whose starred repos were looked up.
+	fetches atomic.Int64
+	lookups atomic.Int64
+}
+
+// client returns a client whose requests are answered by f.
+func (f *fakeGitHub) client() *http.Client {
+	return &http.Client{Transport: roundTripper(f.roundTrip)}
+}
+
+func (f *fakeGitHub) roundTrip(req *http.Request) (*http.Response, error) {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Request:    req,
+	}
+	if login, ok := strings.CutSuffix(strings.TrimPrefix(req.URL.Path, "/users/"), "/starred"); ok {
+		f.lookups.Add(1)
+		if err := f.wait(req); err != nil {
+			return nil, err
+		}
+		repos, ok := f.starred[login]
+		if !ok {
+			res.StatusCode = http.StatusBadGateway
+			res.Body = io.NopCloser(strings.NewReader(`{"message": "bad gateway"}`))
+			return res, nil
+		}
+		stars := make([]string, 0, len(repos))
+		for _, repo := range repos {
+			stars = append(stars, fmt.Sprintf(`{"starred_at": "2023-04-01T00:00:00Z", "repo": {"full_name": %q}}`, repo))
+		}
+		res.Body = io.NopCloser(strings.NewReader("[" + strings.Join(stars, ",") + "]"))
+		return res, nil
+	}
+
+	var body struct {
+		Query     string `json:"query"`
+		Variables struct {
+			After string `json:"after"`
+		} `json:"variables"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	connection, edges := "stargazers", "[]"
+	for _, c := range []string{"stargazers", "forks"} {
+		if !strings.Contains(body.Query, c+"(") {
+			continue
+		}
+		f.fetches.Add(1)
+		connection = c
+		if body.Variables.After == "" && f.edges[c] != "" {
+			edges = f.edges[c]
+		}
+	}
+	if err := f.wait(req); err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(strings.NewReader(fmt.Sprintf(
+		`{"data": {"repository": {%q: {"edges": %s}, "stargazerCount": %d}, "rateLimit": {"remaining": 5000}}}`,
+		connection, edges, f.starCount)))
+	return res, nil
+}
+
+// wait waits for f.release, if it's set, to be closed, or for req to be
+// canceled.
+func (f *fakeGitHub) wait(req *http.Request) error {
+	if f.release == nil {
+		return nil
+	}
+	select {
+	case <-f.release:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// query serves a GET request for path with handler.
+func query(handler http.Handler, path string) *httptest.ResponseRecorder {
+	return serveHTTP(handler, httptest.NewRequest(http.MethodGet, path, nil))
+}
+
+// serveHTTP serves req with handler and returns the response.
+func serveHTTP(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	return res
+}
+
+// writeErrorStore is a kv.Store that fails every Setex and Delete.
+type writeErrorStore struct {
+	kv.Store
+}
+
+func (writeErrorStore) Setex(context.Context, uint, [][2]string) error {
+	return kv.ErrUnavailable
+}
+
+func (writeErrorStore) Delete(context.Context, string) error {
+	return kv.ErrUnavailable
+}
+
+func TestWebhookStoreError(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	for _, tc := range []struct {
+		name string
+		drop bool
+		want int
+	}{
+		{name: "Retry", want: http.StatusInternalServerError},
+		{name: "Drop", drop: true, want: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			api := starquery.New(context.Background(), starquery.Options{
+				KV:                      writeErrorStore{Store: kv.NewMemory()},
+				WebhookSecret:           "secret",
+				DropWebhookOnStoreError: tc.drop,
+			})
+			defer api.Close()
+			for _, action := range []string{"created", "deleted"} {
+				res := httptest.NewRecorder()
+				api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", action)))
+				require.Equal(t, tc.want, res.Code, action)
+			}
+
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			dropped := 0
+			if tc.drop {
+				dropped = 2
+			}
+			require.Contains(t, res.Body.String(), fmt.Sprintf("starquery_webhooks_dropped_total %d\n", dropped))
+		})
+	}
+
+	t.Run("UnstarMissing", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory(), WebhookSecret: "secret"})
+		defer api.Close()
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", "deleted")))
+		require.Equal(t, http.StatusOK, res.Code, "unstarring a user who isn't stored succeeds")
+	})
+}
@@ -0,0 +1,5 @@
+// Package starquerypb defines the gRPC query service that
+// API.GRPCServer serves, with the code generated from starquery.proto.
+package starquerypb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative starquery.proto
@@ -0,0 +1,211 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: starquery.proto
+
+package starquerypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Starquery_IsStargazer_FullMethodName   = "/starquery.v1.Starquery/IsStargazer"
+	Starquery_AreStargazers_FullMethodName = "/starquery.v1.Starquery/AreStargazers"
+	Starquery_Count_FullMethodName         = "/starquery.v1.Starquery/Count"
+)
+
+// StarqueryClient is the client API for Starquery service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Starquery answers stargazer queries from the store, for services that
+// would rather call gRPC than the HTTP API.
+type StarqueryClient interface {
+	// IsStargazer reports whether the user has starred the repo.
+	IsStargazer(ctx context.Context, in *IsStargazerRequest, opts ...grpc.CallOption) (*IsStargazerResponse, error)
+	// AreStargazers reports whether each of the users has starred the
+	// repo.
+	AreStargazers(ctx context.Context, in *AreStargazersRequest, opts ...grpc.CallOption) (*AreStargazersResponse, error)
+	// Count returns the number of the repo's stored stargazers.
+	Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*CountResponse, error)
+}
+
+type starqueryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStarqueryClient(cc grpc.ClientConnInterface) StarqueryClient {
+	return &starqueryClient{cc}
+}
+
+func (c *starqueryClient) IsStargazer(ctx context.Context, in *IsStargazerRequest, opts ...grpc.CallOption) (*IsStargazerResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IsStargazerResponse)
+	err := c.cc.Invoke(ctx, Starquery_IsStargazer_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *starqueryClient) AreStargazers(ctx context.Context, in *AreStargazersRequest, opts ...grpc.CallOption) (*AreStargazersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AreStargazersResponse)
+	err := c.cc.Invoke(ctx, Starquery_AreStargazers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *starqueryClient) Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*CountResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CountResponse)
+	err := c.cc.Invoke(ctx, Starquery_Count_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StarqueryServer is the server API for Starquery service.
+// All implementations must embed UnimplementedStarqueryServer
+// for forward compatibility.
+//
+// Starquery answers stargazer queries from the store, for services that
+// would rather call gRPC than the HTTP API.
+type StarqueryServer interface {
+	// IsStargazer reports whether the user has starred the repo.
+	IsStargazer(context.Context, *IsStargazerRequest) (*IsStargazerResponse, error)
+	// AreStargazers reports whether each of the users has starred the
+	// repo.
+	AreStargazers(context.Context, *AreStargazersRequest) (*AreStargazersResponse, error)
+	// Count returns the number of the repo's stored stargazers.
+	Count(context.Context, *CountRequest) (*CountResponse, error)
+	mustEmbedUnimplementedStarqueryServer()
+}
+
+// UnimplementedStarqueryServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedStarqueryServer struct{}
+
+func (UnimplementedStarqueryServer) IsStargazer(context.Context, *IsStargazerRequest) (*IsStargazerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IsStargazer not implemented")
+}
+func (UnimplementedStarqueryServer) AreStargazers(context.Context, *AreStargazersRequest) (*AreStargazersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AreStargazers not implemented")
+}
+func (UnimplementedStarqueryServer) Count(context.Context, *CountRequest) (*CountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Count not implemented")
+}
+func (UnimplementedStarqueryServer) mustEmbedUnimplementedStarqueryServer() {}
+func (UnimplementedStarqueryServer) testEmbeddedByValue()                   {}
+
+// UnsafeStarqueryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StarqueryServer will
+// result in compilation errors.
+type UnsafeStarqueryServer interface {
+	mustEmbedUnimplementedStarqueryServer()
+}
+
+func RegisterStarqueryServer(s grpc.ServiceRegistrar, srv StarqueryServer) {
+	// If the following call pancis, it indicates UnimplementedStarqueryServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Starquery_ServiceDesc, srv)
+}
+
+func _Starquery_IsStargazer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IsStargazerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StarqueryServer).IsStargazer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Starquery_IsStargazer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StarqueryServer).IsStargazer(ctx, req.(*IsStargazerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Starquery_AreStargazers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AreStargazersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StarqueryServer).AreStargazers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Starquery_AreStargazers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StarqueryServer).AreStargazers(ctx, req.(*AreStargazersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Starquery_Count_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StarqueryServer).Count(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Starquery_Count_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StarqueryServer).Count(ctx, req.(*CountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Starquery_ServiceDesc is the grpc.ServiceDesc for Starquery service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Starquery_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "starquery.v1.Starquery",
+	HandlerType: (*StarqueryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "IsStargazer",
+			Handler:    _Starquery_IsStargazer_Handler,
+		},
+		{
+			MethodName: "AreStargazers",
+			Handler:    _Starquery_AreStargazers_Handler,
+		},
+		{
+			MethodName: "Count",
+			Handler:    _Starquery_Count_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "starquery.proto",
+}
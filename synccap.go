@@ -0,0 +1,64 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coder/starquery/kv"
+)
+
+// errSyncCapped is returned instead of fetching more pages for a repo
+// that has stored MaxStargazersPerSync stargazers this sync.
+var errSyncCapped = errors.New("repo stored the maximum stargazers per sync, resuming next sync")
+
+// syncCursorKey returns the storage key holding the cursor a capped
+// sync of the repo stopped at. Cursors differ between fetchers, so
+// each has its own key.
+func (a *API) syncCursorKey(repo Repo, fetcher string) string {
+	return a.keyPrefix + ":cursor:" + fetcher + ":" + repo.lower().String()
+}
+
+// capped wraps fetchPage so a sync stops once MaxStargazersPerSync
+// stargazers have been fetched, persisting the cursor it stopped at.
+// The next sync resumes from that cursor rather than the first page.
+// A new wrapper must be used for each sync.
+func (a *API) capped(fetcher string, fetchPage pageFetcher) pageFetcher {
+	if a.syncCap <= 0 {
+		return fetchPage
+	}
+	var fetched int
+	var resumed bool
+	return func(ctx context.Context, repo Repo, cursor string) ([]Stargazer, time.Time, int, error) {
+		key := a.syncCursorKey(repo, fetcher)
+		if cursor == "" {
+			saved, err := a.kv.Get(ctx, key)
+			switch {
+			case err == nil:
+				cursor, resumed = saved, true
+			case !errors.Is(err, kv.ErrNotFound):
+				return nil, time.Time{}, 0, fmt.Errorf("get sync cursor: %w", err)
+			}
+		}
+		if fetched >= a.syncCap {
+			if err := a.kv.Setex(ctx, uint(a.ttl.Seconds()), [][2]string{{key, cursor}}); err != nil {
+				return nil, time.Time{}, 0, fmt.Errorf("store sync cursor: %w", err)
+			}
+			return nil, time.Time{}, 0, errSyncCapped
+		}
+
+		stargazers, resetTime, remaining, err := fetchPage(ctx, repo, cursor)
+		if err != nil {
+			return nil, time.Time{}, 0, err
+		}
+		fetched += len(stargazers)
+		if len(stargazers) == 0 && resumed {
+			// The resumed sync reached the last page.
+			if err := a.kv.Delete(ctx, key); err != nil {
+				return nil, time.Time{}, 0, fmt.Errorf("delete sync cursor: %w", err)
+			}
+		}
+		return stargazers, resetTime, remaining, nil
+	}
+}
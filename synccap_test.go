@@ -0,0 +1,152 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxStargazersPerSync(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := kv.NewMemoryWithClock(clk)
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+
+	var mu sync.Mutex
+	var afters []string
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				var body struct {
+					Query     string `json:"query"`
+					Variables struct {
+						After string `json:"after"`
+					} `json:"variables"`
+				}
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					return nil, err
+				}
+				edges := "[]"
+				if strings.Contains(body.Query, "stargazers(") {
+					mu.Lock()
+					afters = append(afters, body.Variables.After)
+					mu.Unlock()
+					// The repo has 5 stargazers, one per page.
+					n, _ := strconv.Atoi(strings.TrimPrefix(body.Variables.After, "c"))
+					if n < 5 {
+						edges = fmt.Sprintf(`[{"node": {"login": "user%d"}, "cursor": "c%d"}]`, n+1, n+1)
+					}
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body: io.NopCloser(strings.NewReader(fmt.Sprintf(
+						`{"data": {"repository": {"stargazers": {"edges": %s}, "stargazerCount": 5}, "rateLimit": {"remaining": 5000}}}`, edges))),
+				}, nil
+			}),
+		},
+		KV:                   store,
+		Repos:                []starquery.Repo{repo},
+		Clock:                clk,
+		MaxStargazersPerSync: 2,
+	})
+	defer api.Close()
+
+	requests := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), afters...)
+	}
+	savedCursor := func() string {
+		value, _ := store.Get(context.Background(), "stargazers:cursor:graphql:coder/coder")
+		return value
+	}
+
+	// The first sync stops after 2 stargazers and persists its cursor.
+	require.Eventually(t, func() bool {
+		return savedCursor() == "c2"
+	}, 5*time.Second, time.Millisecond)
+	require.Equal(t, []string{"", "c1"}, requests())
+	_, err := store.Get(context.Background(), repo.Key("user2"))
+	require.NoError(t, err)
+	_, err = store.Get(context.Background(), repo.Key("user3"))
+	require.ErrorIs(t, err, kv.ErrNotFound)
+
+	// The next sync resumes from it.
+	clk.Advance(starquery.DefaultFetchInterval)
+	require.Eventually(t, func() bool {
+		return savedCursor() == "c4"
+	}, 5*time.Second, time.Millisecond)
+	require.Equal(t, []string{"", "c1", "c2", "c3"}, requests())
+
+	// Once a sync reaches the last page, the cursor is cleared.
+	clk.Advance(starquery.DefaultFetchInterval)
+	require.Eventually(t, func() bool {
+		return len(requests()) == 6
+	}, 5*time.Second, time.Millisecond)
+	require.Equal(t, []string{"", "c1", "c2", "c3", "c4", "c5"}, requests())
+	require.Eventually(t, func() bool {
+		return savedCursor() == ""
+	}, 5*time.Second, time.Millisecond)
+	_, err = store.Get(context.Background(), repo.Key("user5"))
+	require.NoError(t, err)
+}
+
+// TestMaxStargazersPerSyncRelations verifies that a capped star sync
+// still syncs the repo's other relations.
+func TestMaxStargazersPerSyncRelations(t *testing.T) {
+	t.Parallel()
+
+	store := kv.NewMemory()
+	api := starquery.New(context.Background(), starquery.Options{
+		Client: &http.Client{
+			Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+				var body struct {
+					Query     string `json:"query"`
+					Variables struct {
+						After string `json:"after"`
+					} `json:"variables"`
+				}
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					return nil, err
+				}
+				// Every page of stargazers has another after it.
+				response := fmt.Sprintf(`{"data": {"repository": {"stargazers": {"edges": [{"node": {"login": "user%[1]s"}, "cursor": "c%[1]s"}]}}, "rateLimit": {"remaining": 5000}}}`, body.Variables.After+"1")
+				if strings.Contains(body.Query, "forks(") {
+					response = `{"data": {"repository": {"forks": {"edges": []}}, "rateLimit": {"remaining": 5000}}}`
+					if body.Variables.After == "" {
+						response = `{"data": {"repository": {"forks": {"edges": [
+							{"node": {"owner": {"login": "forker1"}, "createdAt": "2024-01-01T00:00:00Z"}, "cursor": "c1"}
+						]}}, "rateLimit": {"remaining": 5000}}}`
+					}
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(response)),
+				}, nil
+			}),
+		},
+		KV:                   store,
+		Repos:                []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		MaxStargazersPerSync: 1,
+		TrackForks:           true,
+	})
+	defer api.Close()
+
+	require.Eventually(t, func() bool {
+		_, err := store.Get(context.Background(), "forkers:coder/coder/forker1")
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+}
@@ -0,0 +1,71 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+// slowStore is a kv.Store whose Get blocks until its context is done.
+type slowStore struct {
+	kv.Store
+}
+
+func (s slowStore) Get(ctx context.Context, _ string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestTimeouts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("GraphQL", func(t *testing.T) {
+		t.Parallel()
+		deadlines := make(chan time.Duration, 1)
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					start := time.Now()
+					<-req.Context().Done()
+					select {
+					case deadlines <- time.Since(start):
+					default:
+					}
+					return nil, req.Context().Err()
+				}),
+			},
+			KV:             kv.NewMemory(),
+			Repos:          []starquery.Repo{{Owner: "coder", Name: "coder"}},
+			GraphQLTimeout: 10 * time.Millisecond,
+			StoreTimeout:   time.Hour,
+		})
+		defer api.Close()
+		select {
+		case waited := <-deadlines:
+			require.Less(t, waited, 5*time.Second)
+		case <-time.After(10 * time.Second):
+			t.Fatal("GraphQL request wasn't timed out")
+		}
+	})
+
+	t.Run("Store", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:             slowStore{Store: kv.NewMemory()},
+			StoreTimeout:   10 * time.Millisecond,
+			GraphQLTimeout: time.Hour,
+		})
+		defer api.Close()
+		start := time.Now()
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/coder/coder/user/kylecarbs", nil))
+		require.Equal(t, http.StatusServiceUnavailable, res.Code)
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+}
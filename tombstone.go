@@ -0,0 +1,99 @@
+package starquery
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// unstarTombstoneTTL is how long an unstar, received by webhook or
+// found by a fresh check, keeps stargazers fetched from GitHub before it
+// from being stored again. It covers pages fetched before the unstar but
+// stored after it.
+const unstarTombstoneTTL = 10 * time.Minute
+
+// tombstoneKey returns the key recording when the user was last seen to
+// unstar the repo.
+func (a *API) tombstoneKey(repo Repo, username string) string {
+	return a.userKey(a.keyPrefix+":unstarred", repo, username)
+}
+
+// storeTombstone records that the user unstarred the repo now. It must
+// be written before the stargazer is deleted, so that storeFetched sees
+// it whenever the delete may have missed a fetched write.
+func (a *API) storeTombstone(ctx context.Context, repo Repo, username string) error {
+	value := strconv.FormatInt(a.clock.Now().UnixNano(), 10)
+	return a.kv.Setex(ctx, uint(unstarTombstoneTTL.Seconds()), [][2]string{{a.tombstoneKey(repo, username), value}})
+}
+
+// unstar removes a stargazer who unstarred the repo, writing the
+// tombstone first so that in-flight fetches don't store them again.
+func (a *API) unstar(ctx context.Context, repo Repo, username string, id int64) error {
+	if err := a.storeTombstone(ctx, repo, username); err != nil {
+		return err
+	}
+	return a.deleteStargazer(ctx, repo, username, id)
+}
+
+// unstarredSince reports, for each user, whether they unstarred the
+// repo at or after t. Ties go to the unstar.
+func (a *API) unstarredSince(ctx context.Context, repo Repo, users []Stargazer, t time.Time) ([]bool, error) {
+	keys := make([]string, len(users))
+	for i, s := range users {
+		keys[i] = a.tombstoneKey(repo, s.Login)
+	}
+	values, err := a.kv.MGet(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	unstarred := make([]bool, len(users))
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		nanos, err := strconv.ParseInt(value, 10, 64)
+		unstarred[i] = err != nil || nanos >= t.UnixNano()
+	}
+	return unstarred, nil
+}
+
+// storeFetched is storeRelation for stargazers fetched from GitHub at
+// fetchedAt. Stargazers who unstarred since then are skipped, and if an
+// unstar lands between that check and the write, its stargazer is
+// deleted again, so the unstar always wins.
+func (a *API) storeFetched(ctx context.Context, rel relation, repo Repo, users []Stargazer, fetchedAt time.Time) error {
+	if rel != stars || len(users) == 0 {
+		return a.storeRelation(ctx, rel, repo, users)
+	}
+	unstarred, err := a.unstarredSince(ctx, repo, users, fetchedAt)
+	if err != nil {
+		return fmt.Errorf("get tombstones: %w", err)
+	}
+	kept := make([]Stargazer, 0, len(users))
+	for i, s := range users {
+		if unstarred[i] {
+			a.logger.DebugContext(ctx, "skipping stargazer unstarred since fetch", "repo", repo, "user", s.Login)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	if err := a.storeRelation(ctx, rel, repo, kept); err != nil {
+		return err
+	}
+
+	unstarred, err = a.unstarredSince(ctx, repo, kept, fetchedAt)
+	if err != nil {
+		return fmt.Errorf("get tombstones: %w", err)
+	}
+	for i, s := range kept {
+		if !unstarred[i] {
+			continue
+		}
+		a.logger.DebugContext(ctx, "removing stargazer unstarred while storing", "repo", repo, "user", s.Login)
+		if err := a.deleteStargazer(ctx, repo, s.Login, s.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
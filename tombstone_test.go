@@ -0,0 +1,136 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+// setexHookStore is a kv.Store that calls hook before every Setex.
+type setexHookStore struct {
+	kv.Store
+	hook func(pairs [][2]string)
+}
+
+func (s *setexHookStore) Setex(ctx context.Context, seconds uint, pairs [][2]string) error {
+	s.hook(pairs)
+	return s.Store.Setex(ctx, seconds, pairs)
+}
+
+func TestUnstarDuringSync(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	// newAPI returns an API whose first stargazers page lists kylecarbs
+	// once release is closed, with channels closed once that page has
+	// been requested and once it has been stored.
+	newAPI := func(t *testing.T, store kv.Store, release <-chan struct{}) (*starquery.API, <-chan struct{}, <-chan struct{}) {
+		requested := make(chan struct{})
+		stored := make(chan struct{})
+		var once sync.Once
+		api := starquery.New(context.Background(), starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					var body struct {
+						Query     string `json:"query"`
+						Variables struct {
+							After string `json:"after"`
+						} `json:"variables"`
+					}
+					if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+						return nil, err
+					}
+					edges := "[]"
+					if strings.Contains(body.Query, "stargazers(") {
+						if body.Variables.After == "" {
+							close(requested)
+							<-release
+							edges = `[{"node": {"login": "kylecarbs"}, "cursor": "c1"}]`
+						} else {
+							// Pages are stored before the next is fetched.
+							once.Do(func() { close(stored) })
+						}
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(strings.NewReader(`{"data": {"repository": {"stargazers": {"edges": ` + edges + `}}, "rateLimit": {"remaining": 5000}}}`)),
+					}, nil
+				}),
+			},
+			KV:            store,
+			Repos:         []starquery.Repo{repo},
+			WebhookSecret: "secret",
+		})
+		t.Cleanup(api.Close)
+		return api, requested, stored
+	}
+	webhook := func(t *testing.T, api *starquery.API, action string) int {
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", action)))
+		return res.Code
+	}
+
+	t.Run("UnstarAfterFetch", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		api, requested, stored := newAPI(t, kv.NewMemory(), release)
+
+		// The page is fetched with the star, then the unstar arrives
+		// before it's stored.
+		<-requested
+		require.Equal(t, http.StatusOK, webhook(t, api, "created"))
+		require.Equal(t, http.StatusOK, webhook(t, api, "deleted"))
+		close(release)
+		select {
+		case <-stored:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the page wasn't stored")
+		}
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs").Code)
+
+		// Stars after the unstar are stored as usual.
+		require.Equal(t, http.StatusOK, webhook(t, api, "created"))
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs").Code)
+	})
+
+	t.Run("UnstarDuringWrite", func(t *testing.T) {
+		t.Parallel()
+		var (
+			api       *starquery.API
+			unstarred atomic.Bool
+			code      atomic.Int64
+		)
+		// The unstar arrives after the page was checked for unstars,
+		// just before it's written.
+		store := &setexHookStore{Store: kv.NewMemory()}
+		store.hook = func(pairs [][2]string) {
+			isStargazer := func(pair [2]string) bool { return pair[0] == repo.Key("kylecarbs") }
+			if slices.ContainsFunc(pairs, isStargazer) && unstarred.CompareAndSwap(false, true) {
+				code.Store(int64(webhook(t, api, "deleted")))
+			}
+		}
+		release := make(chan struct{})
+		api, _, stored := newAPI(t, store, release)
+		close(release)
+		select {
+		case <-stored:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the page wasn't stored")
+		}
+		require.True(t, unstarred.Load())
+		require.EqualValues(t, http.StatusOK, code.Load())
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/kylecarbs").Code)
+	})
+}
@@ -0,0 +1,85 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/coder/starquery/kv"
+)
+
+// userIDKey returns the storage key for the repo's stargazer with the
+// numeric GitHub user ID. Unlike logins, IDs survive renames.
+func (a *API) userIDKey(repo Repo, id int64) string {
+	return a.userKey(a.keyPrefix+":id", repo, strconv.FormatInt(id, 10))
+}
+
+// withUserID appends the stargazer's user ID to the value stored under
+// their login, so the ID key can be found when only the login is known,
+// e.g. to delete it with the stargazer. Logins never contain a colon.
+func withUserID(value string, id int64) string {
+	return value + ":" + strconv.FormatInt(id, 10)
+}
+
+// splitUserID is the inverse of withUserID. The ID is zero for values
+// stored without one.
+func splitUserID(value string) (string, int64) {
+	rest, raw, ok := strings.Cut(value, ":")
+	if !ok {
+		return value, 0
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return value, 0
+	}
+	return rest, id
+}
+
+// deleteStargazer deletes the repo's stored stargazer and, with
+// StoreUserIDs, their ID key. If id is zero, it's read from the value
+// stored under the login.
+func (a *API) deleteStargazer(ctx context.Context, repo Repo, username string, id int64) error {
+	if a.storeUserIDs && id == 0 {
+		value, err := a.kv.Get(ctx, a.key(repo, username))
+		switch {
+		case err == nil:
+			_, id = splitUserID(value)
+		case !errors.Is(err, kv.ErrNotFound):
+			return err
+		}
+	}
+	if err := a.kv.Delete(ctx, a.key(repo, username)); err != nil {
+		return err
+	}
+	if a.storeUserIDs && id != 0 {
+		return a.kv.Delete(ctx, a.userIDKey(repo, id))
+	}
+	return nil
+}
+
+// handleUserID responds like the stars query, but looks the stargazer
+// up by their numeric user ID. Stars are only stored by ID when it was
+// known, so there's no read-through for misses.
+func (a *API) handleUserID(w http.ResponseWriter, r *http.Request) {
+	repo := Repo{Owner: r.PathValue("org"), Name: r.PathValue("repo")}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.storeTimeout)
+	defer cancel()
+	value, err := a.kv.Get(ctx, a.userIDKey(repo, id))
+	if errors.Is(err, kv.ErrNotFound) {
+		a.writeNotFound(w, r, repo)
+		return
+	}
+	if err != nil {
+		a.writeStoreError(w, r, err)
+		return
+	}
+	a.writeFoundLogin(w, r, value, "")
+}
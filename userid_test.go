@@ -0,0 +1,99 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserIDs(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+
+	t.Run("Sync", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		api := starquery.New(ctx, starquery.Options{
+			Client: &http.Client{
+				Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+					var body struct {
+						Query     string `json:"query"`
+						Variables struct {
+							After string `json:"after"`
+						} `json:"variables"`
+					}
+					if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+						return nil, err
+					}
+					edges := "[]"
+					if strings.Contains(body.Query, "databaseId") && body.Variables.After == "" {
+						edges = `[{"node": {"login": "kylecarbs", "databaseId": 7122116}, "cursor": "c1"}]`
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(strings.NewReader(`{"data": {"repository": {"stargazers": {"edges": ` + edges + `}}, "rateLimit": {"remaining": 5000}}}`)),
+					}, nil
+				}),
+			},
+			KV:           kv.NewMemory(),
+			Repos:        []starquery.Repo{repo},
+			StoreUserIDs: true,
+		})
+		defer api.Close()
+
+		require.Eventually(t, func() bool {
+			return query(api, "/coder/coder/user-id/7122116").Code == http.StatusOK
+		}, 5*time.Second, time.Millisecond)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user-id/1").Code)
+		require.Equal(t, http.StatusBadRequest, query(api, "/coder/coder/user-id/kylecarbs").Code)
+		require.Equal(t, http.StatusBadRequest, query(api, "/coder/coder/user-id/0").Code)
+
+		// The login is still stored too.
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/kylecarbs").Code)
+	})
+
+	t.Run("Webhook", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		store := kv.NewMemory()
+		api := starquery.New(ctx, starquery.Options{
+			KV:            store,
+			WebhookSecret: "secret",
+			StoreUserIDs:  true,
+		})
+		defer api.Close()
+
+		event := generateEvent(repo, "kylecarbs", "created")
+		id := int64(7122116)
+		event.Sender.ID = &id
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", event))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, http.StatusOK, query(api, "/coder/coder/user-id/7122116").Code)
+
+		// A renamed user unstarring is still removed by ID.
+		event = generateEvent(repo, "kylecarbs-renamed", "deleted")
+		event.Sender.ID = &id
+		res = httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", event))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user-id/7122116").Code)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory()})
+		defer api.Close()
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user-id/7122116").Code)
+	})
+}
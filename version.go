@@ -0,0 +1,56 @@
+package starquery
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+)
+
+// BuildInfo identifies the running build, as served by GET /version.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+}
+
+// withDefaults fills in unset fields from the build info embedded by
+// the Go toolchain, falling back to "dev" for the version.
+func (b BuildInfo) withDefaults() BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		info = &debug.BuildInfo{}
+	}
+	if b.Version == "" {
+		b.Version = moduleVersion(info)
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && b.Commit == "":
+			b.Commit = setting.Value
+		case setting.Key == "vcs.time" && b.BuildTime == "":
+			b.BuildTime = setting.Value
+		}
+	}
+	return b
+}
+
+// moduleVersion returns the version of the starquery module in the
+// build, or "dev" if it was built from a checkout.
+func moduleVersion(info *debug.BuildInfo) string {
+	version := "dev"
+	if info.Main.Path == "github.com/coder/starquery" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/coder/starquery" {
+			version = dep.Version
+		}
+	}
+	return version
+}
+
+// handleVersion responds with the build info.
+func (a *API) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.build)
+}
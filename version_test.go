@@ -0,0 +1,42 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Build", func(t *testing.T) {
+		t.Parallel()
+		build := starquery.BuildInfo{Version: "v1.2.3", Commit: "abc123", BuildTime: "2024-01-01T00:00:00Z"}
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory(), Build: build})
+		defer api.Close()
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/version", nil))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.JSONEq(t, `{"version":"v1.2.3","commit":"abc123","buildTime":"2024-01-01T00:00:00Z"}`, res.Body.String())
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		api := starquery.New(context.Background(), starquery.Options{KV: kv.NewMemory()})
+		defer api.Close()
+
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/version", nil))
+		require.Equal(t, http.StatusOK, res.Code)
+		var build starquery.BuildInfo
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&build))
+		require.NotEmpty(t, build.Version)
+	})
+}
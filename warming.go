@@ -0,0 +1,101 @@
+package starquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coder/starquery/kv"
+)
+
+// syncedKey returns the storage key marking that the repo has been
+// fully synced. It's shared by every instance using the store, and
+// expires with the stargazers it vouches for.
+func (a *API) syncedKey(repo Repo) string {
+	return a.keyPrefix + ":synced:" + repo.lower().String()
+}
+
+// markSynced records that the repo has been fully synced, and when.
+func (a *API) markSynced(ctx context.Context, repo Repo) error {
+	synced := a.clock.Now().UTC().Format(time.RFC3339)
+	return a.kv.Setex(ctx, uint(a.ttl.Seconds()), [][2]string{{a.syncedKey(repo), synced}})
+}
+
+// warming reports whether the repo is tracked but hasn't been fully
+// synced yet, so a missing stargazer may just not be fetched yet.
+func (a *API) warming(ctx context.Context, repo Repo) (bool, error) {
+	if !a.tracks(repo) {
+		return false, nil
+	}
+	_, err := a.kv.Get(ctx, a.syncedKey(repo))
+	if errors.Is(err, kv.ErrNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// warmingRepos returns the tracked repos that haven't been fully
+// synced yet. Once none are left it returns none without checking, so
+// repos added or expiring later don't make a warm instance cold again.
+func (a *API) warmingRepos(ctx context.Context) ([]string, error) {
+	if a.warm.Load() {
+		return nil, nil
+	}
+	unsynced, err := a.unsyncedRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	warming := make([]string, len(unsynced))
+	for i, repo := range unsynced {
+		warming[i] = repo.String()
+	}
+	if len(warming) == 0 {
+		a.warm.Store(true)
+	}
+	return warming, nil
+}
+
+// unsyncedRepos returns the tracked repos that haven't been fully synced
+// by any instance sharing the store.
+func (a *API) unsyncedRepos(ctx context.Context) ([]Repo, error) {
+	repos := a.Repos()
+	if len(repos) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(repos))
+	for i, repo := range repos {
+		keys[i] = a.syncedKey(repo)
+	}
+	values, err := a.kv.MGet(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("get synced repos: %w", err)
+	}
+	var unsynced []Repo
+	for i, value := range values {
+		if value == "" {
+			unsynced = append(unsynced, repos[i])
+		}
+	}
+	return unsynced, nil
+}
+
+// writeNotFound responds to a query for a stargazer that isn't stored.
+// With WarmingUnavailable, it responds with 503 instead of 404 while
+// the repo is warming.
+func (a *API) writeNotFound(w http.ResponseWriter, r *http.Request, repo Repo) {
+	if a.warmingUnavailable {
+		warming, err := a.warming(r.Context(), repo)
+		if err != nil {
+			a.writeStoreError(w, r, err)
+			return
+		}
+		if warming {
+			w.Header().Set("Retry-After", retryAfterSeconds)
+			http.Error(w, "Warming up, stargazers haven't been fetched yet", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	a.writeQueryNotFound(w, r)
+}
@@ -0,0 +1,89 @@
+package starquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmingUnavailable(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	gh := &fakeGitHub{
+		edges:   map[string]string{"stargazers": `[{"node": {"login": "user1"}, "cursor": "cursor1"}]`},
+		release: release,
+	}
+	api := starquery.New(context.Background(), starquery.Options{
+		Client:             gh.client(),
+		KV:                 kv.NewMemory(),
+		Repos:              []starquery.Repo{{Owner: "coder", Name: "coder"}},
+		WarmingUnavailable: true,
+	})
+	defer api.Close()
+
+	res := query(api, "/coder/coder/user/kylecarbs")
+	require.Equal(t, http.StatusServiceUnavailable, res.Code, "tracked repo is warming")
+	require.NotEmpty(t, res.Header().Get("Retry-After"))
+	require.Equal(t, http.StatusNotFound, query(api, "/coder/other/user/kylecarbs").Code, "untracked repos never warm")
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return query(api, "/coder/coder/user/kylecarbs").Code == http.StatusNotFound
+	}, time.Second, time.Millisecond)
+	require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/user1").Code)
+}
+
+func TestRequireFirstSync(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	store := kv.NewMemory()
+	repos := []starquery.Repo{{Owner: "coder", Name: "coder"}}
+	gh := &fakeGitHub{release: release}
+	api := starquery.New(context.Background(), starquery.Options{
+		Client:           gh.client(),
+		KV:               store,
+		Repos:            repos,
+		RequireFirstSync: true,
+	})
+	defer api.Close()
+
+	health := func(api *starquery.API) (int, map[string]any) {
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+		return res.Code, body
+	}
+
+	code, body := health(api)
+	require.Equal(t, http.StatusServiceUnavailable, code, "cold")
+	require.Equal(t, "warming", body["status"])
+	require.Equal(t, []any{"coder/coder"}, body["warming"])
+
+	close(release)
+	require.Eventually(t, func() bool {
+		code, _ := health(api)
+		return code == http.StatusOK
+	}, time.Second, time.Millisecond)
+
+	// Another replica sharing the store is warm without syncing.
+	replica := starquery.New(context.Background(), starquery.Options{
+		KV:               store,
+		Repos:            repos,
+		RequireFirstSync: true,
+		DisableFetchLoop: true,
+	})
+	defer replica.Close()
+	code, body = health(replica)
+	require.Equal(t, http.StatusOK, code, "warm")
+	require.NotContains(t, body, "warming")
+}
@@ -0,0 +1,82 @@
+package starquery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coder/starquery/kv"
+	"github.com/google/go-github/v52/github"
+)
+
+// staleWebhookError is returned by checkWebhookAge for deliveries older
+// than WebhookMaxAge.
+type staleWebhookError struct {
+	age, limit time.Duration
+}
+
+func (e *staleWebhookError) Error() string {
+	return fmt.Sprintf("delivery is %s old, more than %s", e.age.Round(time.Second), e.limit)
+}
+
+// deliveryKey returns the storage key holding when the delivery was
+// first seen.
+func (a *API) deliveryKey(deliveryID string) string {
+	return a.keyPrefix + ":delivery:" + deliveryID
+}
+
+// checkWebhookAge returns a staleWebhookError if the delivery is older
+// than WebhookMaxAge plus WebhookClockSkew. Stars are dated by their
+// starred_at, and other deliveries by when their ID was first seen,
+// which is recorded for twice as long as they're accepted. Deliveries
+// without either are accepted.
+func (a *API) checkWebhookAge(r *http.Request, payload []byte) error {
+	if a.webhookMaxAge <= 0 {
+		return nil
+	}
+	limit := a.webhookMaxAge + a.webhookClockSkew
+	now := a.clock.Now()
+	if github.WebHookType(r) == "star" {
+		var event struct {
+			StarredAt *time.Time `json:"starred_at"`
+		}
+		// Payloads that don't decode are rejected when parsed.
+		if json.Unmarshal(payload, &event) == nil && event.StarredAt != nil {
+			if age := now.Sub(*event.StarredAt); age > limit {
+				return &staleWebhookError{age: age, limit: limit}
+			}
+			return nil
+		}
+	}
+
+	deliveryID := github.DeliveryID(r)
+	if deliveryID == "" {
+		return nil
+	}
+	key := a.deliveryKey(deliveryID)
+	value, err := a.kv.Get(r.Context(), key)
+	if errors.Is(err, kv.ErrNotFound) {
+		if a.readOnly.Load() {
+			return nil
+		}
+		pairs := [][2]string{{key, strconv.FormatInt(now.UnixNano(), 10)}}
+		if err := a.kv.Setex(r.Context(), uint(max(2*limit, time.Second).Seconds()), pairs); err != nil {
+			return fmt.Errorf("store delivery: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get delivery: %w", err)
+	}
+	firstSeen, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse delivery first seen %q: %w", value, err)
+	}
+	if age := now.Sub(time.Unix(0, firstSeen)); age > limit {
+		return &staleWebhookError{age: age, limit: limit}
+	}
+	return nil
+}
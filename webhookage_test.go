@@ -0,0 +1,83 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/clock"
+	"github.com/coder/starquery/kv"
+	"github.com/google/go-github/v52/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookMaxAge(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	const (
+		maxAge = time.Hour
+		skew   = time.Minute
+	)
+	newAPI := func(t *testing.T, clk clock.Clock, maxAge time.Duration) *starquery.API {
+		api := starquery.New(context.Background(), starquery.Options{
+			KV:               kv.NewMemory(),
+			WebhookSecret:    "secret",
+			Clock:            clk,
+			WebhookMaxAge:    maxAge,
+			WebhookClockSkew: skew,
+			DisableFetchLoop: true,
+		})
+		t.Cleanup(api.Close)
+		return api
+	}
+	star := func(t *testing.T, api *starquery.API, starredAt time.Time) int {
+		event := generateEvent(repo, "kylecarbs", "created")
+		event.StarredAt = &github.Timestamp{Time: starredAt}
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, generateWebhook(t, "secret", event))
+		return res.Code
+	}
+
+	t.Run("StarredAt", func(t *testing.T) {
+		t.Parallel()
+		now := time.Now().Truncate(time.Second)
+		api := newAPI(t, clock.NewFake(now), maxAge)
+
+		// GitHub's clock may be behind ours by up to the skew.
+		require.Equal(t, http.StatusOK, star(t, api, now.Add(-maxAge-skew)))
+		require.Equal(t, http.StatusBadRequest, star(t, api, now.Add(-maxAge-skew-time.Second)))
+		// Or ahead of it.
+		require.Equal(t, http.StatusOK, star(t, api, now.Add(skew)))
+	})
+
+	t.Run("FirstSeen", func(t *testing.T) {
+		t.Parallel()
+		clk := clock.NewFake(time.Now())
+		api := newAPI(t, clk, maxAge)
+		unstar := func(deliveryID string) int {
+			req := generateWebhook(t, "secret", generateEvent(repo, "kylecarbs", "deleted"))
+			req.Header.Set("X-GitHub-Delivery", deliveryID)
+			res := httptest.NewRecorder()
+			api.ServeHTTP(res, req)
+			return res.Code
+		}
+
+		require.Equal(t, http.StatusOK, unstar("delivery-1"))
+		clk.Advance(maxAge + skew)
+		require.Equal(t, http.StatusOK, unstar("delivery-1"), "replayed at the limit")
+		clk.Advance(time.Second)
+		require.Equal(t, http.StatusBadRequest, unstar("delivery-1"), "replayed past the limit")
+		require.Equal(t, http.StatusOK, unstar("delivery-2"), "new delivery")
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		now := time.Now()
+		api := newAPI(t, clock.NewFake(now), 0)
+		require.Equal(t, http.StatusOK, star(t, api, now.Add(-30*24*time.Hour)))
+	})
+}
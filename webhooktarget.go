@@ -0,0 +1,49 @@
+package starquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/google/go-github/v52/github"
+)
+
+const (
+	// HookTargetTypeHeader and HookTargetIDHeader identify what a
+	// webhook belongs to, e.g. "repository" and the repo's ID, or
+	// "integration" and the GitHub App's ID.
+	HookTargetTypeHeader = "X-GitHub-Hook-Installation-Target-Type"
+	HookTargetIDHeader   = "X-GitHub-Hook-Installation-Target-ID"
+)
+
+// checkWebhookTarget returns an error if the webhook wasn't delivered
+// for the configured target and installation. Pings are exempt from
+// the installation check, as GitHub Apps send them without one.
+func (a *API) checkWebhookTarget(r *http.Request, payload []byte) error {
+	if a.webhookTargetType != "" {
+		if got := r.Header.Get(HookTargetTypeHeader); got != a.webhookTargetType {
+			return fmt.Errorf("target type %q, want %q", got, a.webhookTargetType)
+		}
+	}
+	if a.webhookTargetID != 0 {
+		got, err := strconv.ParseInt(r.Header.Get(HookTargetIDHeader), 10, 64)
+		if err != nil || got != a.webhookTargetID {
+			return fmt.Errorf("target id %q, want %d", r.Header.Get(HookTargetIDHeader), a.webhookTargetID)
+		}
+	}
+	if a.webhookInstallationID != 0 && github.WebHookType(r) != "ping" {
+		var event struct {
+			Installation struct {
+				ID int64 `json:"id"`
+			} `json:"installation"`
+		}
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return fmt.Errorf("decode installation: %w", err)
+		}
+		if event.Installation.ID != a.webhookInstallationID {
+			return fmt.Errorf("installation %d, want %d", event.Installation.ID, a.webhookInstallationID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package starquery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coder/starquery"
+	"github.com/coder/starquery/kv"
+	"github.com/google/go-github/v52/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookTarget(t *testing.T) {
+	t.Parallel()
+
+	repo := starquery.Repo{Owner: "coder", Name: "coder"}
+	api := starquery.New(context.Background(), starquery.Options{
+		KV:                    kv.NewMemory(),
+		WebhookSecret:         "secret",
+		WebhookTargetType:     "integration",
+		WebhookTargetID:       1234,
+		WebhookInstallationID: 5678,
+	})
+	defer api.Close()
+
+	deliver := func(username, targetType, targetID string, installation int64) int {
+		event := generateEvent(repo, username, "created")
+		if installation != 0 {
+			event.Installation = &github.Installation{ID: &installation}
+		}
+		req := generateWebhook(t, "secret", event)
+		req.Header.Set(starquery.HookTargetTypeHeader, targetType)
+		req.Header.Set(starquery.HookTargetIDHeader, targetID)
+		res := httptest.NewRecorder()
+		api.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	require.Equal(t, http.StatusOK, deliver("match", "integration", "1234", 5678))
+	require.Equal(t, http.StatusOK, query(api, "/coder/coder/user/match").Code)
+
+	for name, code := range map[string]int{
+		"type":         deliver("type", "repository", "1234", 5678),
+		"id":           deliver("id", "integration", "4321", 5678),
+		"installation": deliver("installation", "integration", "1234", 8765),
+		"missing":      deliver("missing", "integration", "1234", 0),
+	} {
+		require.Equal(t, http.StatusForbidden, code, name)
+		require.Equal(t, http.StatusNotFound, query(api, "/coder/coder/user/"+name).Code, name)
+	}
+
+	// Apps ping without an installation.
+	req := generateEventWebhook(t, "secret", "ping", github.PingEvent{})
+	req.Header.Set(starquery.HookTargetTypeHeader, "integration")
+	req.Header.Set(starquery.HookTargetIDHeader, "1234")
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+}